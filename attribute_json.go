@@ -0,0 +1,147 @@
+package rotor
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// attributeJSON AttributeValue 的 DynamoDB JSON 形式, 例如 {"S":"x"}, {"L":[{"N":"1"}]}
+// 空的 L/M/集合 会保留类型, B/BS 按 base64 编码
+func attributeJSON(av *dynamodb.AttributeValue) (interface{}, error) {
+	if av == nil {
+		return nil, fmt.Errorf("rotor: nil attribute value")
+	}
+	switch {
+	case av.S != nil:
+		return map[string]interface{}{"S": *av.S}, nil
+	case av.N != nil:
+		return map[string]interface{}{"N": *av.N}, nil
+	case av.B != nil:
+		return map[string]interface{}{"B": av.B}, nil
+	case av.BOOL != nil:
+		return map[string]interface{}{"BOOL": *av.BOOL}, nil
+	case av.NULL != nil:
+		return map[string]interface{}{"NULL": *av.NULL}, nil
+	case av.SS != nil:
+		return map[string]interface{}{"SS": aws.StringValueSlice(av.SS)}, nil
+	case av.NS != nil:
+		return map[string]interface{}{"NS": aws.StringValueSlice(av.NS)}, nil
+	case av.BS != nil:
+		return map[string]interface{}{"BS": av.BS}, nil
+	case av.L != nil:
+		l := make([]interface{}, len(av.L))
+		for i, elem := range av.L {
+			v, err := attributeJSON(elem)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = v
+		}
+		return map[string]interface{}{"L": l}, nil
+	case av.M != nil:
+		m, err := attributeMapJSON(av.M)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"M": m}, nil
+	}
+	return nil, fmt.Errorf("rotor: empty attribute value")
+}
+
+func attributeMapJSON(item map[string]*dynamodb.AttributeValue) (map[string]interface{}, error) {
+	m := make(map[string]interface{}, len(item))
+	for name, elem := range item {
+		v, err := attributeJSON(elem)
+		if err != nil {
+			return nil, err
+		}
+		m[name] = v
+	}
+	return m, nil
+}
+
+// marshalAttributeJSON 序列化为 DynamoDB JSON
+func marshalAttributeJSON(av *dynamodb.AttributeValue) ([]byte, error) {
+	v, err := attributeJSON(av)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// unmarshalAttributeJSON 解析 DynamoDB JSON, 每个值必须只有一个类型
+func unmarshalAttributeJSON(data []byte) (*dynamodb.AttributeValue, error) {
+	var typed map[string]json.RawMessage
+	if err := json.Unmarshal(data, &typed); err != nil {
+		return nil, err
+	}
+	if len(typed) != 1 {
+		return nil, fmt.Errorf("rotor: attribute value must have exactly one type, got %d", len(typed))
+	}
+	av := &dynamodb.AttributeValue{}
+	for typ, raw := range typed {
+		var err error
+		switch typ {
+		case "S":
+			err = json.Unmarshal(raw, &av.S)
+		case "N":
+			err = json.Unmarshal(raw, &av.N)
+		case "B":
+			err = json.Unmarshal(raw, &av.B)
+			if err == nil && av.B == nil {
+				av.B = []byte{}
+			}
+		case "BOOL":
+			err = json.Unmarshal(raw, &av.BOOL)
+		case "NULL":
+			err = json.Unmarshal(raw, &av.NULL)
+		case "SS":
+			err = json.Unmarshal(raw, &av.SS)
+		case "NS":
+			err = json.Unmarshal(raw, &av.NS)
+		case "BS":
+			err = json.Unmarshal(raw, &av.BS)
+		case "L":
+			var l []json.RawMessage
+			if err = json.Unmarshal(raw, &l); err != nil {
+				break
+			}
+			av.L = make([]*dynamodb.AttributeValue, len(l))
+			for i, elem := range l {
+				if av.L[i], err = unmarshalAttributeJSON(elem); err != nil {
+					break
+				}
+			}
+		case "M":
+			av.M, err = unmarshalAttributeMapJSON(raw)
+		default:
+			return nil, fmt.Errorf("rotor: unknown attribute type %q", typ)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return av, nil
+}
+
+func unmarshalAttributeMapJSON(data []byte) (map[string]*dynamodb.AttributeValue, error) {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, fmt.Errorf("rotor: attribute map must be an object")
+	}
+	item := make(map[string]*dynamodb.AttributeValue, len(m))
+	for name, raw := range m {
+		av, err := unmarshalAttributeJSON(raw)
+		if err != nil {
+			return nil, err
+		}
+		item[name] = av
+	}
+	return item, nil
+}
@@ -0,0 +1,349 @@
+package rotor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// 转存到 BlobStore 的属性在 item 中保存为指针: {"rotor:blob": key, "rotor:size": 字节数}
+const (
+	blobPointerKey  = "rotor:blob"
+	blobPointerSize = "rotor:size"
+)
+
+// BlobStore 大对象存储, 例如 S3
+type BlobStore interface {
+	PutBlob(ctx context.Context, key string, data []byte) error
+	// GetBlob key 不存在时返回 ErrBlobNotFound
+	GetBlob(ctx context.Context, key string) ([]byte, error)
+	// DeleteBlob key 不存在时不返回错误
+	DeleteBlob(ctx context.Context, key string) error
+}
+
+// FileBlobStore 保存在本地目录的 BlobStore, 用于测试和开发
+type FileBlobStore struct {
+	dir string
+}
+
+// NewFileBlobStore NewFileBlobStore
+func NewFileBlobStore(dir string) (*FileBlobStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileBlobStore{dir: dir}, nil
+}
+
+func (s *FileBlobStore) path(key string) (string, error) {
+	p := filepath.Join(s.dir, filepath.FromSlash(key))
+	if key == "" || !strings.HasPrefix(p, filepath.Clean(s.dir)+string(filepath.Separator)) {
+		return "", ErrInput
+	}
+	return p, nil
+}
+
+// PutBlob PutBlob
+func (s *FileBlobStore) PutBlob(ctx context.Context, key string, data []byte) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(p), ".blob-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+// GetBlob GetBlob
+func (s *FileBlobStore) GetBlob(ctx context.Context, key string) ([]byte, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	return data, err
+}
+
+// DeleteBlob DeleteBlob
+func (s *FileBlobStore) DeleteBlob(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// ServiceBlobStore 将 `rotor:"offload"` 字段转存到 store, item 中只保存指针
+// Put/PutBatch/Upsert/Patch/UpdateDiff/Transact 写入时转存, 写入失败时删除本次转存的对象, 读取时默认加载,
+// 覆盖和删除时清理不再引用的对象; 事务写入拿不到旧 item, 会在写入前一致性读取
+func ServiceBlobStore(store BlobStore) ServiceOption {
+	return func(rs *Service) {
+		rs.blobStore = store
+	}
+}
+
+// offloadFields 结构体中 `rotor:"offload"` 的字段
+func offloadFields(t reflect.Type) []field {
+	t = indirectType(t)
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+	var fields []field
+	for _, f := range structFields(t) {
+		if f.Rotor.Has("offload") {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// blobKey 表/PK/SK/属性名/内容的 sha256, 内容不变时 key 不变
+func (rs *Service) blobKey(item map[string]*dynamodb.AttributeValue, name string, data []byte) string {
+	sum := sha256.Sum256(data)
+	return strings.Join([]string{
+		url.PathEscape(rs.TableName()),
+		url.PathEscape(keyString(item[tablePK])),
+		url.PathEscape(keyString(item[tableSK])),
+		url.PathEscape(name),
+		hex.EncodeToString(sum[:]),
+	}, "/")
+}
+
+func blobPointer(av *dynamodb.AttributeValue) (string, bool) {
+	if av == nil || av.M == nil || len(av.M) != 2 || av.M[blobPointerKey] == nil || av.M[blobPointerKey].S == nil {
+		return "", false
+	}
+	return *av.M[blobPointerKey].S, true
+}
+
+// offloadBlobs 把 in 的转存字段写入 BlobStore 并替换为指针
+func (rs *Service) offloadBlobs(ctx context.Context, t reflect.Type, item map[string]*dynamodb.AttributeValue) error {
+	if rs.blobStore == nil {
+		return nil
+	}
+	for _, f := range offloadFields(t) {
+		if f.Name == tablePK || f.Name == tableSK {
+			return ErrInput
+		}
+		av, ok := item[f.Name]
+		if !ok || av.NULL != nil {
+			continue
+		}
+		data, err := marshalAttributeJSON(av)
+		if err != nil {
+			return err
+		}
+		key := rs.blobKey(item, f.Name, data)
+		if err := rs.blobStore.PutBlob(ctx, key, data); err != nil {
+			return err
+		}
+		item[f.Name] = &dynamodb.AttributeValue{M: map[string]*dynamodb.AttributeValue{
+			blobPointerKey:  {S: aws.String(key)},
+			blobPointerSize: {N: aws.String(strconv.Itoa(len(data)))},
+		}}
+	}
+	return nil
+}
+
+// hydrateBlobs 返回加载了转存属性的 item, skip 时去掉指针属性, 不修改传入的 item
+func (rs *Service) hydrateBlobs(ctx context.Context, item map[string]*dynamodb.AttributeValue, skip bool) (map[string]*dynamodb.AttributeValue, error) {
+	var out map[string]*dynamodb.AttributeValue
+	for name, av := range item {
+		key, ok := blobPointer(av)
+		if !ok {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]*dynamodb.AttributeValue, len(item))
+			for k, v := range item {
+				out[k] = v
+			}
+		}
+		if skip || rs.blobStore == nil {
+			delete(out, name)
+			continue
+		}
+		data, err := rs.blobStore.GetBlob(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if out[name], err = unmarshalAttributeJSON(data); err != nil {
+			return nil, err
+		}
+	}
+	if out == nil {
+		return item, nil
+	}
+	return out, nil
+}
+
+// cleanupBlobs 删除 old 中引用而 current 中不再引用的对象
+// 数据已经写入成功, 清理失败只会留下孤儿对象, 不返回错误
+func (rs *Service) cleanupBlobs(ctx context.Context, old, current map[string]*dynamodb.AttributeValue) {
+	if rs.blobStore == nil {
+		return
+	}
+	keep := map[string]struct{}{}
+	for _, av := range current {
+		if key, ok := blobPointer(av); ok {
+			keep[key] = struct{}{}
+		}
+	}
+	for _, av := range old {
+		key, ok := blobPointer(av)
+		if !ok {
+			continue
+		}
+		if _, ok := keep[key]; ok {
+			continue
+		}
+		_ = rs.blobStore.DeleteBlob(ctx, key)
+	}
+}
+
+// discardBlobs 写入失败后删除 items 转存的对象
+// 对象 key 由内容决定, 当前 item 仍然引用的对象是写入前就存在的, 需要保留;
+// 读取当前 item 失败时不删除, 只会留下孤儿对象
+func (rs *Service) discardBlobs(ctx context.Context, items ...map[string]*dynamodb.AttributeValue) {
+	if rs.blobStore == nil {
+		return
+	}
+	for _, item := range items {
+		if !hasBlobPointer(item) {
+			continue
+		}
+		key, err := itemKey(item, []string{tablePK, tableSK})
+		if err != nil {
+			continue
+		}
+		current, err := rs.getRaw(ctx, key, GetConsistent(true))
+		if err != nil && !errors.Is(err, ErrItemNotFound) {
+			continue
+		}
+		rs.cleanupBlobs(ctx, item, current)
+	}
+}
+
+// currentItems 一致性读取 keys 当前的 item, item 不存在时对应位置为 nil
+// 事务写入拿不到旧 item, 在写入前读取, 写入成功后用来清理不再引用的对象
+func (rs *Service) currentItems(ctx context.Context, keys ...PrimaryKeyType) ([]map[string]*dynamodb.AttributeValue, error) {
+	items := make([]map[string]*dynamodb.AttributeValue, len(keys))
+	for i, key := range keys {
+		item, err := rs.getRaw(ctx, key, GetConsistent(true))
+		if err != nil && !errors.Is(err, ErrItemNotFound) {
+			return nil, err
+		}
+		items[i] = item
+	}
+	return items, nil
+}
+
+// pickAttributes item 中属性 names 组成的 item
+func pickAttributes(item map[string]*dynamodb.AttributeValue, names []string) map[string]*dynamodb.AttributeValue {
+	out := make(map[string]*dynamodb.AttributeValue, len(names))
+	for _, name := range names {
+		if av, ok := item[name]; ok {
+			out[name] = av
+		}
+	}
+	return out
+}
+
+// offloadHook 把 set 中类型 t 的转存字段写入 BlobStore 并替换为指针, remove 为要删除的属性
+type offloadHook func(t reflect.Type, set map[string]*dynamodb.AttributeValue, remove []string) error
+
+// updateOffloaded 执行 build 生成的 update, 类型 t 有转存字段时转存 SET 的字段,
+// 以被更新属性的旧值清理不再引用的对象, 写入失败时删除本次转存的对象
+func (rs *Service) updateOffloaded(ctx context.Context, key PrimaryKeyType, t reflect.Type, build func(hook offloadHook) (expression.UpdateBuilder, bool, error), opts ...UpdateOption) error {
+	if rs.blobStore == nil || len(offloadFields(t)) == 0 {
+		update, changed, err := build(nil)
+		if err != nil || !changed {
+			return err
+		}
+		return rs.Update(ctx, key, update, opts...)
+	}
+	var item map[string]*dynamodb.AttributeValue
+	var touched []string
+	hook := func(t reflect.Type, set map[string]*dynamodb.AttributeValue, remove []string) error {
+		item = make(map[string]*dynamodb.AttributeValue, len(set)+len(key))
+		for name, av := range key {
+			item[name] = av
+		}
+		for name, av := range set {
+			item[name] = av
+		}
+		if err := rs.offloadBlobs(ctx, t, item); err != nil {
+			rs.discardBlobs(ctx, item)
+			return err
+		}
+		for name := range set {
+			set[name] = item[name]
+			touched = append(touched, name)
+		}
+		touched = append(touched, remove...)
+		return nil
+	}
+	update, changed, err := build(hook)
+	if err != nil || !changed {
+		return err
+	}
+	old, err := rs.updateRaw(ctx, key, update, UpdateReturnValueUpdatedOld, false, opts...)
+	if err != nil {
+		rs.discardBlobs(ctx, item)
+		return err
+	}
+	rs.cleanupBlobs(ctx, pickAttributes(old, touched), item)
+	return nil
+}
+
+func hasBlobPointer(item map[string]*dynamodb.AttributeValue) bool {
+	for _, av := range item {
+		if _, ok := blobPointer(av); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadBlobs 读取 item 的转存属性 attrs 到 out, 用于 GetSkipBlobs/QuerySkipBlobs 之后按需加载
+func (rs *Service) LoadBlobs(ctx context.Context, key PrimaryKeyType, out interface{}, attrs ...string) error {
+	if len(attrs) == 0 {
+		return ErrInput
+	}
+	projection := expression.NamesList(expression.Name(tablePK), expression.Name(tableSK))
+	for _, attr := range attrs {
+		projection = projection.AddNames(expression.Name(attr))
+	}
+	return rs.Get(ctx, key, out, GetProjection(projection), GetConsistent(true))
+}
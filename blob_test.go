@@ -0,0 +1,278 @@
+package rotor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+type blobTestSchema struct {
+	BaseSchema
+
+	Name       string
+	Attachment []byte `rotor:"offload"`
+}
+
+func TestBlobOffload(t *testing.T) {
+	store, err := NewFileBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := &Service{codec: NewCodec(), tableName: aws.String("test"), blobStore: store}
+	ctx := context.TODO()
+	in := blobTestSchema{
+		BaseSchema: BaseSchema{PK: "Test#id1", SK: "Test"},
+		Name:       "n",
+		Attachment: bytes.Repeat([]byte{7}, 500*1024),
+	}
+	item, err := rs.marshalItem(ctx, in)
+	if err != nil {
+		t.Fatalf("marshalItem失败: %v", err)
+	}
+	key, ok := blobPointer(item["Attachment"])
+	if !ok {
+		t.Fatalf("marshalItem失败: Attachment 没有转存 %v", item["Attachment"])
+	}
+
+	var out blobTestSchema
+	if err := rs.decodeItem(ctx, item, &out, decodeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Attachment, in.Attachment) || out.Name != "n" {
+		t.Error("decodeItem失败: 不是预期的值")
+	}
+	out = blobTestSchema{}
+	if err := rs.decodeItem(ctx, item, &out, decodeOptions{skipBlobs: true}); err != nil {
+		t.Fatal(err)
+	}
+	if out.Attachment != nil || out.Name != "n" {
+		t.Error("decodeItem失败: skipBlobs 不应该加载")
+	}
+
+	in.Attachment = []byte("changed")
+	updated, err := rs.marshalItem(ctx, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.cleanupBlobs(ctx, item, updated)
+	if _, err := store.GetBlob(ctx, key); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("cleanupBlobs失败: 旧对象没有删除 %v", err)
+	}
+	newKey, _ := blobPointer(updated["Attachment"])
+	if _, err := store.GetBlob(ctx, newKey); err != nil {
+		t.Errorf("cleanupBlobs失败: 不应该删除新对象 %v", err)
+	}
+	if _, err := store.GetBlob(ctx, "../escape"); !errors.Is(err, ErrInput) {
+		t.Errorf("FileBlobStore失败: key 不能离开目录 %v", err)
+	}
+}
+
+func TestBlobDiscard(t *testing.T) {
+	store, err := NewFileBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var current map[string]*dynamodb.AttributeValue
+	rs, fake := newFakeService(func(op string, input interface{}) (interface{}, error) {
+		switch op {
+		case "PutItem", "TransactWriteItems":
+			return nil, fakeError(dynamodb.ErrCodeConditionalCheckFailedException)
+		case "GetItem":
+			return &dynamodb.GetItemOutput{Item: current}, nil
+		case "UpdateItem":
+			return &dynamodb.UpdateItemOutput{Attributes: current}, nil
+		}
+		return nil, errors.New(op)
+	}, ServiceBlobStore(store))
+	ctx := context.TODO()
+	in := blobTestSchema{BaseSchema: BaseSchema{PK: "Test#id1", SK: "Test"}, Attachment: []byte("v1")}
+
+	blobKey := func(item map[string]*dynamodb.AttributeValue) string {
+		key, _ := blobPointer(item["Attachment"])
+		return key
+	}
+	exists := func(key string) bool {
+		_, err := store.GetBlob(ctx, key)
+		return err == nil
+	}
+
+	if err := rs.Put(ctx, in, PutCondition(ConditionItemNotExist())); !errors.Is(err, ErrConditionalCheck) {
+		t.Fatalf("Put失败: 应该返回 ErrConditionalCheck %v", err)
+	}
+	put := fake.calls[0].input.(*dynamodb.PutItemInput)
+	if key := blobKey(put.Item); key == "" || exists(key) {
+		t.Errorf("Put失败: 写入失败时应该删除转存的对象 %s", key)
+	}
+
+	// 当前 item 引用内容相同的对象时保留
+	current, err = rs.marshalItem(ctx, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.PutBatch(ctx, []interface{}{in}, PutCondition(ConditionItemNotExist())); !errors.Is(err, ErrConditionalCheck) {
+		t.Fatalf("PutBatch失败: 应该返回 ErrConditionalCheck %v", err)
+	}
+	if !exists(blobKey(current)) {
+		t.Error("PutBatch失败: 不应该删除当前 item 引用的对象")
+	}
+
+	in.Attachment = []byte("v2")
+	if err := rs.Upsert(ctx, in); err != nil {
+		t.Fatal(err)
+	}
+	update := fake.calls[len(fake.calls)-1].input.(*dynamodb.UpdateItemInput)
+	if aws.StringValue(update.ReturnValues) != UpdateReturnValueUpdatedOld {
+		t.Errorf("Upsert失败: 需要旧值来清理对象 %v", update.ReturnValues)
+	}
+	var pointer string
+	for _, av := range update.ExpressionAttributeValues {
+		if key, ok := blobPointer(av); ok {
+			pointer = key
+		}
+	}
+	if pointer == "" || !exists(pointer) || exists(blobKey(current)) {
+		t.Errorf("Upsert失败: 应该转存新对象并清理旧对象 %s", pointer)
+	}
+
+	var insertOnly struct {
+		BaseSchema
+		Attachment []byte `rotor:"offload,insertonly"`
+	}
+	insertOnly.PK, insertOnly.SK = "Test#id2", "Test"
+	if err := rs.Upsert(ctx, &insertOnly); !errors.Is(err, ErrInput) {
+		t.Errorf("Upsert失败: insertonly 的转存字段应该返回 ErrInput %v", err)
+	}
+}
+
+func TestBlobUpdate(t *testing.T) {
+	store, err := NewFileBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var old map[string]*dynamodb.AttributeValue
+	rs, fake := newFakeService(func(op string, input interface{}) (interface{}, error) {
+		if op == "UpdateItem" {
+			return &dynamodb.UpdateItemOutput{Attributes: old}, nil
+		}
+		return nil, errors.New(op)
+	}, ServiceBlobStore(store))
+	ctx := context.TODO()
+	key := PrimaryKey("Test#id1", "Test")
+	exists := func(key string) bool {
+		_, err := store.GetBlob(ctx, key)
+		return err == nil
+	}
+	pointer := func() string {
+		update := fake.calls[len(fake.calls)-1].input.(*dynamodb.UpdateItemInput)
+		if aws.StringValue(update.ReturnValues) != UpdateReturnValueUpdatedOld {
+			t.Errorf("需要旧值来清理对象 %v", update.ReturnValues)
+		}
+		for _, av := range update.ExpressionAttributeValues {
+			if key, ok := blobPointer(av); ok {
+				return key
+			}
+		}
+		return ""
+	}
+
+	item, err := rs.marshalItem(ctx, blobTestSchema{BaseSchema: BaseSchema{PK: "Test#id1", SK: "Test"}, Attachment: []byte("v1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1, _ := blobPointer(item["Attachment"])
+	old = map[string]*dynamodb.AttributeValue{"Attachment": item["Attachment"]}
+	if err := rs.Patch(ctx, key, &blobTestSchema{Attachment: []byte("v2")}); err != nil {
+		t.Fatal(err)
+	}
+	v2 := pointer()
+	if v2 == "" || !exists(v2) || exists(v1) {
+		t.Errorf("Patch失败: 应该转存新对象并清理旧对象 %s", v2)
+	}
+
+	old = map[string]*dynamodb.AttributeValue{"Attachment": fake.calls[0].input.(*dynamodb.UpdateItemInput).ExpressionAttributeValues[":0"]}
+	if _, ok := blobPointer(old["Attachment"]); !ok {
+		t.Fatalf("Patch失败: 不是预期的值 %v", old)
+	}
+	// 只修改 Name 时不会转存也不会清理
+	if err := rs.UpdateDiff(ctx, key, &blobTestSchema{Name: "a", Attachment: []byte("v2")}, &blobTestSchema{Name: "b", Attachment: []byte("v2")}); err != nil {
+		t.Fatal(err)
+	}
+	if p := pointer(); p != "" || !exists(v2) {
+		t.Errorf("UpdateDiff失败: 没有变化的转存字段不应该写入 %s", p)
+	}
+	if err := rs.UpdateDiff(ctx, key, &blobTestSchema{Attachment: []byte("v2")}, &blobTestSchema{Attachment: []byte("v3")}); err != nil {
+		t.Fatal(err)
+	}
+	if v3 := pointer(); v3 == "" || !exists(v3) || exists(v2) {
+		t.Errorf("UpdateDiff失败: 应该转存新对象并清理旧对象 %s", v3)
+	}
+}
+
+func TestBlobCleanupTransact(t *testing.T) {
+	store, err := NewFileBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var current map[string]*dynamodb.AttributeValue
+	rs, _ := newFakeService(func(op string, input interface{}) (interface{}, error) {
+		switch op {
+		case "GetItem":
+			return &dynamodb.GetItemOutput{Item: current}, nil
+		case "TransactWriteItems":
+			return &dynamodb.TransactWriteItemsOutput{}, nil
+		}
+		return nil, errors.New(op)
+	}, ServiceBlobStore(store))
+	ctx := context.TODO()
+	in := blobTestSchema{BaseSchema: BaseSchema{PK: "Test#id1", SK: "Test"}, Attachment: []byte("v1")}
+	key := PrimaryKey("Test#id1", "Test")
+	exists := func(key string) bool {
+		_, err := store.GetBlob(ctx, key)
+		return err == nil
+	}
+	// 每次写入前 current 引用一个新的对象
+	stored := func(content string) string {
+		in.Attachment = []byte(content)
+		item, err := rs.marshalItem(ctx, in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		current = item
+		pointer, _ := blobPointer(item["Attachment"])
+		return pointer
+	}
+
+	cases := []struct {
+		name  string
+		write func() error
+	}{
+		{"DeleteConditionReturnOld", func() error { return rs.Delete(ctx, key, DeleteConditionReturnOld()) }},
+		{"DeleteBatch", func() error { return rs.DeleteBatch(ctx, []PrimaryKeyType{key}) }},
+		{"Transact", func() error {
+			return rs.Transact(ctx, func(options *TransactOptions) {
+				options.DeleteItems = append(options.DeleteItems, TransactDeleteItem{Key: key})
+			})
+		}},
+		{"PutConditionReturnOld", func() error {
+			in.Attachment = []byte("new")
+			return rs.Put(ctx, in, PutConditionReturnOld())
+		}},
+		{"PutBatch", func() error {
+			in.Attachment = []byte("new")
+			return rs.PutBatch(ctx, []interface{}{in})
+		}},
+	}
+	for _, c := range cases {
+		pointer := stored(c.name)
+		if err := c.write(); err != nil {
+			t.Fatalf("%s失败: %v", c.name, err)
+		}
+		if exists(pointer) {
+			t.Errorf("%s失败: 应该清理旧 item 引用的对象", c.name)
+		}
+	}
+}
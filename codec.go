@@ -1,6 +1,8 @@
 package rotor
 
 import (
+	"reflect"
+
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 )
@@ -10,11 +12,15 @@ import (
 type Codec struct {
 	Encoder *dynamodbattribute.Encoder
 	Decoder *dynamodbattribute.Decoder
+
+	config *codecConfig
+	// itemKey 加密字段绑定的主键, 由最外层的结构体设置
+	itemKey PrimaryKeyType
 }
 
 // NewCodec NewCodec
-func NewCodec() Codec {
-	return Codec{
+func NewCodec(opts ...CodecOption) Codec {
+	codec := Codec{
 		Encoder: dynamodbattribute.NewEncoder(
 			func(e *dynamodbattribute.Encoder) {
 				e.SupportJSONTags = false
@@ -27,14 +33,26 @@ func NewCodec() Codec {
 			d.SupportJSONTags = false
 			d.EnableEmptyCollections = true
 		}),
+		config: newCodecConfig(),
+	}
+	for _, opt := range opts {
+		opt(&codec)
 	}
+	return codec
 }
 
 // Marshal will marshal a Go value type to an AttributeValue
 // Marshal cannot represent cyclic data structures and will not handle them.
 // Passing cyclic structures to Marshal will result in an infinite recursion.
 func (codec Codec) Marshal(in interface{}) (*dynamodb.AttributeValue, error) {
-	return codec.Encoder.Encode(in)
+	if codec.config == nil {
+		return codec.Encoder.Encode(in)
+	}
+	v := reflect.ValueOf(in)
+	if !v.IsValid() || !codec.planned() {
+		return codec.encodeValue(v, nil)
+	}
+	return codec.config.encoderOf(v.Type())(codec, v)
 }
 
 // MarshalMap is an alias for Marshal func which marshals Go value
@@ -42,7 +60,7 @@ func (codec Codec) Marshal(in interface{}) (*dynamodb.AttributeValue, error) {
 //
 // This is useful for DynamoDB APIs such as PutItem.
 func (codec Codec) MarshalMap(in interface{}) (map[string]*dynamodb.AttributeValue, error) {
-	av, err := codec.Encoder.Encode(in)
+	av, err := codec.Marshal(in)
 	if err != nil || av == nil || av.M == nil {
 		return map[string]*dynamodb.AttributeValue{}, err
 	}
@@ -53,7 +71,7 @@ func (codec Codec) MarshalMap(in interface{}) (map[string]*dynamodb.AttributeVal
 // MarshalList is an alias for Marshal func which marshals Go value
 // type to a slice of AttributeValues.
 func (codec Codec) MarshalList(in interface{}) ([]*dynamodb.AttributeValue, error) {
-	av, err := codec.Encoder.Encode(in)
+	av, err := codec.Marshal(in)
 	if err != nil || av == nil || av.L == nil {
 		return []*dynamodb.AttributeValue{}, err
 	}
@@ -64,7 +82,29 @@ func (codec Codec) MarshalList(in interface{}) ([]*dynamodb.AttributeValue, erro
 // Unmarshal will unmarshal an AttributeValue into a Go value type
 // The output value provided must be a non-nil pointer
 func (codec Codec) Unmarshal(av *dynamodb.AttributeValue, out interface{}) error {
-	return codec.Decoder.Decode(av, out)
+	if codec.strict() {
+		if t := reflect.TypeOf(out); t != nil && t.Kind() == reflect.Ptr {
+			if err := codec.checkStrict(av, t.Elem(), strictOptions{}); err != nil {
+				return err
+			}
+		}
+	}
+	return codec.decode(av, out)
+}
+
+// decode 解码 av, 不做严格检查
+func (codec Codec) decode(av *dynamodb.AttributeValue, out interface{}) error {
+	v := reflect.ValueOf(out)
+	if codec.config == nil || v.Kind() != reflect.Ptr || v.IsNil() {
+		return codec.Decoder.Decode(av, out)
+	}
+	if codec.planned() && av != nil {
+		return codec.config.decoderOf(v.Elem().Type())(codec, av, v.Elem())
+	}
+	if !codec.config.custom(v.Type()) {
+		return codec.Decoder.Decode(av, out)
+	}
+	return codec.decodeValue(av, v.Elem(), nil)
 }
 
 // UnmarshalMap is an alias for Unmarshal which unmarshals from
@@ -72,7 +112,7 @@ func (codec Codec) Unmarshal(av *dynamodb.AttributeValue, out interface{}) error
 //
 // The output value provided must be a non-nil pointer
 func (codec Codec) UnmarshalMap(m map[string]*dynamodb.AttributeValue, out interface{}) error {
-	return codec.Decoder.Decode(&dynamodb.AttributeValue{M: m}, out)
+	return codec.Unmarshal(&dynamodb.AttributeValue{M: m}, out)
 }
 
 // UnmarshalList is an alias for Unmarshal func which unmarshals
@@ -80,7 +120,7 @@ func (codec Codec) UnmarshalMap(m map[string]*dynamodb.AttributeValue, out inter
 //
 // The output value provided must be a non-nil pointer
 func (codec Codec) UnmarshalList(l []*dynamodb.AttributeValue, out interface{}) error {
-	return codec.Decoder.Decode(&dynamodb.AttributeValue{L: l}, out)
+	return codec.Unmarshal(&dynamodb.AttributeValue{L: l}, out)
 }
 
 // UnmarshalListOfMaps is an alias for Unmarshal func which unmarshals a
@@ -0,0 +1,220 @@
+package rotor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/klauspost/compress/zstd"
+)
+
+// 压缩字段的格式: magic | 算法编号 | 压缩后的属性 DynamoDB JSON
+// 小于阈值的值原样保存, 恰好以 magic 开头的二进制值使用算法编号 0 原样包装
+const (
+	compressStored = 0
+	compressGzip   = 1
+	compressZstd   = 2
+
+	defaultCompressThreshold = 1024
+)
+
+var compressMagic = []byte{0xd9, 0x7a}
+
+// Compressor 压缩算法, rotor 内置 gzip 和 zstd
+// 自定义的算法需要保证同一编号始终对应同一种格式
+type Compressor interface {
+	// ID 写入头部的算法编号, 0 到 2 由 rotor 使用
+	ID() byte
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+type gzipCompressor struct {
+	level int
+}
+
+// GzipCompressor gzip 压缩, level 与 compress/gzip 相同
+func GzipCompressor(level int) Compressor {
+	return gzipCompressor{level: level}
+}
+
+func (gzipCompressor) ID() byte {
+	return compressGzip
+}
+
+func (c gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, c.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+type zstdCompressor struct {
+	enc *zstd.Encoder
+	err error
+}
+
+var (
+	zstdOnce    sync.Once
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+// ZstdCompressor zstd 压缩, level 与 zstd 命令行的级别相同, 会映射到最接近的级别
+func ZstdCompressor(level int) Compressor {
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	return zstdCompressor{enc: enc, err: err}
+}
+
+func (zstdCompressor) ID() byte {
+	return compressZstd
+}
+
+func (c zstdCompressor) Compress(data []byte) ([]byte, error) {
+	if c.enc == nil {
+		return nil, fmt.Errorf("rotor: zstd encoder: %v", c.err)
+	}
+	return c.enc.EncodeAll(data, nil), nil
+}
+
+// Decompress 所有 zstd 编码器共用一个解码器, 没有设置 ZstdCompressor 时也可以读取
+func (zstdCompressor) Decompress(data []byte) ([]byte, error) {
+	zstdOnce.Do(func() {
+		zstdDecoder, zstdErr = zstd.NewReader(nil)
+	})
+	if zstdErr != nil {
+		return nil, zstdErr
+	}
+	return zstdDecoder.DecodeAll(data, nil)
+}
+
+// CodecCompressor 设置 `rotor:"compress"` 字段使用的压缩算法, 默认为 gzip
+// 之前使用过的算法需要继续注册才能读取旧数据, gzip 和 zstd 总是可以读取
+func CodecCompressor(compressor Compressor, others ...Compressor) CodecOption {
+	return func(codec *Codec) {
+		codec.config.compressor = compressor
+		for _, c := range append([]Compressor{compressor}, others...) {
+			codec.config.compressors[c.ID()] = c
+		}
+	}
+}
+
+// CodecCompressThreshold 编码后不小于 n 字节的值才压缩, 默认 1024
+func CodecCompressThreshold(n int) CodecOption {
+	return func(codec *Codec) {
+		codec.config.compressThreshold = n
+	}
+}
+
+// compressAttribute 压缩单个属性, 小于阈值或压缩后没有变小时原样返回
+func (c *codecConfig) compressAttribute(av *dynamodb.AttributeValue) (*dynamodb.AttributeValue, error) {
+	plain, err := marshalAttributeJSON(av)
+	if err != nil {
+		return nil, err
+	}
+	if len(plain) >= c.compressThreshold {
+		compressed, err := c.compressor.Compress(plain)
+		if err != nil {
+			return nil, err
+		}
+		if len(compressed) < len(plain) {
+			header := append(append([]byte{}, compressMagic...), c.compressor.ID())
+			return &dynamodb.AttributeValue{B: append(header, compressed...)}, nil
+		}
+	}
+	if av.B != nil && bytes.HasPrefix(av.B, compressMagic) {
+		header := append(append([]byte{}, compressMagic...), compressStored)
+		return &dynamodb.AttributeValue{B: append(header, plain...)}, nil
+	}
+	return av, nil
+}
+
+// decompressAttribute 没有压缩头部的值原样返回, 开启压缩前写入的数据可以直接读取
+func (c *codecConfig) decompressAttribute(av *dynamodb.AttributeValue, name string) (*dynamodb.AttributeValue, error) {
+	if av == nil || av.B == nil || !bytes.HasPrefix(av.B, compressMagic) || len(av.B) <= len(compressMagic) {
+		return av, nil
+	}
+	id, payload := av.B[len(compressMagic)], av.B[len(compressMagic)+1:]
+	if id != compressStored {
+		compressor, ok := c.compressors[id]
+		if !ok {
+			return nil, fmt.Errorf("rotor: attribute %s uses unknown compressor %d", name, id)
+		}
+		var err error
+		if payload, err = compressor.Decompress(payload); err != nil {
+			return nil, fmt.Errorf("rotor: decompress attribute %s: %w", name, err)
+		}
+	}
+	return unmarshalAttributeJSON(payload)
+}
+
+// compressFields 压缩结构体编码后的 `rotor:"compress"` 字段, 需要在加密之前
+func (c *codecConfig) compressFields(fields []field, item map[string]*dynamodb.AttributeValue) error {
+	for _, f := range fields {
+		if !f.Rotor.Has("compress") {
+			continue
+		}
+		if f.Name == tablePK || f.Name == tableSK {
+			return fmt.Errorf("rotor: primary key %s cannot be compressed", f.Name)
+		}
+		av, ok := item[f.Name]
+		if !ok || av.NULL != nil {
+			continue
+		}
+		compressed, err := c.compressAttribute(av)
+		if err != nil {
+			return err
+		}
+		item[f.Name] = compressed
+	}
+	return nil
+}
+
+// decompressFields 返回解压后的 item, 不修改传入的 item
+func (c *codecConfig) decompressFields(fields []field, item map[string]*dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, error) {
+	var out map[string]*dynamodb.AttributeValue
+	for _, f := range fields {
+		if !f.Rotor.Has("compress") {
+			continue
+		}
+		name, ok := lookupAttribute(item, f.Name)
+		if !ok {
+			continue
+		}
+		av, err := c.decompressAttribute(item[name], name)
+		if err != nil {
+			return nil, err
+		}
+		if out == nil {
+			out = make(map[string]*dynamodb.AttributeValue, len(item))
+			for k, v := range item {
+				out[k] = v
+			}
+		}
+		out[name] = av
+	}
+	if out == nil {
+		return item, nil
+	}
+	return out, nil
+}
@@ -0,0 +1,69 @@
+package rotor_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/lixw1994/rotor"
+)
+
+type blobSchema struct {
+	rotor.BaseSchema
+
+	Doc  string `rotor:"compress"`
+	Raw  []byte `rotor:"compress"`
+	Note string `rotor:"compress"`
+}
+
+func TestCodecCompress(t *testing.T) {
+	codec := rotor.NewCodec(rotor.CodecCompressThreshold(256))
+	in := blobSchema{
+		BaseSchema: newTestSchema("id1", "").BaseSchema,
+		Doc:        strings.Repeat(`{"k":"v"},`, 1000),
+		Raw:        []byte{0xd9, 0x7a, 1, 2, 3},
+		Note:       "short",
+	}
+	m, err := codec.MarshalMap(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m["Doc"].B == nil || len(m["Doc"].B) >= len(in.Doc) {
+		t.Errorf("MarshalMap失败: Doc 没有压缩 %d", len(m["Doc"].B))
+	}
+	if m["Note"].S == nil {
+		t.Errorf("MarshalMap失败: 小于阈值的值不应该压缩 %v", m["Note"])
+	}
+	var out blobSchema
+	if err := codec.UnmarshalMap(m, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Doc != in.Doc || !bytes.Equal(out.Raw, in.Raw) || out.Note != in.Note {
+		t.Errorf("UnmarshalMap失败: 不是预期的值 %q %v %q", out.Doc[:10], out.Raw, out.Note)
+	}
+}
+
+func TestCodecCompressZstd(t *testing.T) {
+	codec := rotor.NewCodec(rotor.CodecCompressor(rotor.ZstdCompressor(3)), rotor.CodecCompressThreshold(256))
+	in := blobSchema{
+		BaseSchema: newTestSchema("id1", "").BaseSchema,
+		Doc:        strings.Repeat(`{"k":"v"},`, 1000),
+	}
+	m, err := codec.MarshalMap(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m["Doc"].B == nil || m["Doc"].B[2] != 2 || len(m["Doc"].B) >= len(in.Doc) {
+		t.Fatalf("MarshalMap失败: Doc 没有使用 zstd 压缩 %v", m["Doc"])
+	}
+	// 默认的 codec 也可以读取 zstd
+	for _, c := range []rotor.Codec{codec, rotor.NewCodec()} {
+		var out blobSchema
+		if err := c.UnmarshalMap(m, &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.Doc != in.Doc {
+			t.Errorf("UnmarshalMap失败: 不是预期的值 %q", out.Doc[:10])
+		}
+	}
+}
@@ -0,0 +1,666 @@
+package rotor
+
+import (
+	"compress/gzip"
+	"encoding"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// Converter 自定义类型与 AttributeValue 之间的转换
+// v 是注册的类型的值, Unmarshal 时 v 可以 Set, 属性不存在或为 NULL 时不会调用
+type Converter interface {
+	MarshalAttribute(v reflect.Value) (*dynamodb.AttributeValue, error)
+	UnmarshalAttribute(av *dynamodb.AttributeValue, v reflect.Value) error
+}
+
+// ConverterFuncs 用函数实现 Converter
+// Unmarshal 返回的值需要可以赋值给注册的类型
+type ConverterFuncs struct {
+	Marshal   func(in interface{}) (*dynamodb.AttributeValue, error)
+	Unmarshal func(av *dynamodb.AttributeValue) (interface{}, error)
+}
+
+// MarshalAttribute MarshalAttribute
+func (c ConverterFuncs) MarshalAttribute(v reflect.Value) (*dynamodb.AttributeValue, error) {
+	return c.Marshal(v.Interface())
+}
+
+// UnmarshalAttribute UnmarshalAttribute
+func (c ConverterFuncs) UnmarshalAttribute(av *dynamodb.AttributeValue, v reflect.Value) error {
+	out, err := c.Unmarshal(av)
+	if err != nil {
+		return err
+	}
+	ov := reflect.ValueOf(out)
+	if !ov.IsValid() {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	if !ov.Type().AssignableTo(v.Type()) {
+		return &dynamodbattribute.UnmarshalTypeError{Value: ov.Type().String(), Type: v.Type()}
+	}
+	v.Set(ov)
+	return nil
+}
+
+// 自定义类型可以转换成的属性类型
+const (
+	AttributeTypeS = "S"
+	AttributeTypeN = "N"
+	AttributeTypeB = "B"
+)
+
+type textConverter struct {
+	attrType string
+}
+
+// TextConverter 通过 encoding.TextMarshaler/TextUnmarshaler 转换, attrType 为 S 或 N
+// 例如 uuid.UUID, netip.Addr 保存为 S, decimal.Decimal, *big.Int 保存为 N
+func TextConverter(attrType string) Converter {
+	return textConverter{attrType: attrType}
+}
+
+func (c textConverter) MarshalAttribute(v reflect.Value) (*dynamodb.AttributeValue, error) {
+	m, ok := methodReceiver(v).Interface().(encoding.TextMarshaler)
+	if !ok {
+		return nil, fmt.Errorf("rotor: %s does not implement encoding.TextMarshaler", v.Type())
+	}
+	text, err := m.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+	if c.attrType == AttributeTypeN {
+		return &dynamodb.AttributeValue{N: aws.String(string(text))}, nil
+	}
+	return &dynamodb.AttributeValue{S: aws.String(string(text))}, nil
+}
+
+func (c textConverter) UnmarshalAttribute(av *dynamodb.AttributeValue, v reflect.Value) error {
+	text := av.S
+	if c.attrType == AttributeTypeN {
+		text = av.N
+	}
+	if text == nil {
+		return &dynamodbattribute.UnmarshalTypeError{Value: "non-" + c.attrType, Type: v.Type()}
+	}
+	u, ok := settableReceiver(v).Interface().(encoding.TextUnmarshaler)
+	if !ok {
+		return fmt.Errorf("rotor: %s does not implement encoding.TextUnmarshaler", v.Type())
+	}
+	return u.UnmarshalText([]byte(*text))
+}
+
+type binaryConverter struct{}
+
+// BinaryConverter 通过 encoding.BinaryMarshaler/BinaryUnmarshaler 转换为 B
+func BinaryConverter() Converter {
+	return binaryConverter{}
+}
+
+func (binaryConverter) MarshalAttribute(v reflect.Value) (*dynamodb.AttributeValue, error) {
+	m, ok := methodReceiver(v).Interface().(encoding.BinaryMarshaler)
+	if !ok {
+		return nil, fmt.Errorf("rotor: %s does not implement encoding.BinaryMarshaler", v.Type())
+	}
+	b, err := m.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return &dynamodb.AttributeValue{B: b}, nil
+}
+
+func (binaryConverter) UnmarshalAttribute(av *dynamodb.AttributeValue, v reflect.Value) error {
+	if av.B == nil {
+		return &dynamodbattribute.UnmarshalTypeError{Value: "non-B", Type: v.Type()}
+	}
+	u, ok := settableReceiver(v).Interface().(encoding.BinaryUnmarshaler)
+	if !ok {
+		return fmt.Errorf("rotor: %s does not implement encoding.BinaryUnmarshaler", v.Type())
+	}
+	return u.UnmarshalBinary(av.B)
+}
+
+// methodReceiver 值类型没有实现时尝试指针接收者
+func methodReceiver(v reflect.Value) reflect.Value {
+	if v.Kind() == reflect.Ptr {
+		return v
+	}
+	if v.CanAddr() {
+		return v.Addr()
+	}
+	p := reflect.New(v.Type())
+	p.Elem().Set(v)
+	return p
+}
+
+// settableReceiver 指针类型为 nil 时先分配
+func settableReceiver(v reflect.Value) reflect.Value {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return v
+	}
+	return v.Addr()
+}
+
+// codecConfig Codec 的自定义配置, Codec 按值传递时共享
+type codecConfig struct {
+	converters   map[reflect.Type]Converter
+	timeEncoding TimeEncoding
+	keyProvider  KeyProvider
+
+	plaintextFallback bool
+
+	compressor        Compressor
+	compressors       map[byte]Compressor
+	compressThreshold int
+
+	strict      bool
+	protoNaming ProtoNaming
+
+	needs    sync.Map // reflect.Type => bool
+	wrappers sync.Map // wrapperKey => reflect.Type
+	encoders sync.Map // reflect.Type => encodeFunc
+	decoders sync.Map // reflect.Type => decodeFunc
+}
+
+func newCodecConfig() *codecConfig {
+	gz := GzipCompressor(gzip.DefaultCompression)
+	return &codecConfig{
+		converters:        map[reflect.Type]Converter{},
+		compressor:        gz,
+		compressors:       map[byte]Compressor{gz.ID(): gz, compressZstd: zstdCompressor{}},
+		compressThreshold: defaultCompressThreshold,
+	}
+}
+
+// CodecOption CodecOption
+type CodecOption func(codec *Codec)
+
+// CodecConverter 为 sample 的类型注册 Converter, 例如 CodecConverter(uuid.UUID{}, TextConverter(AttributeTypeS))
+// 指针类型需要单独注册, 例如 CodecConverter((*big.Int)(nil), TextConverter(AttributeTypeN))
+func CodecConverter(sample interface{}, converter Converter) CodecOption {
+	return func(codec *Codec) {
+		codec.config.converters[reflect.TypeOf(sample)] = converter
+	}
+}
+
+var (
+	marshalerType   = reflect.TypeOf((*dynamodbattribute.Marshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*dynamodbattribute.Unmarshaler)(nil)).Elem()
+)
+
+// custom 类型 t 的编解码是否需要 rotor 处理, 不需要时整体交给 dynamodbattribute
+func (c *codecConfig) custom(t reflect.Type) bool {
+	if c == nil || t == nil {
+		return false
+	}
+	if v, ok := c.needs.Load(t); ok {
+		return v.(bool)
+	}
+	v := c.computeCustom(t, map[reflect.Type]bool{})
+	c.needs.Store(t, v)
+	return v
+}
+
+func (c *codecConfig) computeCustom(t reflect.Type, visiting map[reflect.Type]bool) bool {
+	if _, ok := c.converters[t]; ok {
+		return true
+	}
+	if t == timeType {
+		return c.timeEncoding != TimeDefault
+	}
+	if visiting[t] {
+		return false
+	}
+	visiting[t] = true
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return c.computeCustom(t.Elem(), visiting)
+	case reflect.Struct:
+		if t.Implements(marshalerType) || reflect.PtrTo(t).Implements(unmarshalerType) {
+			return false
+		}
+		for _, f := range structFields(t) {
+			if f.Rotor.Has("time") || f.Rotor.Has("encrypt") || f.Rotor.Has("compress") || c.computeCustom(f.Type, visiting) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (codec Codec) converter(t reflect.Type) (Converter, bool) {
+	if codec.config == nil {
+		return nil, false
+	}
+	conv, ok := codec.config.converters[t]
+	return conv, ok
+}
+
+// encodeValue 编码 v, f 为 v 所在的结构体字段, 返回 nil 表示省略
+func (codec Codec) encodeValue(v reflect.Value, f *field) (*dynamodb.AttributeValue, error) {
+	if !v.IsValid() {
+		return &dynamodb.AttributeValue{NULL: aws.Bool(true)}, nil
+	}
+	t := v.Type()
+	if conv, ok := codec.converter(t); ok {
+		if t.Kind() == reflect.Ptr && v.IsNil() {
+			return &dynamodb.AttributeValue{NULL: aws.Bool(true)}, nil
+		}
+		return conv.MarshalAttribute(v)
+	}
+	if t.Kind() == reflect.Interface && !v.IsNil() && codec.config != nil {
+		return codec.encodeValue(v.Elem(), f)
+	}
+	if isTimeType(t) && codec.config != nil {
+		enc := codec.config.timeEncodingOf(f)
+		switch {
+		case enc == TimeDefault:
+			return codec.delegateEncode(v, f)
+		case t.Kind() == reflect.Ptr && v.IsNil():
+			return &dynamodb.AttributeValue{NULL: aws.Bool(true)}, nil
+		case t.Kind() == reflect.Ptr:
+			v = v.Elem()
+		}
+		return encodeTime(v.Interface().(time.Time), enc)
+	}
+	if !codec.config.custom(t) {
+		return codec.delegateEncode(v, f)
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return &dynamodb.AttributeValue{NULL: aws.Bool(true)}, nil
+		}
+		return codec.encodeValue(v.Elem(), f)
+	case reflect.Struct:
+		return codec.encodeStruct(v)
+	case reflect.Map:
+		return codec.encodeMap(v, f)
+	case reflect.Slice, reflect.Array:
+		return codec.encodeList(v, f)
+	}
+	return codec.delegateEncode(v, f)
+}
+
+func (codec Codec) encodeStruct(v reflect.Value) (*dynamodb.AttributeValue, error) {
+	m := map[string]*dynamodb.AttributeValue{}
+	fields := structFields(v.Type())
+	order, keys := keyFirst(fields)
+	bind := codec.bindsKey()
+	for n, i := range order {
+		if bind && n == keys {
+			codec.itemKey = pickAttributes(m, []string{tablePK, tableSK})
+		}
+		f := fields[i]
+		fv, ok := fieldByIndex(v, f.Index)
+		if !ok {
+			continue
+		}
+		if f.OmitEmpty && emptyValue(fv) {
+			continue
+		}
+		av, err := codec.encodeValue(fv, &f)
+		if err != nil {
+			return nil, err
+		}
+		if av == nil || (f.OmitEmpty && av.NULL != nil) {
+			continue
+		}
+		m[f.Name] = av
+	}
+	if err := codec.config.compressFields(fields, m); err != nil {
+		return nil, err
+	}
+	if err := codec.config.encryptFields(fields, m, codec.itemKey); err != nil {
+		return nil, err
+	}
+	return &dynamodb.AttributeValue{M: m}, nil
+}
+
+func (codec Codec) encodeMap(v reflect.Value, f *field) (*dynamodb.AttributeValue, error) {
+	if v.IsNil() {
+		return &dynamodb.AttributeValue{NULL: aws.Bool(true)}, nil
+	}
+	if v.Type().Key().Kind() != reflect.String {
+		return codec.delegateEncode(v, f)
+	}
+	m := make(map[string]*dynamodb.AttributeValue, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		av, err := codec.encodeValue(iter.Value(), nil)
+		if err != nil {
+			return nil, err
+		}
+		if av == nil || (f != nil && f.OmitEmptyElem && av.NULL != nil) {
+			continue
+		}
+		m[iter.Key().String()] = av
+	}
+	return &dynamodb.AttributeValue{M: m}, nil
+}
+
+func (codec Codec) encodeList(v reflect.Value, f *field) (*dynamodb.AttributeValue, error) {
+	if v.Kind() == reflect.Slice && v.IsNil() {
+		return &dynamodb.AttributeValue{NULL: aws.Bool(true)}, nil
+	}
+	l := make([]*dynamodb.AttributeValue, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		av, err := codec.encodeValue(v.Index(i), nil)
+		if err != nil {
+			return nil, err
+		}
+		if av == nil || (f != nil && f.OmitEmptyElem && av.NULL != nil) {
+			continue
+		}
+		l = append(l, av)
+	}
+	if f == nil || !(f.AsStrSet || f.AsNumSet || f.AsBinSet) {
+		return &dynamodb.AttributeValue{L: l}, nil
+	}
+	set := &dynamodb.AttributeValue{}
+	for _, av := range l {
+		switch {
+		case f.AsStrSet && av.S != nil:
+			set.SS = append(set.SS, av.S)
+		case f.AsNumSet && av.N != nil:
+			set.NS = append(set.NS, av.N)
+		case f.AsBinSet && av.B != nil:
+			set.BS = append(set.BS, av.B)
+		default:
+			return nil, &dynamodbattribute.InvalidMarshalError{}
+		}
+	}
+	if len(l) == 0 {
+		return &dynamodb.AttributeValue{NULL: aws.Bool(true)}, nil
+	}
+	return set, nil
+}
+
+// wrapperKey 带 dynamodbav 选项的字段交给 dynamodbattribute 时使用的包装类型
+type wrapperKey struct {
+	t   reflect.Type
+	tag string
+}
+
+const wrapperField = "V"
+
+// avOptions 除 omitempty 外会影响编解码的 dynamodbav 选项
+func (f *field) avOptions() string {
+	if f == nil {
+		return ""
+	}
+	var opts []string
+	for _, opt := range []struct {
+		set  bool
+		name string
+	}{
+		{f.OmitEmptyElem, "omitemptyelem"},
+		{f.AsString, "string"},
+		{f.AsBinSet, "binaryset"},
+		{f.AsNumSet, "numberset"},
+		{f.AsStrSet, "stringset"},
+		{f.AsUnixTime, "unixtime"},
+	} {
+		if opt.set {
+			opts = append(opts, opt.name)
+		}
+	}
+	return strings.Join(opts, ",")
+}
+
+func (c *codecConfig) wrapper(t reflect.Type, opts string) reflect.Type {
+	key := wrapperKey{t: t, tag: opts}
+	if w, ok := c.wrappers.Load(key); ok {
+		return w.(reflect.Type)
+	}
+	w := reflect.StructOf([]reflect.StructField{{
+		Name: wrapperField,
+		Type: t,
+		Tag:  reflect.StructTag(fmt.Sprintf(`dynamodbav:"%s,%s"`, wrapperField, opts)),
+	}})
+	c.wrappers.Store(key, w)
+	return w
+}
+
+// delegateEncode 交给 dynamodbattribute, 字段上的 dynamodbav 选项通过包装类型保留
+func (codec Codec) delegateEncode(v reflect.Value, f *field) (*dynamodb.AttributeValue, error) {
+	opts := f.avOptions()
+	if opts == "" || codec.config == nil {
+		return codec.Encoder.Encode(v.Interface())
+	}
+	w := reflect.New(codec.config.wrapper(v.Type(), opts)).Elem()
+	w.Field(0).Set(v)
+	av, err := codec.Encoder.Encode(w.Interface())
+	if err != nil || av == nil {
+		return nil, err
+	}
+	return av.M[wrapperField], nil
+}
+
+// decodeValue 解码 av 到可以 Set 的 v
+func (codec Codec) decodeValue(av *dynamodb.AttributeValue, v reflect.Value, f *field) error {
+	t := v.Type()
+	if conv, ok := codec.converter(t); ok {
+		if av == nil || av.NULL != nil {
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		return conv.UnmarshalAttribute(av, v)
+	}
+	if isTimeType(t) && codec.config != nil {
+		enc := codec.config.timeEncodingOf(f)
+		switch {
+		case enc == TimeDefault:
+			return codec.delegateDecode(av, v, f)
+		case av == nil || av.NULL != nil:
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		tm, err := decodeTime(av, enc)
+		if err != nil {
+			return err
+		}
+		if t.Kind() == reflect.Ptr {
+			v.Set(reflect.New(timeType))
+			v = v.Elem()
+		}
+		v.Set(reflect.ValueOf(tm))
+		return nil
+	}
+	if !codec.config.custom(t) {
+		return codec.delegateDecode(av, v, f)
+	}
+	if av == nil || av.NULL != nil {
+		v.Set(reflect.Zero(t))
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(t.Elem()))
+		}
+		return codec.decodeValue(av, v.Elem(), f)
+	case reflect.Struct:
+		return codec.decodeStruct(av, v)
+	case reflect.Map:
+		return codec.decodeMap(av, v, f)
+	case reflect.Slice, reflect.Array:
+		return codec.decodeList(av, v)
+	}
+	return codec.delegateDecode(av, v, f)
+}
+
+func (codec Codec) decodeStruct(av *dynamodb.AttributeValue, v reflect.Value) error {
+	if av.M == nil {
+		return &dynamodbattribute.UnmarshalTypeError{Value: "non-M", Type: v.Type()}
+	}
+	fields := structFields(v.Type())
+	if codec.bindsKey() {
+		codec.itemKey = pickAttributes(av.M, []string{tablePK, tableSK})
+	}
+	item, err := codec.config.decryptFields(fields, av.M, codec.itemKey)
+	if err != nil {
+		return err
+	}
+	if item, err = codec.config.decompressFields(fields, item); err != nil {
+		return err
+	}
+	for name, a := range item {
+		f, ok := lookupField(fields, name)
+		if !ok {
+			continue
+		}
+		fv, err := allocFieldByIndex(v, f.Index)
+		if err != nil {
+			return err
+		}
+		if err := codec.decodeValue(a, fv, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (codec Codec) decodeMap(av *dynamodb.AttributeValue, v reflect.Value, f *field) error {
+	t := v.Type()
+	if t.Key().Kind() != reflect.String {
+		return codec.delegateDecode(av, v, f)
+	}
+	if av.M == nil {
+		return &dynamodbattribute.UnmarshalTypeError{Value: "non-M", Type: t}
+	}
+	if v.IsNil() {
+		v.Set(reflect.MakeMapWithSize(t, len(av.M)))
+	}
+	for k, a := range av.M {
+		elem := reflect.New(t.Elem()).Elem()
+		if err := codec.decodeValue(a, elem, nil); err != nil {
+			return err
+		}
+		key := reflect.New(t.Key()).Elem()
+		key.SetString(k)
+		v.SetMapIndex(key, elem)
+	}
+	return nil
+}
+
+func (codec Codec) decodeList(av *dynamodb.AttributeValue, v reflect.Value) error {
+	l := av.L
+	switch {
+	case av.SS != nil:
+		l = make([]*dynamodb.AttributeValue, len(av.SS))
+		for i, s := range av.SS {
+			l[i] = &dynamodb.AttributeValue{S: s}
+		}
+	case av.NS != nil:
+		l = make([]*dynamodb.AttributeValue, len(av.NS))
+		for i, n := range av.NS {
+			l[i] = &dynamodb.AttributeValue{N: n}
+		}
+	case av.BS != nil:
+		l = make([]*dynamodb.AttributeValue, len(av.BS))
+		for i, b := range av.BS {
+			l[i] = &dynamodb.AttributeValue{B: b}
+		}
+	case l == nil:
+		return &dynamodbattribute.UnmarshalTypeError{Value: "non-L", Type: v.Type()}
+	}
+	if v.Kind() == reflect.Slice {
+		v.Set(reflect.MakeSlice(v.Type(), len(l), len(l)))
+	}
+	for i := 0; i < len(l) && i < v.Len(); i++ {
+		if err := codec.decodeValue(l[i], v.Index(i), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// delegateDecode 交给 dynamodbattribute 解码
+func (codec Codec) delegateDecode(av *dynamodb.AttributeValue, v reflect.Value, f *field) error {
+	if av == nil {
+		return nil
+	}
+	opts := f.avOptions()
+	if opts == "" || codec.config == nil {
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		if err := codec.Decoder.Decode(av, p.Interface()); err != nil {
+			return err
+		}
+		v.Set(p.Elem())
+		return nil
+	}
+	w := reflect.New(codec.config.wrapper(v.Type(), opts))
+	w.Elem().Field(0).Set(v)
+	err := codec.Decoder.Decode(&dynamodb.AttributeValue{M: map[string]*dynamodb.AttributeValue{wrapperField: av}}, w.Interface())
+	if err != nil {
+		return err
+	}
+	v.Set(w.Elem().Field(0))
+	return nil
+}
+
+// lookupField 先精确匹配, 再忽略大小写匹配, 与 dynamodbattribute 一致
+func lookupField(fields []field, name string) (*field, bool) {
+	for i := range fields {
+		if fields[i].Name == name {
+			return &fields[i], true
+		}
+	}
+	for i := range fields {
+		if strings.EqualFold(fields[i].Name, name) {
+			return &fields[i], true
+		}
+	}
+	return nil, false
+}
+
+// allocFieldByIndex 按 index 取字段, 路径上的 nil 指针会被分配
+func allocFieldByIndex(v reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, fmt.Errorf("rotor: cannot set embedded pointer to unexported struct %s", v.Type().Elem())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, nil
+}
+
+// emptyValue 与 dynamodbattribute 的 omitempty 规则一致, NewCodec 开启了 EnableEmptyCollections
+func emptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice:
+		return v.IsNil()
+	case reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
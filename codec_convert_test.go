@@ -0,0 +1,88 @@
+package rotor_test
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/lixw1994/rotor"
+)
+
+type orderID struct {
+	shard int
+	seq   int
+}
+
+type convertSchema struct {
+	rotor.BaseSchema
+
+	ID     orderID
+	Amount *big.Int
+	Refs   []orderID `dynamodbav:",stringset"`
+	Tags   map[string]orderID
+	Note   string `dynamodbav:",omitempty"`
+}
+
+func newConvertCodec() rotor.Codec {
+	return rotor.NewCodec(
+		rotor.CodecConverter(orderID{}, rotor.ConverterFuncs{
+			Marshal: func(in interface{}) (*dynamodb.AttributeValue, error) {
+				id := in.(orderID)
+				return &dynamodb.AttributeValue{S: aws.String(fmt.Sprintf("O%d-%d", id.shard, id.seq))}, nil
+			},
+			Unmarshal: func(av *dynamodb.AttributeValue) (interface{}, error) {
+				var id orderID
+				_, err := fmt.Sscanf(aws.StringValue(av.S), "O%d-%d", &id.shard, &id.seq)
+				return id, err
+			},
+		}),
+		rotor.CodecConverter((*big.Int)(nil), rotor.TextConverter(rotor.AttributeTypeN)),
+	)
+}
+
+func TestCodecConverter(t *testing.T) {
+	codec := newConvertCodec()
+	amount, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	in := convertSchema{
+		BaseSchema: newTestSchema("id1", "v1").BaseSchema,
+		ID:         orderID{shard: 1, seq: 2},
+		Amount:     amount,
+		Refs:       []orderID{{shard: 3, seq: 4}},
+		Tags:       map[string]orderID{"a": {shard: 5, seq: 6}},
+	}
+	m, err := codec.MarshalMap(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(m["ID"].S) != "O1-2" || aws.StringValue(m["Amount"].N) != amount.String() {
+		t.Fatalf("MarshalMap失败: 不是预期的值 %v", m)
+	}
+	if len(m["Refs"].SS) != 1 || aws.StringValue(m["Refs"].SS[0]) != "O3-4" {
+		t.Fatalf("MarshalMap失败: 不是预期的集合 %v", m["Refs"])
+	}
+	if _, ok := m["Note"]; ok || aws.StringValue(m["PK"].S) != pKPrefix+"id1" {
+		t.Fatalf("MarshalMap失败: 不是预期的字段 %v", m)
+	}
+	if _, ok := m["ExpireTime"]; ok {
+		t.Fatalf("MarshalMap失败: omitempty 没有生效 %v", m)
+	}
+
+	var out convertSchema
+	if err := codec.UnmarshalMap(m, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.ID != in.ID || out.Amount.Cmp(amount) != 0 || out.Refs[0] != in.Refs[0] || out.Tags["a"] != in.Tags["a"] {
+		t.Fatalf("UnmarshalMap失败: 不是预期的值 %+v", out)
+	}
+	if out.PK != in.PK || out.CreateTime != in.CreateTime {
+		t.Fatalf("UnmarshalMap失败: 不是预期的 BaseSchema %+v", out.BaseSchema)
+	}
+
+	m["Amount"] = &dynamodb.AttributeValue{S: aws.String("1")}
+	if err := codec.UnmarshalMap(m, &out); err == nil || !strings.Contains(err.Error(), "non-N") {
+		t.Errorf("UnmarshalMap应该失败: %v", err)
+	}
+}
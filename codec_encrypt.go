@@ -0,0 +1,418 @@
+package rotor
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// 加密字段的格式: version | len(key id) | key id | len(wrapped) | wrapped data key | nonce | AES-GCM 密文
+// 明文为属性的 DynamoDB JSON, 附加数据为头部 + PK + SK + 属性名, 密文不能被移动到其他 item 或属性
+// 嵌套结构体中的加密字段绑定最外层结构体的 PK/SK
+const (
+	encryptVersion = 1
+
+	dataKeySize = 32
+)
+
+// KeyProvider 加密数据密钥的主密钥, 例如 KMS
+type KeyProvider interface {
+	// WrapKey 用当前的主密钥加密数据密钥, 返回主密钥 id 和密文
+	WrapKey(dataKey []byte) (keyID string, wrapped []byte, err error)
+	// UnwrapKey 用 keyID 对应的主密钥解密数据密钥
+	UnwrapKey(keyID string, wrapped []byte) ([]byte, error)
+}
+
+// StaticKey 本地主密钥, Secret 长度为 16, 24 或 32
+type StaticKey struct {
+	ID     string
+	Secret []byte
+}
+
+// StaticKeyProvider 使用本地密钥的 KeyProvider
+// 第一个 key 用于加密, 所有 key 都可以解密, 轮换后旧数据在下次写入时使用新 key
+type StaticKeyProvider struct {
+	mu   sync.RWMutex
+	keys []StaticKey
+}
+
+// NewStaticKeyProvider NewStaticKeyProvider
+func NewStaticKeyProvider(keys ...StaticKey) (*StaticKeyProvider, error) {
+	p := &StaticKeyProvider{}
+	for i := len(keys) - 1; i >= 0; i-- {
+		if err := p.Rotate(keys[i]); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Rotate 将 key 设为加密使用的 key, 之前的 key 仍可解密
+func (p *StaticKeyProvider) Rotate(key StaticKey) error {
+	if key.ID == "" || len(key.ID) > 255 {
+		return ErrInput
+	}
+	if _, err := aes.NewCipher(key.Secret); err != nil {
+		return ErrInput
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	keys := []StaticKey{key}
+	for _, k := range p.keys {
+		if k.ID != key.ID {
+			keys = append(keys, k)
+		}
+	}
+	p.keys = keys
+	return nil
+}
+
+// Retire 移除 key, 由它加密的数据将无法解密
+func (p *StaticKeyProvider) Retire(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	keys := make([]StaticKey, 0, len(p.keys))
+	for _, k := range p.keys {
+		if k.ID != id {
+			keys = append(keys, k)
+		}
+	}
+	p.keys = keys
+}
+
+// WrapKey WrapKey
+func (p *StaticKeyProvider) WrapKey(dataKey []byte) (string, []byte, error) {
+	p.mu.RLock()
+	if len(p.keys) == 0 {
+		p.mu.RUnlock()
+		return "", nil, ErrEncryption
+	}
+	key := p.keys[0]
+	p.mu.RUnlock()
+	gcm, err := newGCM(key.Secret)
+	if err != nil {
+		return "", nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	return key.ID, gcm.Seal(nonce, nonce, dataKey, []byte(key.ID)), nil
+}
+
+// UnwrapKey UnwrapKey
+func (p *StaticKeyProvider) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	p.mu.RLock()
+	var secret []byte
+	for _, k := range p.keys {
+		if k.ID == keyID {
+			secret = k.Secret
+		}
+	}
+	p.mu.RUnlock()
+	if secret == nil {
+		return nil, ErrEncryption
+	}
+	gcm, err := newGCM(secret)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < gcm.NonceSize() {
+		return nil, ErrEncryption
+	}
+	dataKey, err := gcm.Open(nil, wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():], []byte(keyID))
+	if err != nil {
+		return nil, ErrEncryption
+	}
+	return dataKey, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// CodecKeyProvider 设置 `rotor:"encrypt"` 字段使用的 KeyProvider
+// 加密字段每次编码的密文都不同, 不能用于条件表达式, 也不能作为主键或索引键
+// 读到没有加密的属性时返回 ErrEncryption, 已有明文数据时配合 CodecPlaintextFallback 迁移
+func CodecKeyProvider(provider KeyProvider) CodecOption {
+	return func(codec *Codec) {
+		codec.config.keyProvider = provider
+	}
+}
+
+// CodecPlaintextFallback 加密字段读到不是密文的属性时当作开启加密前写入的明文, 下次写入时加密
+// 只用于迁移已有数据, 迁移完成后应该去掉, 否则写入数据库的明文也会被接受
+func CodecPlaintextFallback() CodecOption {
+	return func(codec *Codec) {
+		codec.config.plaintextFallback = true
+	}
+}
+
+// bindsKey 是否由当前结构体设置加密字段绑定的主键, 只有最外层的结构体设置
+func (codec Codec) bindsKey() bool {
+	return codec.itemKey == nil && codec.config != nil && codec.config.keyProvider != nil
+}
+
+// keyFirst 主键字段排在前面的编码顺序, keys 为主键字段的个数
+func keyFirst(fields []field) (order []int, keys int) {
+	order = make([]int, 0, len(fields))
+	for i, f := range fields {
+		if f.Name == tablePK || f.Name == tableSK {
+			order = append(order, i)
+		}
+	}
+	keys = len(order)
+	for i, f := range fields {
+		if f.Name != tablePK && f.Name != tableSK {
+			order = append(order, i)
+		}
+	}
+	return order, keys
+}
+
+// itemDataKey 一次编码中同一个 item 的加密字段共用一个数据密钥
+type itemDataKey struct {
+	keyID   string
+	wrapped []byte
+	gcm     cipher.AEAD
+}
+
+func (c *codecConfig) newDataKey() (*itemDataKey, error) {
+	if c.keyProvider == nil {
+		return nil, fmt.Errorf("%w: no key provider", ErrEncryption)
+	}
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	keyID, wrapped, err := c.keyProvider.WrapKey(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if keyID == "" || len(keyID) > 255 || len(wrapped) > 0xffff {
+		return nil, fmt.Errorf("%w: invalid wrapped key", ErrEncryption)
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &itemDataKey{keyID: keyID, wrapped: wrapped, gcm: gcm}, nil
+}
+
+// encryptAAD 附加数据绑定 item 的主键和属性名
+func encryptAAD(header []byte, key PrimaryKeyType, name string) []byte {
+	aad := append([]byte{}, header...)
+	for _, part := range []string{keyString(key[tablePK]), keyString(key[tableSK]), name} {
+		var n [4]byte
+		binary.BigEndian.PutUint32(n[:], uint32(len(part)))
+		aad = append(append(aad, n[:]...), part...)
+	}
+	return aad
+}
+
+func keyString(av *dynamodb.AttributeValue) string {
+	switch {
+	case av == nil:
+		return ""
+	case av.S != nil:
+		return "S" + *av.S
+	case av.N != nil:
+		return "N" + *av.N
+	case av.B != nil:
+		return "B" + string(av.B)
+	}
+	return ""
+}
+
+// encryptAttribute 加密 item 中的属性 name, 绑定主键 key
+func encryptAttribute(dk *itemDataKey, item map[string]*dynamodb.AttributeValue, name string, key PrimaryKeyType) (*dynamodb.AttributeValue, error) {
+	plain, err := marshalAttributeJSON(item[name])
+	if err != nil {
+		return nil, err
+	}
+	header := []byte{encryptVersion, byte(len(dk.keyID))}
+	header = append(header, dk.keyID...)
+	header = append(header, byte(len(dk.wrapped)>>8), byte(len(dk.wrapped)))
+	header = append(header, dk.wrapped...)
+	nonce := make([]byte, dk.gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := dk.gcm.Seal(nonce, nonce, plain, encryptAAD(header, key, name))
+	return &dynamodb.AttributeValue{B: append(header, sealed...)}, nil
+}
+
+// decryptAttribute 解密 item 中的属性 name, key 为 nil 时绑定 item 自己的主键
+func (c *codecConfig) decryptAttribute(item map[string]*dynamodb.AttributeValue, name string, key PrimaryKeyType) (*dynamodb.AttributeValue, error) {
+	if key == nil {
+		key = item
+	}
+	av := item[name]
+	if av == nil || av.NULL != nil {
+		return av, nil
+	}
+	if av.B == nil {
+		if c.plaintextFallback {
+			return av, nil
+		}
+		return nil, fmt.Errorf("%w: attribute %s is not encrypted", ErrEncryption, name)
+	}
+	raw := av.B
+	if len(raw) < 2 || raw[0] != encryptVersion {
+		return nil, fmt.Errorf("%w: attribute %s is not encrypted", ErrEncryption, name)
+	}
+	idEnd := 2 + int(raw[1])
+	if len(raw) < idEnd+2 {
+		return nil, fmt.Errorf("%w: attribute %s is truncated", ErrEncryption, name)
+	}
+	keyID := string(raw[2:idEnd])
+	wrappedEnd := idEnd + 2 + int(binary.BigEndian.Uint16(raw[idEnd:]))
+	if len(raw) < wrappedEnd {
+		return nil, fmt.Errorf("%w: attribute %s is truncated", ErrEncryption, name)
+	}
+	if c.keyProvider == nil {
+		return nil, fmt.Errorf("%w: no key provider", ErrEncryption)
+	}
+	dataKey, err := c.keyProvider.UnwrapKey(keyID, raw[idEnd+2:wrappedEnd])
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	header, sealed := raw[:wrappedEnd], raw[wrappedEnd:]
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("%w: attribute %s is truncated", ErrEncryption, name)
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], encryptAAD(header, key, name))
+	if err != nil {
+		return nil, fmt.Errorf("%w: attribute %s authentication failed", ErrEncryption, name)
+	}
+	return unmarshalAttributeJSON(plain)
+}
+
+// encryptFields 加密结构体编码后的 `rotor:"encrypt"` 字段, key 为 nil 时绑定 item 自己的主键
+func (c *codecConfig) encryptFields(fields []field, item map[string]*dynamodb.AttributeValue, key PrimaryKeyType) error {
+	if key == nil {
+		key = item
+	}
+	var dk *itemDataKey
+	for _, f := range fields {
+		if !f.Rotor.Has("encrypt") {
+			continue
+		}
+		if f.Name == tablePK || f.Name == tableSK {
+			return fmt.Errorf("%w: primary key %s cannot be encrypted", ErrEncryption, f.Name)
+		}
+		av, ok := item[f.Name]
+		if !ok || av.NULL != nil {
+			continue
+		}
+		if dk == nil {
+			var err error
+			if dk, err = c.newDataKey(); err != nil {
+				return err
+			}
+		}
+		enc, err := encryptAttribute(dk, item, f.Name, key)
+		if err != nil {
+			return err
+		}
+		item[f.Name] = enc
+	}
+	return nil
+}
+
+// decryptFields 返回解密后的 item, 不修改传入的 item, key 为 nil 时绑定 item 自己的主键
+func (c *codecConfig) decryptFields(fields []field, item map[string]*dynamodb.AttributeValue, key PrimaryKeyType) (map[string]*dynamodb.AttributeValue, error) {
+	var out map[string]*dynamodb.AttributeValue
+	for _, f := range fields {
+		if !f.Rotor.Has("encrypt") {
+			continue
+		}
+		name, ok := lookupAttribute(item, f.Name)
+		if !ok {
+			continue
+		}
+		av, err := c.decryptAttribute(item, name, key)
+		if err != nil {
+			return nil, err
+		}
+		if out == nil {
+			out = make(map[string]*dynamodb.AttributeValue, len(item))
+			for k, v := range item {
+				out[k] = v
+			}
+		}
+		out[name] = av
+	}
+	if out == nil {
+		return item, nil
+	}
+	return out, nil
+}
+
+// lookupAttribute 与 lookupField 相同的匹配规则
+func lookupAttribute(item map[string]*dynamodb.AttributeValue, name string) (string, bool) {
+	if _, ok := item[name]; ok {
+		return name, true
+	}
+	for k := range item {
+		if strings.EqualFold(k, name) {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+// encryptedFields 结构体中 `rotor:"encrypt"` 的字段
+func encryptedFields(t reflect.Type) []field {
+	t = indirectType(t)
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+	var fields []field
+	for _, f := range structFields(t) {
+		if f.Rotor.Has("encrypt") {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// hasEncrypted t 或嵌套的结构体中是否有 `rotor:"encrypt"` 的字段
+func hasEncrypted(t reflect.Type, visiting map[reflect.Type]bool) bool {
+	if t == nil || visiting[t] {
+		return false
+	}
+	visiting[t] = true
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return hasEncrypted(t.Elem(), visiting)
+	case reflect.Struct:
+		for _, f := range structFields(t) {
+			if f.Rotor.Has("encrypt") || hasEncrypted(f.Type, visiting) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// withItemKey 加密字段绑定主键 key, 用于不包含主键的部分 item, 例如 Patch
+func (codec Codec) withItemKey(key PrimaryKeyType) Codec {
+	codec.itemKey = pickAttributes(key, []string{tablePK, tableSK})
+	return codec
+}
@@ -0,0 +1,122 @@
+package rotor_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/lixw1994/rotor"
+)
+
+type secretSchema struct {
+	rotor.BaseSchema
+
+	Name    string
+	Phone   string            `rotor:"encrypt"`
+	Profile map[string]string `dynamodbav:",omitempty" rotor:"encrypt"`
+	Contact *secretContact    `dynamodbav:",omitempty"`
+}
+
+type secretContact struct {
+	Email string `rotor:"encrypt"`
+}
+
+func TestCodecEncrypt(t *testing.T) {
+	provider, err := rotor.NewStaticKeyProvider(rotor.StaticKey{ID: "k1", Secret: bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec := rotor.NewCodec(rotor.CodecKeyProvider(provider))
+	in := secretSchema{
+		BaseSchema: newTestSchema("id1", "").BaseSchema,
+		Name:       "n",
+		Phone:      "13800000000",
+		Profile:    map[string]string{"city": "sh"},
+		Contact:    &secretContact{Email: "a@b.c"},
+	}
+	m, err := codec.MarshalMap(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m["Phone"].B == nil || bytes.Contains(m["Phone"].B, []byte(in.Phone)) || aws.StringValue(m["Name"].S) != "n" {
+		t.Fatalf("MarshalMap失败: 字段没有加密 %v", m)
+	}
+
+	t.Run("Encrypt-RoundTrip", func(t *testing.T) {
+		var out secretSchema
+		if err := codec.UnmarshalMap(m, &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.Phone != in.Phone || out.Profile["city"] != "sh" || out.Contact == nil || out.Contact.Email != in.Contact.Email {
+			t.Errorf("UnmarshalMap失败: 不是预期的值 %+v", out)
+		}
+	})
+	t.Run("Encrypt-Moved", func(t *testing.T) {
+		other, _ := codec.MarshalMap(secretSchema{BaseSchema: newTestSchema("id2", "").BaseSchema})
+		other["Phone"] = m["Phone"]
+		var out secretSchema
+		if err := codec.UnmarshalMap(other, &out); !errors.Is(err, rotor.ErrEncryption) {
+			t.Errorf("密文移动到其他 item 应该解密失败: %v", err)
+		}
+		// 嵌套结构体的加密字段绑定最外层的主键
+		delete(other, "Phone")
+		other["Contact"] = m["Contact"]
+		if err := codec.UnmarshalMap(other, &out); !errors.Is(err, rotor.ErrEncryption) {
+			t.Errorf("嵌套的密文移动到其他 item 应该解密失败: %v", err)
+		}
+	})
+	t.Run("Encrypt-Plaintext", func(t *testing.T) {
+		plain, err := dynamodbattribute.MarshalMap(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out secretSchema
+		if err := codec.UnmarshalMap(plain, &out); !errors.Is(err, rotor.ErrEncryption) {
+			t.Errorf("默认不接受明文: %v", err)
+		}
+		migrate := rotor.NewCodec(rotor.CodecKeyProvider(provider), rotor.CodecPlaintextFallback())
+		if err := migrate.UnmarshalMap(plain, &out); err != nil || out.Phone != in.Phone || out.Contact.Email != in.Contact.Email {
+			t.Errorf("CodecPlaintextFallback失败: 不是预期的值 %+v %v", out, err)
+		}
+	})
+	t.Run("Encrypt-Rotate", func(t *testing.T) {
+		if err := provider.Rotate(rotor.StaticKey{ID: "k2", Secret: bytes.Repeat([]byte{2}, 32)}); err != nil {
+			t.Fatal(err)
+		}
+		var out secretSchema
+		if err := codec.UnmarshalMap(m, &out); err != nil || out.Phone != in.Phone {
+			t.Errorf("轮换后旧密钥应该仍可解密: %v", err)
+		}
+		provider.Retire("k1")
+		if err := codec.UnmarshalMap(m, &out); !errors.Is(err, rotor.ErrEncryption) {
+			t.Errorf("移除密钥后应该解密失败: %v", err)
+		}
+	})
+	t.Run("Encrypt-Diff", func(t *testing.T) {
+		_, changed, err := codec.Diff(in, in)
+		if err != nil || changed {
+			t.Errorf("Diff失败: 加密字段没有变化 %v", err)
+		}
+		changedIn := in
+		changedIn.Contact = &secretContact{Email: "x@y.z"}
+		update, changed, err := codec.Diff(in, changedIn)
+		if err != nil || !changed {
+			t.Fatalf("Diff失败: 嵌套的加密字段有变化 %v", err)
+		}
+		expr, err := expression.NewBuilder().WithUpdate(update).Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := aws.StringValue(expr.Update()); !strings.HasPrefix(got, "SET #0 = :0") || len(expr.Names()) != 1 || aws.StringValue(expr.Names()["#0"]) != "Contact" {
+			t.Errorf("Diff失败: 应该整体替换 Contact %s %v", got, expr.Names())
+		}
+		_, _, err = codec.Patch(secretSchema{Phone: "1"})
+		if !errors.Is(err, rotor.ErrEncryption) {
+			t.Errorf("Patch应该需要主键: %v", err)
+		}
+	})
+}
@@ -0,0 +1,172 @@
+package rotor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+var attributeMapType = reflect.TypeOf(map[string]*dynamodb.AttributeValue{})
+
+// MarshalDynamoJSON 编码为 DynamoDB JSON, 与控制台和 aws cli 的格式相同, 例如 {"PK":{"S":"x"}}
+// in 可以是结构体或者 map[string]*dynamodb.AttributeValue
+func (codec Codec) MarshalDynamoJSON(in interface{}) ([]byte, error) {
+	item, err := codec.jsonItem(in)
+	if err != nil {
+		return nil, err
+	}
+	m, err := attributeMapJSON(item)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(m)
+}
+
+// UnmarshalDynamoJSON 解析 DynamoDB JSON 并解码到 out, out 可以是 *map[string]*dynamodb.AttributeValue
+func (codec Codec) UnmarshalDynamoJSON(data []byte, out interface{}) error {
+	item, err := unmarshalAttributeMapJSON(data)
+	if err != nil {
+		return err
+	}
+	return codec.jsonDecode(item, out)
+}
+
+// MarshalPlainJSON 编码为普通 JSON, 数字保持原有的精度, 集合编码为数组, 二进制编码为 base64 字符串
+// in 可以是结构体或者 map[string]*dynamodb.AttributeValue
+func (codec Codec) MarshalPlainJSON(in interface{}) ([]byte, error) {
+	item, err := codec.jsonItem(in)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(plainMapJSON(item))
+}
+
+// UnmarshalPlainJSON 解析普通 JSON 并解码到 out, 字符串/数组按 out 的字段类型解码为二进制/集合
+// 解码到 *map[string]*dynamodb.AttributeValue 时没有类型信息, 字符串为 S, 数组为 L
+func (codec Codec) UnmarshalPlainJSON(data []byte, out interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var m map[string]interface{}
+	if err := decoder.Decode(&m); err != nil {
+		return err
+	}
+	if m == nil {
+		return fmt.Errorf("rotor: item must be an object")
+	}
+	item := make(map[string]*dynamodb.AttributeValue, len(m))
+	for name, v := range m {
+		av, err := plainAttribute(v)
+		if err != nil {
+			return err
+		}
+		item[name] = av
+	}
+	return codec.jsonDecode(item, out)
+}
+
+func (codec Codec) jsonItem(in interface{}) (map[string]*dynamodb.AttributeValue, error) {
+	if item, ok := in.(map[string]*dynamodb.AttributeValue); ok {
+		return item, nil
+	}
+	return codec.MarshalMap(in)
+}
+
+func (codec Codec) jsonDecode(item map[string]*dynamodb.AttributeValue, out interface{}) error {
+	v := reflect.ValueOf(out)
+	if v.Kind() == reflect.Ptr && !v.IsNil() && v.Elem().Type() == attributeMapType {
+		v.Elem().Set(reflect.ValueOf(item))
+		return nil
+	}
+	return codec.UnmarshalMap(item, out)
+}
+
+// plainJSON AttributeValue 的普通 JSON 形式, N 使用 json.Number 保持精度
+func plainJSON(av *dynamodb.AttributeValue) interface{} {
+	if av == nil {
+		return nil
+	}
+	switch {
+	case av.S != nil:
+		return *av.S
+	case av.N != nil:
+		return plainNumber(*av.N)
+	case av.B != nil:
+		return av.B
+	case av.BOOL != nil:
+		return *av.BOOL
+	case av.SS != nil:
+		return aws.StringValueSlice(av.SS)
+	case av.NS != nil:
+		l := make([]interface{}, len(av.NS))
+		for i, n := range av.NS {
+			l[i] = plainNumber(aws.StringValue(n))
+		}
+		return l
+	case av.BS != nil:
+		return av.BS
+	case av.L != nil:
+		l := make([]interface{}, len(av.L))
+		for i, elem := range av.L {
+			l[i] = plainJSON(elem)
+		}
+		return l
+	case av.M != nil:
+		return plainMapJSON(av.M)
+	}
+	return nil
+}
+
+func plainMapJSON(item map[string]*dynamodb.AttributeValue) map[string]interface{} {
+	m := make(map[string]interface{}, len(item))
+	for name, av := range item {
+		m[name] = plainJSON(av)
+	}
+	return m
+}
+
+// plainNumber 不是合法 JSON 数字的 N (例如 .5) 保存为字符串
+func plainNumber(n string) interface{} {
+	if json.Valid([]byte(n)) {
+		return json.Number(n)
+	}
+	return n
+}
+
+// plainAttribute 普通 JSON 的值转换为 AttributeValue
+func plainAttribute(v interface{}) (*dynamodb.AttributeValue, error) {
+	switch v := v.(type) {
+	case nil:
+		return &dynamodb.AttributeValue{NULL: aws.Bool(true)}, nil
+	case bool:
+		return &dynamodb.AttributeValue{BOOL: aws.Bool(v)}, nil
+	case json.Number:
+		return &dynamodb.AttributeValue{N: aws.String(v.String())}, nil
+	case string:
+		return &dynamodb.AttributeValue{S: aws.String(v)}, nil
+	case []interface{}:
+		l := make([]*dynamodb.AttributeValue, len(v))
+		for i, elem := range v {
+			av, err := plainAttribute(elem)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = av
+		}
+		return &dynamodb.AttributeValue{L: l}, nil
+	case map[string]interface{}:
+		m := make(map[string]*dynamodb.AttributeValue, len(v))
+		for name, elem := range v {
+			av, err := plainAttribute(elem)
+			if err != nil {
+				return nil, err
+			}
+			m[name] = av
+		}
+		return &dynamodb.AttributeValue{M: m}, nil
+	}
+	return nil, fmt.Errorf("rotor: unsupported json value %T", v)
+}
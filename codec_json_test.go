@@ -0,0 +1,78 @@
+package rotor_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/lixw1994/rotor"
+)
+
+type jsonSchema struct {
+	rotor.BaseSchema
+
+	Amount  float64 `dynamodbav:",omitempty"`
+	Big     string  `dynamodbav:",omitempty"`
+	Count   uint64
+	Data    []byte
+	Tags    []string `dynamodbav:",stringset"`
+	Empty   []string
+	Profile map[string]interface{}
+}
+
+func TestCodecJSON(t *testing.T) {
+	codec := rotor.NewCodec()
+	in := jsonSchema{
+		Count:   18446744073709551615,
+		Data:    []byte{0, 1, 2},
+		Tags:    []string{"a", "b"},
+		Empty:   []string{},
+		Profile: map[string]interface{}{"k": "v"},
+	}
+	in.PK, in.SK = "Json#1", "Json"
+
+	for _, dialect := range []struct {
+		name      string
+		marshal   func(interface{}) ([]byte, error)
+		unmarshal func([]byte, interface{}) error
+	}{
+		{"DynamoJSON", codec.MarshalDynamoJSON, codec.UnmarshalDynamoJSON},
+		{"PlainJSON", codec.MarshalPlainJSON, codec.UnmarshalPlainJSON},
+	} {
+		data, err := dialect.marshal(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out jsonSchema
+		if err := dialect.unmarshal(data, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(in, out) {
+			t.Errorf("%s失败: 不是预期的值 %+v", dialect.name, out)
+		}
+	}
+
+	console := []byte(`{"PK":{"S":"Json#2"},"SK":{"S":"Json"},"Big":{"S":"x"},"N":{"N":"12345678901234567890.123456789"},"Set":{"NS":["1","2"]}}`)
+	var item map[string]*dynamodb.AttributeValue
+	if err := codec.UnmarshalDynamoJSON(console, &item); err != nil {
+		t.Fatal(err)
+	}
+	if len(item["Set"].NS) != 2 || *item["N"].N != "12345678901234567890.123456789" {
+		t.Errorf("UnmarshalDynamoJSON失败: 不是预期的 item %v", item)
+	}
+	plain, err := codec.MarshalPlainJSON(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(plain, []byte(`"N":12345678901234567890.123456789`)) {
+		t.Errorf("MarshalPlainJSON失败: 数字精度丢失 %s", plain)
+	}
+	data, err := codec.MarshalDynamoJSON(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(`"Set":{"NS":["1","2"]}`)) {
+		t.Errorf("MarshalDynamoJSON失败: 不是预期的 JSON %s", data)
+	}
+}
@@ -0,0 +1,637 @@
+package rotor
+
+import (
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// 编解码计划: 每个类型第一次编解码时生成一个函数并缓存在 codecConfig 中,
+// 字段的下标, tag 选项, Converter 和时间格式在生成时确定, 之后不再反射查找.
+// 计划无法处理的情况 (Marshaler/Unmarshaler, interface, 带 dynamodbav 选项的字段等)
+// 交给 encodeValue/decodeValue 或者 dynamodbattribute, 结果与之前保持一致
+
+type encodeFunc func(codec Codec, v reflect.Value) (*dynamodb.AttributeValue, error)
+
+type decodeFunc func(codec Codec, av *dynamodb.AttributeValue, v reflect.Value) error
+
+var (
+	numberType    = reflect.TypeOf(dynamodbattribute.Number(""))
+	byteSliceType = reflect.TypeOf([]byte(nil))
+)
+
+// planned Encoder/Decoder 的选项与 NewCodec 相同时才使用编解码计划
+func (codec Codec) planned() bool {
+	return codec.config != nil && codec.Encoder != nil && codec.Decoder != nil &&
+		!codec.Encoder.SupportJSONTags && codec.Encoder.EnableEmptyCollections &&
+		!codec.Decoder.SupportJSONTags && codec.Decoder.EnableEmptyCollections
+}
+
+// encoderOf 类型 t 的编码计划, 递归类型在生成完成前使用等待生成结果的占位函数
+func (c *codecConfig) encoderOf(t reflect.Type) encodeFunc {
+	if fn, ok := c.encoders.Load(t); ok {
+		return fn.(encodeFunc)
+	}
+	var (
+		wg sync.WaitGroup
+		fn encodeFunc
+	)
+	wg.Add(1)
+	placeholder, loaded := c.encoders.LoadOrStore(t, encodeFunc(func(codec Codec, v reflect.Value) (*dynamodb.AttributeValue, error) {
+		wg.Wait()
+		return fn(codec, v)
+	}))
+	if loaded {
+		return placeholder.(encodeFunc)
+	}
+	fn = c.compileEncoder(t)
+	wg.Done()
+	c.encoders.Store(t, fn)
+	return fn
+}
+
+// decoderOf 类型 t 的解码计划
+func (c *codecConfig) decoderOf(t reflect.Type) decodeFunc {
+	if fn, ok := c.decoders.Load(t); ok {
+		return fn.(decodeFunc)
+	}
+	var (
+		wg sync.WaitGroup
+		fn decodeFunc
+	)
+	wg.Add(1)
+	placeholder, loaded := c.decoders.LoadOrStore(t, decodeFunc(func(codec Codec, av *dynamodb.AttributeValue, v reflect.Value) error {
+		wg.Wait()
+		return fn(codec, av, v)
+	}))
+	if loaded {
+		return placeholder.(decodeFunc)
+	}
+	fn = c.compileDecoder(t)
+	wg.Done()
+	c.decoders.Store(t, fn)
+	return fn
+}
+
+// hasMethods 去掉指针后的类型或其指针实现了 Marshaler/Unmarshaler
+func hasMethods(t reflect.Type, iface reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		if t.Implements(iface) {
+			return true
+		}
+		t = t.Elem()
+	}
+	return t.Implements(iface) || reflect.PtrTo(t).Implements(iface)
+}
+
+// delegated 交给 encodeValue/decodeValue 处理的类型
+func delegated(t reflect.Type, iface reflect.Type) bool {
+	if t == numberType || hasMethods(t, iface) || isTimeType(t) {
+		return true
+	}
+	if t.Kind() == reflect.Struct && t.ConvertibleTo(timeType) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Array, reflect.Chan, reflect.Func, reflect.UnsafePointer, reflect.Complex64, reflect.Complex128:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.Uint8 && t != byteSliceType || t.Elem() == byteSliceType
+	}
+	return false
+}
+
+func encodeFallback(codec Codec, v reflect.Value) (*dynamodb.AttributeValue, error) {
+	return codec.encodeValue(v, nil)
+}
+
+func (c *codecConfig) compileEncoder(t reflect.Type) encodeFunc {
+	if _, ok := c.converters[t]; !ok && isProtoType(t) {
+		return protoEncoder(t)
+	}
+	if _, ok := c.converters[t]; ok || delegated(t, marshalerType) {
+		if hasMethods(t, marshalerType) && t.Kind() != reflect.Ptr {
+			// 与 dynamodbattribute 一致, 可以取地址时使用指针上的方法
+			return func(codec Codec, v reflect.Value) (*dynamodb.AttributeValue, error) {
+				if v.CanAddr() {
+					return codec.Encoder.Encode(v.Addr().Interface())
+				}
+				return codec.encodeValue(v, nil)
+			}
+		}
+		return encodeFallback
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return func(codec Codec, v reflect.Value) (*dynamodb.AttributeValue, error) {
+			return &dynamodb.AttributeValue{BOOL: aws.Bool(v.Bool())}, nil
+		}
+	case reflect.String:
+		return func(codec Codec, v reflect.Value) (*dynamodb.AttributeValue, error) {
+			s := v.String()
+			if s == "" && codec.Encoder.NullEmptyString {
+				return &dynamodb.AttributeValue{NULL: aws.Bool(true)}, nil
+			}
+			return &dynamodb.AttributeValue{S: &s}, nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(codec Codec, v reflect.Value) (*dynamodb.AttributeValue, error) {
+			return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(v.Int(), 10))}, nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return func(codec Codec, v reflect.Value) (*dynamodb.AttributeValue, error) {
+			return &dynamodb.AttributeValue{N: aws.String(strconv.FormatUint(v.Uint(), 10))}, nil
+		}
+	case reflect.Float32, reflect.Float64:
+		bits := t.Bits()
+		return func(codec Codec, v reflect.Value) (*dynamodb.AttributeValue, error) {
+			return &dynamodb.AttributeValue{N: aws.String(strconv.FormatFloat(v.Float(), 'f', -1, bits))}, nil
+		}
+	case reflect.Interface:
+		return func(codec Codec, v reflect.Value) (*dynamodb.AttributeValue, error) {
+			if v.IsNil() {
+				return &dynamodb.AttributeValue{NULL: aws.Bool(true)}, nil
+			}
+			return codec.config.encoderOf(v.Elem().Type())(codec, v.Elem())
+		}
+	case reflect.Ptr:
+		elem := c.encoderOf(t.Elem())
+		return func(codec Codec, v reflect.Value) (*dynamodb.AttributeValue, error) {
+			if v.IsNil() {
+				return &dynamodb.AttributeValue{NULL: aws.Bool(true)}, nil
+			}
+			return elem(codec, v.Elem())
+		}
+	case reflect.Slice:
+		if t == byteSliceType {
+			return func(codec Codec, v reflect.Value) (*dynamodb.AttributeValue, error) {
+				if v.IsNil() {
+					return &dynamodb.AttributeValue{NULL: aws.Bool(true)}, nil
+				}
+				return &dynamodb.AttributeValue{B: append([]byte{}, v.Bytes()...)}, nil
+			}
+		}
+		elem := c.encoderOf(t.Elem())
+		return func(codec Codec, v reflect.Value) (*dynamodb.AttributeValue, error) {
+			if v.IsNil() {
+				return &dynamodb.AttributeValue{NULL: aws.Bool(true)}, nil
+			}
+			l := make([]*dynamodb.AttributeValue, 0, v.Len())
+			for i := 0; i < v.Len(); i++ {
+				av, err := elem(codec, v.Index(i))
+				if err != nil {
+					return nil, err
+				}
+				if av != nil {
+					l = append(l, av)
+				}
+			}
+			return &dynamodb.AttributeValue{L: l}, nil
+		}
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return encodeFallback
+		}
+		elem := c.encoderOf(t.Elem())
+		return func(codec Codec, v reflect.Value) (*dynamodb.AttributeValue, error) {
+			if v.IsNil() {
+				return &dynamodb.AttributeValue{NULL: aws.Bool(true)}, nil
+			}
+			m := make(map[string]*dynamodb.AttributeValue, v.Len())
+			iter := v.MapRange()
+			for iter.Next() {
+				key := iter.Key().String()
+				if key == "" {
+					// 交给 dynamodbattribute 返回相同的错误
+					return codec.encodeValue(v, nil)
+				}
+				av, err := elem(codec, iter.Value())
+				if err != nil {
+					return nil, err
+				}
+				if av != nil {
+					m[key] = av
+				}
+			}
+			return &dynamodb.AttributeValue{M: m}, nil
+		}
+	case reflect.Struct:
+		return c.compileStructEncoder(t)
+	}
+	return encodeFallback
+}
+
+// fieldPlan 结构体字段的编解码计划
+type fieldPlan struct {
+	field
+	encode encodeFunc
+	decode decodeFunc
+}
+
+// structPlan 结构体的字段计划, hooks 表示有需要加密或压缩的字段
+// order 为编码顺序, 前 keys 个是主键字段
+type structPlan struct {
+	fields []field
+	plans  []fieldPlan
+	byName map[string]int
+	hooks  bool
+	order  []int
+	keys   int
+}
+
+func (c *codecConfig) compileStruct(t reflect.Type, encode bool) *structPlan {
+	fields := structFields(t)
+	sp := &structPlan{fields: fields, plans: make([]fieldPlan, len(fields)), byName: make(map[string]int, len(fields))}
+	sp.order, sp.keys = keyFirst(fields)
+	for i, f := range fields {
+		f := f
+		sp.byName[f.Name] = i
+		if f.Rotor.Has("encrypt") || f.Rotor.Has("compress") {
+			sp.hooks = true
+		}
+		fp := fieldPlan{field: f}
+		// field.Type 去掉了匿名指针, 计划需要字段实际的类型
+		ft := t.FieldByIndex(f.Index).Type
+		_, converted := c.converters[ft]
+		timeField := !converted && isTimeType(ft)
+		defaultTime := timeField && c.timeEncodingOf(&f) == TimeDefault
+		// 需要字段信息的情况交给 encodeValue/decodeValue
+		switch {
+		case encode && !converted && (f.avOptions() != "" || (timeField && c.timeEncodingOf(&f) != c.timeEncodingOf(nil))):
+			fp.encode = func(codec Codec, v reflect.Value) (*dynamodb.AttributeValue, error) {
+				return codec.encodeValue(v, &f)
+			}
+		case encode:
+			fp.encode = c.encoderOf(ft)
+		case defaultTime:
+			fp.decode = timeDecoder(ft, f.AsUnixTime)
+		case !converted && (timeField || f.AsString):
+			// 集合等其他 dynamodbav 选项不影响解码
+			fp.decode = func(codec Codec, av *dynamodb.AttributeValue, v reflect.Value) error {
+				return codec.decodeValue(av, v, &f)
+			}
+		default:
+			fp.decode = c.decoderOf(ft)
+		}
+		sp.plans[i] = fp
+	}
+	return sp
+}
+
+func (c *codecConfig) compileStructEncoder(t reflect.Type) encodeFunc {
+	sp := c.compileStruct(t, true)
+	return func(codec Codec, v reflect.Value) (*dynamodb.AttributeValue, error) {
+		m := make(map[string]*dynamodb.AttributeValue, len(sp.plans))
+		bind := codec.bindsKey()
+		for n, i := range sp.order {
+			if bind && n == sp.keys {
+				codec.itemKey = pickAttributes(m, []string{tablePK, tableSK})
+			}
+			fp := &sp.plans[i]
+			var fv reflect.Value
+			if len(fp.Index) == 1 {
+				fv = v.Field(fp.Index[0])
+			} else if fv, _ = fieldByIndex(v, fp.Index); !fv.IsValid() {
+				continue
+			}
+			if fp.OmitEmpty && emptyValue(fv) {
+				continue
+			}
+			av, err := fp.encode(codec, fv)
+			if err != nil {
+				return nil, err
+			}
+			if av == nil || (fp.OmitEmpty && av.NULL != nil) {
+				continue
+			}
+			m[fp.Name] = av
+		}
+		if sp.hooks {
+			if err := codec.config.compressFields(sp.fields, m); err != nil {
+				return nil, err
+			}
+			if err := codec.config.encryptFields(sp.fields, m, codec.itemKey); err != nil {
+				return nil, err
+			}
+		}
+		return &dynamodb.AttributeValue{M: m}, nil
+	}
+}
+
+func decodeFallback(codec Codec, av *dynamodb.AttributeValue, v reflect.Value) error {
+	return codec.decodeValue(av, v, nil)
+}
+
+// decodeScalar 处理不了的值交给 dynamodbattribute, 错误信息与之前相同
+func decodeScalar(ok func(av *dynamodb.AttributeValue, v reflect.Value) bool) decodeFunc {
+	return func(codec Codec, av *dynamodb.AttributeValue, v reflect.Value) error {
+		if av == nil {
+			return nil
+		}
+		if av.NULL != nil {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		if ok(av, v) {
+			return nil
+		}
+		return codec.delegateDecode(av, v, nil)
+	}
+}
+
+func (c *codecConfig) compileDecoder(t reflect.Type) decodeFunc {
+	_, converted := c.converters[t]
+	switch {
+	case !converted && t.Kind() == reflect.Struct && isProtoType(t):
+		return protoDecoder(t)
+	case !converted && t == timeType && c.timeEncoding == TimeDefault:
+		return timeDecoder(t, false)
+	case !converted && t.Kind() != reflect.Ptr && !t.Implements(unmarshalerType) && reflect.PtrTo(t).Implements(unmarshalerType):
+		return func(codec Codec, av *dynamodb.AttributeValue, v reflect.Value) error {
+			if av == nil || !v.CanAddr() {
+				return codec.decodeValue(av, v, nil)
+			}
+			return v.Addr().Interface().(dynamodbattribute.Unmarshaler).UnmarshalDynamoDBAttributeValue(av)
+		}
+	case converted || delegated(t, unmarshalerType):
+		return decodeFallback
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return decodeScalar(func(av *dynamodb.AttributeValue, v reflect.Value) bool {
+			if av.BOOL == nil {
+				return false
+			}
+			v.SetBool(*av.BOOL)
+			return true
+		})
+	case reflect.String:
+		return decodeScalar(func(av *dynamodb.AttributeValue, v reflect.Value) bool {
+			if av.S == nil {
+				return false
+			}
+			v.SetString(*av.S)
+			return true
+		})
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return decodeScalar(func(av *dynamodb.AttributeValue, v reflect.Value) bool {
+			return av.N != nil && setNumber(*av.N, v)
+		})
+	case reflect.Ptr:
+		elem := c.decoderOf(t.Elem())
+		return func(codec Codec, av *dynamodb.AttributeValue, v reflect.Value) error {
+			if av == nil {
+				return nil
+			}
+			if av.NULL != nil {
+				v.Set(reflect.Zero(t))
+				return nil
+			}
+			if v.IsNil() {
+				v.Set(reflect.New(t.Elem()))
+			}
+			return elem(codec, av, v.Elem())
+		}
+	case reflect.Slice:
+		if t == byteSliceType {
+			return decodeScalar(func(av *dynamodb.AttributeValue, v reflect.Value) bool {
+				if av.B == nil {
+					return false
+				}
+				v.SetBytes(append([]byte{}, av.B...))
+				return true
+			})
+		}
+		return c.compileListDecoder(t)
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return decodeFallback
+		}
+		elem := c.decoderOf(t.Elem())
+		return func(codec Codec, av *dynamodb.AttributeValue, v reflect.Value) error {
+			if av == nil {
+				return nil
+			}
+			if av.NULL != nil {
+				v.Set(reflect.Zero(t))
+				return nil
+			}
+			if av.M == nil {
+				return codec.delegateDecode(av, v, nil)
+			}
+			if v.IsNil() {
+				v.Set(reflect.MakeMapWithSize(t, len(av.M)))
+			}
+			// SetMapIndex 复制 key 和 elem, 可以重复使用
+			key, e, zero := reflect.New(t.Key()).Elem(), reflect.New(t.Elem()).Elem(), reflect.Zero(t.Elem())
+			for k, a := range av.M {
+				e.Set(zero)
+				if err := elem(codec, a, e); err != nil {
+					return err
+				}
+				key.SetString(k)
+				v.SetMapIndex(key, e)
+			}
+			return nil
+		}
+	case reflect.Struct:
+		return c.compileStructDecoder(t)
+	}
+	return decodeFallback
+}
+
+// setNumber 把 n 解析到数字类型的 v, 解析失败或溢出时返回 false
+func setNumber(n string, v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(n, 10, 64)
+		if err != nil || v.OverflowInt(i) {
+			return false
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(n, 10, 64)
+		if err != nil || v.OverflowUint(u) {
+			return false
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(n, 64)
+		if err != nil || v.OverflowFloat(f) {
+			return false
+		}
+		v.SetFloat(f)
+	default:
+		return false
+	}
+	return true
+}
+
+// compileListDecoder L 和集合都可以解码到 slice, 与 decodeList 相同
+// SS/NS 的元素是普通的字符串或数字时直接设置, 不再包装成 AttributeValue
+func (c *codecConfig) compileListDecoder(t reflect.Type) decodeFunc {
+	elem := c.decoderOf(t.Elem())
+	_, converted := c.converters[t.Elem()]
+	plain := !converted && !delegated(t.Elem(), unmarshalerType)
+	return func(codec Codec, av *dynamodb.AttributeValue, v reflect.Value) error {
+		if av == nil {
+			return nil
+		}
+		if av.NULL != nil {
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		n := len(av.L)
+		switch {
+		case av.SS != nil:
+			n = len(av.SS)
+		case av.NS != nil:
+			n = len(av.NS)
+		case av.BS != nil:
+			n = len(av.BS)
+		case av.L == nil:
+			return codec.delegateDecode(av, v, nil)
+		}
+		if v.IsNil() || v.Cap() < n {
+			v.Set(reflect.MakeSlice(t, n, n))
+		} else {
+			v.SetLen(n)
+		}
+		if plain && av.SS != nil && t.Elem().Kind() == reflect.String {
+			for i, s := range av.SS {
+				v.Index(i).SetString(*s)
+			}
+			return nil
+		}
+		if plain && av.NS != nil {
+			ok := true
+			for i := 0; i < n && ok; i++ {
+				ok = setNumber(*av.NS[i], v.Index(i))
+			}
+			if ok {
+				return nil
+			}
+		}
+		for i := 0; i < n; i++ {
+			var a *dynamodb.AttributeValue
+			switch {
+			case av.SS != nil:
+				a = &dynamodb.AttributeValue{S: av.SS[i]}
+			case av.NS != nil:
+				a = &dynamodb.AttributeValue{N: av.NS[i]}
+			case av.BS != nil:
+				a = &dynamodb.AttributeValue{B: av.BS[i]}
+			default:
+				a = av.L[i]
+			}
+			if err := elem(codec, a, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func (c *codecConfig) compileStructDecoder(t reflect.Type) decodeFunc {
+	sp := c.compileStruct(t, false)
+	return func(codec Codec, av *dynamodb.AttributeValue, v reflect.Value) error {
+		if av == nil {
+			return nil
+		}
+		if av.NULL != nil {
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		if av.M == nil {
+			return codec.delegateDecode(av, v, nil)
+		}
+		item := av.M
+		if codec.bindsKey() {
+			codec.itemKey = pickAttributes(item, []string{tablePK, tableSK})
+		}
+		if sp.hooks {
+			var err error
+			if item, err = codec.config.decryptFields(sp.fields, item, codec.itemKey); err != nil {
+				return err
+			}
+			if item, err = codec.config.decompressFields(sp.fields, item); err != nil {
+				return err
+			}
+		}
+		for name, a := range item {
+			i, ok := sp.byName[name]
+			if !ok {
+				f, found := lookupField(sp.fields, name)
+				if !found {
+					continue
+				}
+				i = sp.byName[f.Name]
+			}
+			fp := &sp.plans[i]
+			var fv reflect.Value
+			if len(fp.Index) == 1 {
+				fv = v.Field(fp.Index[0])
+			} else {
+				var err error
+				if fv, err = allocFieldByIndex(v, fp.Index); err != nil {
+					return err
+				}
+			}
+			if err := fp.decode(codec, a, fv); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// timeDecoder 默认格式的时间, 与 dynamodbattribute 相同: S 为 RFC3339, unixtime 字段的 N 为秒
+func timeDecoder(t reflect.Type, unix bool) decodeFunc {
+	return func(codec Codec, av *dynamodb.AttributeValue, v reflect.Value) error {
+		if av == nil {
+			return nil
+		}
+		if av.NULL != nil {
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		var (
+			tm  time.Time
+			err error
+			ok  bool
+		)
+		switch {
+		case av.S != nil:
+			tm, err = time.Parse(time.RFC3339, *av.S)
+			ok = err == nil
+		case av.N != nil && unix:
+			var sec int64
+			sec, err = strconv.ParseInt(*av.N, 10, 64)
+			tm, ok = time.Unix(sec, 0), err == nil
+		}
+		if !ok {
+			// 交给 dynamodbattribute 返回相同的错误
+			f := &field{AsUnixTime: unix}
+			return codec.delegateDecode(av, v, f)
+		}
+		if t.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(timeType))
+			}
+			v = v.Elem()
+		}
+		if v.CanAddr() {
+			*v.Addr().Interface().(*time.Time) = tm
+			return nil
+		}
+		v.Set(reflect.ValueOf(tm))
+		return nil
+	}
+}
@@ -0,0 +1,183 @@
+package rotor_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/lixw1994/rotor"
+)
+
+type planAddress struct {
+	City string
+	Zip  *int
+}
+
+type planMarshaler struct {
+	V string
+}
+
+func (m *planMarshaler) MarshalDynamoDBAttributeValue(av *dynamodb.AttributeValue) error {
+	s := "m:" + m.V
+	av.S = &s
+	return nil
+}
+
+func (m *planMarshaler) UnmarshalDynamoDBAttributeValue(av *dynamodb.AttributeValue) error {
+	if av.S != nil {
+		m.V = (*av.S)[2:]
+	}
+	return nil
+}
+
+type planNode struct {
+	Name     string
+	Children []*planNode
+}
+
+type planSchema struct {
+	rotor.BaseSchema
+
+	Name     string
+	Empty    string
+	Count    int32
+	Ratio    float32
+	Big      uint64
+	OK       bool
+	Data     []byte
+	Nums     []int            `dynamodbav:",numberset"`
+	Tags     []string         `dynamodbav:",stringset,omitempty"`
+	Address  planAddress      `dynamodbav:"addr"`
+	Previous *planAddress     `dynamodbav:",omitempty"`
+	Attrs    map[string]int64 `dynamodbav:",omitempty"`
+	Any      interface{}
+	Custom   planMarshaler
+	At       time.Time
+	Tree     planNode
+	Skip     string `dynamodbav:"-"`
+}
+
+func newPlanSchema(i int) planSchema {
+	zip := 100 + i
+	at := time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)
+	s := planSchema{
+		Name:     fmt.Sprintf("name-%d", i),
+		Count:    int32(i),
+		Ratio:    0.5,
+		Big:      1<<63 + uint64(i),
+		OK:       i%2 == 0,
+		Data:     []byte{1, 2, byte(i)},
+		Nums:     []int{1, 2},
+		Tags:     []string{"a", "b"},
+		Address:  planAddress{City: "c", Zip: &zip},
+		Attrs:    map[string]int64{"x": int64(i)},
+		Any:      map[string]interface{}{"k": "v"},
+		Custom:   planMarshaler{V: "v"},
+		At:       at,
+		Tree:     planNode{Name: "root", Children: []*planNode{{Name: "leaf"}}},
+		Previous: nil,
+	}
+	s.PK, s.SK = fmt.Sprintf("Plan#%d", i), "Plan"
+	s.SetTTL(time.Hour)
+	return s
+}
+
+func TestCodecPlan(t *testing.T) {
+	codec := rotor.NewCodec()
+	in := newPlanSchema(1)
+
+	got, err := codec.MarshalMap(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect, err := codec.Encoder.Encode(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, expect.M) {
+		t.Errorf("MarshalMap失败: 与 dynamodbattribute 不一致\n%v\n%v", got, expect.M)
+	}
+
+	var out, delegated planSchema
+	if err := codec.UnmarshalMap(got, &out); err != nil {
+		t.Fatal(err)
+	}
+	if err := codec.Decoder.Decode(&dynamodb.AttributeValue{M: got}, &delegated); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, delegated) {
+		t.Errorf("UnmarshalMap失败: 与 dynamodbattribute 不一致\n%+v\n%+v", out, delegated)
+	}
+
+	got["Count"].N = got["Big"].N
+	err = codec.UnmarshalMap(got, &out)
+	expectErr := codec.Decoder.Decode(&dynamodb.AttributeValue{M: got}, &delegated)
+	if err == nil || expectErr == nil || err.Error() != expectErr.Error() {
+		t.Errorf("UnmarshalMap失败: 错误与 dynamodbattribute 不一致 %v %v", err, expectErr)
+	}
+}
+
+func planItems(n int) []map[string]*dynamodb.AttributeValue {
+	codec := rotor.NewCodec()
+	items := make([]map[string]*dynamodb.AttributeValue, n)
+	for i := range items {
+		item, err := codec.MarshalMap(newPlanSchema(i))
+		if err != nil {
+			panic(err)
+		}
+		items[i] = item
+	}
+	return items
+}
+
+func BenchmarkUnmarshalListOfMaps(b *testing.B) {
+	items := planItems(100)
+	b.Run("dynamodbattribute", func(b *testing.B) {
+		decoder := rotor.NewCodec().Decoder
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			list := make([]*dynamodb.AttributeValue, len(items))
+			for j, item := range items {
+				list[j] = &dynamodb.AttributeValue{M: item}
+			}
+			var out []planSchema
+			if err := decoder.Decode(&dynamodb.AttributeValue{L: list}, &out); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Codec", func(b *testing.B) {
+		codec := rotor.NewCodec()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var out []planSchema
+			if err := codec.UnmarshalListOfMaps(items, &out); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkMarshalMap(b *testing.B) {
+	in := newPlanSchema(1)
+	b.Run("dynamodbattribute", func(b *testing.B) {
+		encoder := rotor.NewCodec().Encoder
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := encoder.Encode(in); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Codec", func(b *testing.B) {
+		codec := rotor.NewCodec()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := codec.MarshalMap(in); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
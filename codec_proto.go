@@ -0,0 +1,465 @@
+package rotor
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// ProtoNaming proto.Message 编码时使用的属性名
+type ProtoNaming string
+
+// ProtoNaming
+const (
+	// ProtoFieldName proto 文件中的字段名, 例如 create_time
+	ProtoFieldName ProtoNaming = ""
+	// ProtoJSONName json 名, 例如 createTime
+	ProtoJSONName ProtoNaming = "json"
+)
+
+// CodecProtoNaming 设置 proto.Message 的属性名, 解码时两种名字都可以识别
+//
+// proto.Message 按 protoreflect 编解码: 没有设置的字段不写入, oneof 只写入设置的字段,
+// 枚举为名字 (S), 64 位整数为 N 不损失精度, Timestamp 与 time.Time 的编码方式相同,
+// Duration 为 "1.5s" 形式的 S, 包装类型 (Int64Value 等) 为其中的值
+func CodecProtoNaming(naming ProtoNaming) CodecOption {
+	return func(codec *Codec) {
+		codec.config.protoNaming = naming
+	}
+}
+
+var protoMessageType = reflect.TypeOf((*proto.Message)(nil)).Elem()
+
+// isProtoType t 或者 *t 是 proto.Message
+func isProtoType(t reflect.Type) bool {
+	return t.Implements(protoMessageType) || (t.Kind() != reflect.Ptr && reflect.PtrTo(t).Implements(protoMessageType))
+}
+
+// protoEncoder proto.Message 的编码计划, t 为生成的结构体或者它的指针
+func protoEncoder(t reflect.Type) encodeFunc {
+	return func(codec Codec, v reflect.Value) (*dynamodb.AttributeValue, error) {
+		if t.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return &dynamodb.AttributeValue{NULL: aws.Bool(true)}, nil
+			}
+		} else if v.CanAddr() {
+			v = v.Addr()
+		} else {
+			p := reflect.New(t)
+			p.Elem().Set(v)
+			v = p
+		}
+		return codec.encodeProtoMessage(v.Interface().(proto.Message).ProtoReflect())
+	}
+}
+
+// protoDecoder proto.Message 的解码计划, t 为生成的结构体, 指针由 Ptr 的计划分配
+func protoDecoder(t reflect.Type) decodeFunc {
+	return func(codec Codec, av *dynamodb.AttributeValue, v reflect.Value) error {
+		if av == nil {
+			return nil
+		}
+		if !v.CanAddr() {
+			return &dynamodbattribute.InvalidUnmarshalError{Type: t}
+		}
+		m := v.Addr().Interface().(proto.Message)
+		proto.Reset(m)
+		if av.NULL != nil {
+			return nil
+		}
+		return codec.decodeProtoValue(av, m.ProtoReflect())
+	}
+}
+
+func (codec Codec) protoName(fd protoreflect.FieldDescriptor) string {
+	if codec.config.protoNaming == ProtoJSONName {
+		return fd.JSONName()
+	}
+	return string(fd.Name())
+}
+
+func (codec Codec) encodeProtoMessage(m protoreflect.Message) (*dynamodb.AttributeValue, error) {
+	if av, ok, err := codec.encodeWellKnown(m); ok {
+		return av, err
+	}
+	item := map[string]*dynamodb.AttributeValue{}
+	var err error
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		var av *dynamodb.AttributeValue
+		if av, err = codec.encodeProtoField(fd, v); err != nil {
+			return false
+		}
+		item[codec.protoName(fd)] = av
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &dynamodb.AttributeValue{M: item}, nil
+}
+
+func (codec Codec) encodeProtoField(fd protoreflect.FieldDescriptor, v protoreflect.Value) (*dynamodb.AttributeValue, error) {
+	switch {
+	case fd.IsList():
+		list := v.List()
+		l := make([]*dynamodb.AttributeValue, list.Len())
+		for i := range l {
+			av, err := codec.encodeProtoSingular(fd, list.Get(i))
+			if err != nil {
+				return nil, err
+			}
+			l[i] = av
+		}
+		return &dynamodb.AttributeValue{L: l}, nil
+	case fd.IsMap():
+		m := map[string]*dynamodb.AttributeValue{}
+		var err error
+		v.Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+			var av *dynamodb.AttributeValue
+			if av, err = codec.encodeProtoSingular(fd.MapValue(), v); err != nil {
+				return false
+			}
+			m[k.String()] = av
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &dynamodb.AttributeValue{M: m}, nil
+	}
+	return codec.encodeProtoSingular(fd, v)
+}
+
+func (codec Codec) encodeProtoSingular(fd protoreflect.FieldDescriptor, v protoreflect.Value) (*dynamodb.AttributeValue, error) {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return &dynamodb.AttributeValue{BOOL: aws.Bool(v.Bool())}, nil
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return &dynamodb.AttributeValue{S: aws.String(string(ev.Name()))}, nil
+		}
+		return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(int64(v.Enum()), 10))}, nil
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(v.Int(), 10))}, nil
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return &dynamodb.AttributeValue{N: aws.String(strconv.FormatUint(v.Uint(), 10))}, nil
+	case protoreflect.FloatKind:
+		return encodeProtoFloat(v.Float(), 32), nil
+	case protoreflect.DoubleKind:
+		return encodeProtoFloat(v.Float(), 64), nil
+	case protoreflect.StringKind:
+		return &dynamodb.AttributeValue{S: aws.String(v.String())}, nil
+	case protoreflect.BytesKind:
+		return &dynamodb.AttributeValue{B: append([]byte{}, v.Bytes()...)}, nil
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return codec.encodeProtoMessage(v.Message())
+	}
+	return nil, fmt.Errorf("rotor: unsupported proto field %s", fd.FullName())
+}
+
+// encodeProtoFloat N 不能表示 NaN 和无穷, 与 protojson 一样使用字符串
+func encodeProtoFloat(f float64, bits int) *dynamodb.AttributeValue {
+	switch {
+	case math.IsNaN(f):
+		return &dynamodb.AttributeValue{S: aws.String("NaN")}
+	case math.IsInf(f, 1):
+		return &dynamodb.AttributeValue{S: aws.String("Infinity")}
+	case math.IsInf(f, -1):
+		return &dynamodb.AttributeValue{S: aws.String("-Infinity")}
+	}
+	return &dynamodb.AttributeValue{N: aws.String(strconv.FormatFloat(f, 'f', -1, bits))}
+}
+
+// wellKnown Timestamp, Duration 和包装类型
+func wellKnown(md protoreflect.MessageDescriptor) string {
+	if md.ParentFile() == nil || md.ParentFile().Package() != "google.protobuf" {
+		return ""
+	}
+	switch name := string(md.Name()); name {
+	case "Timestamp", "Duration",
+		"DoubleValue", "FloatValue", "Int64Value", "UInt64Value", "Int32Value", "UInt32Value",
+		"BoolValue", "StringValue", "BytesValue":
+		return name
+	}
+	return ""
+}
+
+func (codec Codec) encodeWellKnown(m protoreflect.Message) (*dynamodb.AttributeValue, bool, error) {
+	fields := m.Descriptor().Fields()
+	switch wellKnown(m.Descriptor()) {
+	case "":
+		return nil, false, nil
+	case "Timestamp":
+		sec, nanos := m.Get(fields.ByNumber(1)).Int(), m.Get(fields.ByNumber(2)).Int()
+		return codec.EncodeTime(time.Unix(sec, nanos).UTC()), true, nil
+	case "Duration":
+		sec, nanos := m.Get(fields.ByNumber(1)).Int(), m.Get(fields.ByNumber(2)).Int()
+		return &dynamodb.AttributeValue{S: aws.String(formatProtoDuration(sec, nanos))}, true, nil
+	}
+	fd := fields.ByNumber(1)
+	av, err := codec.encodeProtoSingular(fd, m.Get(fd))
+	return av, true, err
+}
+
+// formatProtoDuration 与 protojson 相同, 例如 1.5s, -0.000000001s
+func formatProtoDuration(sec, nanos int64) string {
+	sign := ""
+	if sec < 0 || nanos < 0 {
+		sign, sec, nanos = "-", -sec, -nanos
+	}
+	s := sign + strconv.FormatInt(sec, 10)
+	if nanos != 0 {
+		frac := strings.TrimRight(fmt.Sprintf("%09d", nanos), "0")
+		s += "." + frac
+	}
+	return s + "s"
+}
+
+func parseProtoDuration(s string) (int64, int64, error) {
+	if !strings.HasSuffix(s, "s") {
+		return 0, 0, fmt.Errorf("rotor: invalid duration %q", s)
+	}
+	s = strings.TrimSuffix(s, "s")
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	secPart, fracPart := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		secPart, fracPart = s[:i], s[i+1:]
+	}
+	sec, err := strconv.ParseInt(secPart, 10, 64)
+	if err != nil || len(fracPart) > 9 {
+		return 0, 0, fmt.Errorf("rotor: invalid duration %q", s)
+	}
+	var nanos int64
+	if fracPart != "" {
+		if nanos, err = strconv.ParseInt(fracPart+strings.Repeat("0", 9-len(fracPart)), 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("rotor: invalid duration %q", s)
+		}
+	}
+	if neg {
+		sec, nanos = -sec, -nanos
+	}
+	return sec, nanos, nil
+}
+
+func (codec Codec) decodeProtoMessage(item map[string]*dynamodb.AttributeValue, m protoreflect.Message) error {
+	fields := m.Descriptor().Fields()
+	for name, av := range item {
+		fd := fields.ByName(protoreflect.Name(name))
+		if fd == nil {
+			fd = fields.ByJSONName(name)
+		}
+		if fd == nil || av == nil || av.NULL != nil {
+			continue
+		}
+		if od := fd.ContainingOneof(); od != nil && !od.IsSynthetic() {
+			if set := m.WhichOneof(od); set != nil {
+				return fmt.Errorf("rotor: oneof %s has both %s and %s", od.FullName(), set.Name(), fd.Name())
+			}
+		}
+		if err := codec.decodeProtoField(av, fd, m); err != nil {
+			return fmt.Errorf("rotor: decode proto field %s: %w", fd.FullName(), err)
+		}
+	}
+	return nil
+}
+
+func (codec Codec) decodeProtoField(av *dynamodb.AttributeValue, fd protoreflect.FieldDescriptor, m protoreflect.Message) error {
+	switch {
+	case fd.IsList():
+		if av.L == nil {
+			return &dynamodbattribute.UnmarshalTypeError{Value: "non-L", Type: reflect.TypeOf([]interface{}{})}
+		}
+		list := m.Mutable(fd).List()
+		for _, elem := range av.L {
+			v, err := codec.decodeProtoSingular(elem, fd, list.NewElement)
+			if err != nil {
+				return err
+			}
+			list.Append(v)
+		}
+		return nil
+	case fd.IsMap():
+		if av.M == nil {
+			return &dynamodbattribute.UnmarshalTypeError{Value: "non-M", Type: reflect.TypeOf(map[string]interface{}{})}
+		}
+		pm := m.Mutable(fd).Map()
+		for k, elem := range av.M {
+			key, err := parseProtoMapKey(k, fd.MapKey())
+			if err != nil {
+				return err
+			}
+			v, err := codec.decodeProtoSingular(elem, fd.MapValue(), pm.NewValue)
+			if err != nil {
+				return err
+			}
+			pm.Set(key, v)
+		}
+		return nil
+	}
+	v, err := codec.decodeProtoSingular(av, fd, func() protoreflect.Value { return m.NewField(fd) })
+	if err != nil {
+		return err
+	}
+	m.Set(fd, v)
+	return nil
+}
+
+func parseProtoMapKey(k string, fd protoreflect.FieldDescriptor) (protoreflect.MapKey, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(k).MapKey(), nil
+	case protoreflect.BoolKind:
+		b, err := strconv.ParseBool(k)
+		return protoreflect.ValueOfBool(b).MapKey(), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		i, err := strconv.ParseInt(k, 10, 32)
+		return protoreflect.ValueOfInt32(int32(i)).MapKey(), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		i, err := strconv.ParseInt(k, 10, 64)
+		return protoreflect.ValueOfInt64(i).MapKey(), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		u, err := strconv.ParseUint(k, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(u)).MapKey(), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		u, err := strconv.ParseUint(k, 10, 64)
+		return protoreflect.ValueOfUint64(u).MapKey(), err
+	}
+	return protoreflect.MapKey{}, fmt.Errorf("rotor: unsupported proto map key %s", fd.Kind())
+}
+
+// decodeProtoSingular newValue 分配消息类型的值
+func (codec Codec) decodeProtoSingular(av *dynamodb.AttributeValue, fd protoreflect.FieldDescriptor, newValue func() protoreflect.Value) (protoreflect.Value, error) {
+	mismatch := func(want string) (protoreflect.Value, error) {
+		return protoreflect.Value{}, fmt.Errorf("rotor: proto field %s expects %s, got %s", fd.FullName(), want, attributeType(av))
+	}
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		if av.BOOL == nil {
+			return mismatch("BOOL")
+		}
+		return protoreflect.ValueOfBool(*av.BOOL), nil
+	case protoreflect.EnumKind:
+		switch {
+		case av.S != nil:
+			ev := fd.Enum().Values().ByName(protoreflect.Name(*av.S))
+			if ev == nil {
+				return protoreflect.Value{}, fmt.Errorf("rotor: unknown enum value %s for %s", *av.S, fd.Enum().FullName())
+			}
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		case av.N != nil:
+			i, err := strconv.ParseInt(*av.N, 10, 32)
+			return protoreflect.ValueOfEnum(protoreflect.EnumNumber(i)), err
+		}
+		return mismatch("S or N")
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		if av.N == nil {
+			return mismatch("N")
+		}
+		i, err := strconv.ParseInt(*av.N, 10, 32)
+		return protoreflect.ValueOfInt32(int32(i)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		if av.N == nil {
+			return mismatch("N")
+		}
+		i, err := strconv.ParseInt(*av.N, 10, 64)
+		return protoreflect.ValueOfInt64(i), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		if av.N == nil {
+			return mismatch("N")
+		}
+		u, err := strconv.ParseUint(*av.N, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(u)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		if av.N == nil {
+			return mismatch("N")
+		}
+		u, err := strconv.ParseUint(*av.N, 10, 64)
+		return protoreflect.ValueOfUint64(u), err
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		bits := 64
+		if fd.Kind() == protoreflect.FloatKind {
+			bits = 32
+		}
+		var s string
+		switch {
+		case av.N != nil:
+			s = *av.N
+		case av.S != nil:
+			// NaN, Infinity, -Infinity
+			s = *av.S
+		default:
+			return mismatch("N")
+		}
+		f, err := strconv.ParseFloat(s, bits)
+		if bits == 32 {
+			return protoreflect.ValueOfFloat32(float32(f)), err
+		}
+		return protoreflect.ValueOfFloat64(f), err
+	case protoreflect.StringKind:
+		if av.S == nil {
+			return mismatch("S")
+		}
+		return protoreflect.ValueOfString(*av.S), nil
+	case protoreflect.BytesKind:
+		if av.B == nil {
+			return mismatch("B")
+		}
+		return protoreflect.ValueOfBytes(append([]byte{}, av.B...)), nil
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		v := newValue()
+		if err := codec.decodeProtoValue(av, v.Message()); err != nil {
+			return protoreflect.Value{}, err
+		}
+		return v, nil
+	}
+	return protoreflect.Value{}, fmt.Errorf("rotor: unsupported proto field %s", fd.FullName())
+}
+
+// decodeProtoValue 解码消息类型的值, 包括 Timestamp 等
+func (codec Codec) decodeProtoValue(av *dynamodb.AttributeValue, m protoreflect.Message) error {
+	fields := m.Descriptor().Fields()
+	switch wellKnown(m.Descriptor()) {
+	case "":
+		if av.M == nil {
+			return &dynamodbattribute.UnmarshalTypeError{Value: "non-M", Type: reflect.TypeOf(m.Interface())}
+		}
+		return codec.decodeProtoMessage(av.M, m)
+	case "Timestamp":
+		t, err := decodeTime(av, codec.config.timeEncoding)
+		if err != nil {
+			return err
+		}
+		m.Set(fields.ByNumber(1), protoreflect.ValueOfInt64(t.Unix()))
+		m.Set(fields.ByNumber(2), protoreflect.ValueOfInt32(int32(t.Nanosecond())))
+		return nil
+	case "Duration":
+		if av.S == nil {
+			return fmt.Errorf("rotor: duration expects S, got %s", attributeType(av))
+		}
+		sec, nanos, err := parseProtoDuration(*av.S)
+		if err != nil {
+			return err
+		}
+		m.Set(fields.ByNumber(1), protoreflect.ValueOfInt64(sec))
+		m.Set(fields.ByNumber(2), protoreflect.ValueOfInt32(int32(nanos)))
+		return nil
+	}
+	fd := fields.ByNumber(1)
+	v, err := codec.decodeProtoSingular(av, fd, nil)
+	if err != nil {
+		return err
+	}
+	m.Set(fd, v)
+	return nil
+}
@@ -0,0 +1,220 @@
+package rotor_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/lixw1994/rotor"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// protoUserDescriptor 相当于
+//
+//	message User {
+//	  enum Status { UNKNOWN = 0; ACTIVE = 1; }
+//	  string PK = 1; string SK = 2; string display_name = 3; int64 age = 4; Status status = 5;
+//	  repeated string tags = 6; map<string, int32> attrs = 7;
+//	  oneof contact { string email = 8; string phone = 9; }
+//	  google.protobuf.Timestamp created_at = 10; google.protobuf.Duration ttl = 11;
+//	  google.protobuf.StringValue nickname = 12;
+//	}
+func protoUserDescriptor(t *testing.T) protoreflect.MessageDescriptor {
+	optional := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+	repeated := descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, label *descriptorpb.FieldDescriptorProto_Label, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(protoJSONName(name)),
+			Number:   proto.Int32(number),
+			Type:     typ.Enum(),
+			Label:    label,
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	oneof := func(f *descriptorpb.FieldDescriptorProto) *descriptorpb.FieldDescriptorProto {
+		f.OneofIndex = proto.Int32(0)
+		return f
+	}
+	file := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("rotor_test/user.proto"),
+		Package:    proto.String("rotortest"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/timestamp.proto", "google/protobuf/duration.proto", "google/protobuf/wrappers.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("User"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("PK", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, ""),
+				field("SK", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, ""),
+				field("display_name", 3, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, ""),
+				field("age", 4, descriptorpb.FieldDescriptorProto_TYPE_INT64, optional, ""),
+				field("status", 5, descriptorpb.FieldDescriptorProto_TYPE_ENUM, optional, ".rotortest.User.Status"),
+				field("tags", 6, descriptorpb.FieldDescriptorProto_TYPE_STRING, repeated, ""),
+				field("attrs", 7, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, repeated, ".rotortest.User.AttrsEntry"),
+				oneof(field("email", 8, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, "")),
+				oneof(field("phone", 9, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, "")),
+				field("created_at", 10, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, optional, ".google.protobuf.Timestamp"),
+				field("ttl", 11, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, optional, ".google.protobuf.Duration"),
+				field("nickname", 12, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, optional, ".google.protobuf.StringValue"),
+			},
+			NestedType: []*descriptorpb.DescriptorProto{{
+				Name: proto.String("AttrsEntry"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("key", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, ""),
+					field("value", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32, optional, ""),
+				},
+				Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+			}},
+			EnumType: []*descriptorpb.EnumDescriptorProto{{
+				Name: proto.String("Status"),
+				Value: []*descriptorpb.EnumValueDescriptorProto{
+					{Name: proto.String("UNKNOWN"), Number: proto.Int32(0)},
+					{Name: proto.String("ACTIVE"), Number: proto.Int32(1)},
+				},
+			}},
+			OneofDecl: []*descriptorpb.OneofDescriptorProto{{Name: proto.String("contact")}},
+		}},
+	}
+	// 依赖的文件在导入 known 包时已经注册
+	fd, err := protodesc.NewFile(file, protoregistry.GlobalFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fd.Messages().ByName("User")
+}
+
+func protoJSONName(name string) string {
+	out := []byte{}
+	upper := false
+	for i := 0; i < len(name); i++ {
+		switch c := name[i]; {
+		case c == '_':
+			upper = true
+		case upper && 'a' <= c && c <= 'z':
+			out = append(out, c-'a'+'A')
+			upper = false
+		default:
+			out = append(out, c)
+			upper = false
+		}
+	}
+	return string(out)
+}
+
+func newProtoUser(md protoreflect.MessageDescriptor) *dynamicpb.Message {
+	m := dynamicpb.NewMessage(md)
+	fields := md.Fields()
+	m.Set(fields.ByName("PK"), protoreflect.ValueOfString("User#1"))
+	m.Set(fields.ByName("SK"), protoreflect.ValueOfString("User"))
+	m.Set(fields.ByName("display_name"), protoreflect.ValueOfString("n"))
+	m.Set(fields.ByName("age"), protoreflect.ValueOfInt64(9007199254740993))
+	m.Set(fields.ByName("status"), protoreflect.ValueOfEnum(1))
+	tags := m.Mutable(fields.ByName("tags")).List()
+	tags.Append(protoreflect.ValueOfString("a"))
+	attrs := m.Mutable(fields.ByName("attrs")).Map()
+	attrs.Set(protoreflect.ValueOfString("k").MapKey(), protoreflect.ValueOfInt32(3))
+	m.Set(fields.ByName("email"), protoreflect.ValueOfString("a@b.c"))
+	at := time.Date(2022, 3, 4, 5, 6, 7, 8, time.UTC)
+	m.Set(fields.ByName("created_at"), protoreflect.ValueOfMessage(timestamppb.New(at).ProtoReflect()))
+	m.Set(fields.ByName("ttl"), protoreflect.ValueOfMessage(durationpb.New(1500*time.Millisecond).ProtoReflect()))
+	m.Set(fields.ByName("nickname"), protoreflect.ValueOfMessage(wrapperspb.String("nick").ProtoReflect()))
+	return m
+}
+
+func TestCodecProto(t *testing.T) {
+	md := protoUserDescriptor(t)
+	in := newProtoUser(md)
+	codec := rotor.NewCodec()
+
+	item, err := codec.MarshalMap(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expect := range map[string]string{
+		"display_name": "n",
+		"status":       "ACTIVE",
+		"email":        "a@b.c",
+		"created_at":   "2022-03-04T05:06:07.000000008Z",
+		"ttl":          "1.5s",
+		"nickname":     "nick",
+	} {
+		if item[name] == nil || aws.StringValue(item[name].S) != expect {
+			t.Errorf("MarshalMap失败: %s 不是预期的值 %v", name, item[name])
+		}
+	}
+	if aws.StringValue(item["age"].N) != "9007199254740993" || item["phone"] != nil {
+		t.Errorf("MarshalMap失败: 不是预期的 item %v", item)
+	}
+	if aws.StringValue(item["attrs"].M["k"].N) != "3" || len(item["tags"].L) != 1 {
+		t.Errorf("MarshalMap失败: 不是预期的集合 %v %v", item["attrs"], item["tags"])
+	}
+
+	out := dynamicpb.NewMessage(md)
+	if err := codec.UnmarshalMap(item, out); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(in, out) {
+		t.Errorf("UnmarshalMap失败: 不是预期的值 %v", out)
+	}
+
+	jsonCodec := rotor.NewCodec(rotor.CodecProtoNaming(rotor.ProtoJSONName))
+	item, err = jsonCodec.MarshalMap(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item["displayName"] == nil || item["createdAt"] == nil {
+		t.Errorf("MarshalMap失败: 没有使用 json 名 %v", item)
+	}
+	out = dynamicpb.NewMessage(md)
+	if err := codec.UnmarshalMap(item, out); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(in, out) {
+		t.Errorf("UnmarshalMap失败: 没有识别 json 名 %v", out)
+	}
+
+	item["phone"] = item["email"]
+	if err := codec.UnmarshalMap(item, dynamicpb.NewMessage(md)); err == nil {
+		t.Error("UnmarshalMap失败: oneof 设置了两个字段应该返回错误")
+	}
+}
+
+type protoFieldSchema struct {
+	rotor.BaseSchema
+
+	At    *timestamppb.Timestamp
+	Count *wrapperspb.Int64Value
+	Wait  *durationpb.Duration
+}
+
+func TestCodecProtoField(t *testing.T) {
+	codec := rotor.NewCodec(rotor.CodecTimeEncoding(rotor.TimeUnixMilli))
+	at := time.Date(2022, 3, 4, 5, 6, 7, 8000000, time.UTC)
+	in := protoFieldSchema{At: timestamppb.New(at), Count: wrapperspb.Int64(-1), Wait: durationpb.New(-time.Nanosecond)}
+	item, err := codec.MarshalMap(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(item["At"].N) != "1646370367008" || aws.StringValue(item["Count"].N) != "-1" ||
+		aws.StringValue(item["Wait"].S) != "-0.000000001s" {
+		t.Errorf("MarshalMap失败: 不是预期的 item %v", item)
+	}
+	var out protoFieldSchema
+	if err := codec.UnmarshalMap(item, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !out.At.AsTime().Equal(at) || out.Count.GetValue() != -1 || out.Wait.AsDuration() != -time.Nanosecond {
+		t.Errorf("UnmarshalMap失败: 不是预期的值 %+v", out)
+	}
+}
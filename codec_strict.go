@@ -0,0 +1,280 @@
+package rotor
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// DecodeFieldError 一个属性的解码问题
+type DecodeFieldError struct {
+	// Path 属性路径, 例如 Profile.Tags[2]
+	Path   string
+	Reason string
+}
+
+// DecodeError 严格模式下的解码错误, 列出所有有问题的属性, errors.Is(err, ErrDecode) 成立
+type DecodeError struct {
+	Errors []DecodeFieldError
+}
+
+func (e *DecodeError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		msgs[i] = fe.Path + ": " + fe.Reason
+	}
+	return ErrDecode.Error() + ": " + strings.Join(msgs, "; ")
+}
+
+// Unwrap Unwrap
+func (e *DecodeError) Unwrap() error {
+	return ErrDecode
+}
+
+const (
+	decodeUnknown  = "unknown attribute"
+	decodeRequired = "missing required attribute"
+)
+
+// CodecStrict 严格模式, 解码时结构体中没有对应字段的属性, 缺少 `rotor:"required"` 的字段
+// 以及类型不匹配都会返回 *DecodeError
+func CodecStrict() CodecOption {
+	return func(codec *Codec) {
+		codec.config.strict = true
+	}
+}
+
+// strictOptions 严格检查的选项
+type strictOptions struct {
+	// partial 读到的是 projection 后的部分 item, 不检查顶层的必填字段
+	partial bool
+	// ignore 顶层可以没有对应字段的属性, 例如 schema 版本
+	ignore map[string]bool
+}
+
+// ServiceStrict Service 的所有读取使用严格模式, 可以用 GetStrict/QueryStrict 单次覆盖
+// schema 版本属性不算作未知属性
+func ServiceStrict() ServiceOption {
+	return func(rs *Service) {
+		rs.strict = true
+	}
+}
+
+// checkStrict 按 Service 和单次调用的设置严格检查读取到的 av
+func (rs *Service) checkStrict(av *dynamodb.AttributeValue, out interface{}, dopts decodeOptions) error {
+	t := reflect.TypeOf(out)
+	if t == nil || t.Kind() != reflect.Ptr {
+		return nil
+	}
+	return rs.checkStrictType(av, t.Elem(), dopts)
+}
+
+// checkStrictType 与 checkStrict 相同, 按类型 t 检查, 用于 QueryCollection 的单个 item
+func (rs *Service) checkStrictType(av *dynamodb.AttributeValue, t reflect.Type, dopts decodeOptions) error {
+	strict := rs.strict || rs.codec.strict()
+	if dopts.strict != nil {
+		strict = *dopts.strict
+	}
+	if !strict || t == nil {
+		return nil
+	}
+	opts := strictOptions{partial: dopts.partial}
+	if rs.schemas != nil {
+		opts.ignore = map[string]bool{rs.schemas.attr: true}
+	}
+	return rs.codec.checkStrict(av, t, opts)
+}
+
+func (codec Codec) strict() bool {
+	return codec.config != nil && codec.config.strict
+}
+
+// checkStrict 检查 av 能否严格地解码为 t, 有问题时返回 *DecodeError
+func (codec Codec) checkStrict(av *dynamodb.AttributeValue, t reflect.Type, opts strictOptions) error {
+	var errs []DecodeFieldError
+	codec.checkValue(av, t, nil, "", 0, opts, &errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	return &DecodeError{Errors: errs}
+}
+
+func (codec Codec) checkValue(av *dynamodb.AttributeValue, t reflect.Type, f *field, path string, depth int, opts strictOptions, errs *[]DecodeFieldError) {
+	if av == nil || av.NULL != nil || t == nil {
+		return
+	}
+	mismatch := func() {
+		*errs = append(*errs, DecodeFieldError{
+			Path:   path,
+			Reason: fmt.Sprintf("type mismatch: %s cannot decode into %s", attributeType(av), t),
+		})
+	}
+	if _, ok := codec.converter(t); ok {
+		return
+	}
+	if reflect.PtrTo(t).Implements(unmarshalerType) || t.Implements(unmarshalerType) {
+		return
+	}
+	if isProtoType(t) {
+		// 属性名与 Go 字段名不同, 由解码时检查
+		return
+	}
+	if f != nil && (f.Rotor.Has("encrypt") || f.Rotor.Has("compress")) {
+		// 存储形式与字段类型不同, 由解码时检查
+		return
+	}
+	if isTimeType(t) {
+		if av.S == nil && av.N == nil {
+			mismatch()
+		}
+		return
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		codec.checkValue(av, t.Elem(), f, path, depth, opts, errs)
+	case reflect.Interface:
+	case reflect.Bool:
+		if av.BOOL == nil {
+			mismatch()
+		}
+	case reflect.String:
+		if av.S == nil {
+			mismatch()
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		n := av.N
+		if n == nil && av.S != nil && f != nil && f.AsString {
+			n = av.S
+		}
+		if n == nil {
+			mismatch()
+			return
+		}
+		if reason := checkNumber(*n, t); reason != "" {
+			*errs = append(*errs, DecodeFieldError{Path: path, Reason: reason})
+		}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// 与 dynamodbattribute 相同, S 按 base64 解码
+			if av.B == nil && av.S == nil {
+				mismatch()
+			}
+			return
+		}
+		switch {
+		case av.L != nil:
+			// 顶层的 list 是读取到的多个 item, 元素仍然按顶层检查
+			for i, elem := range av.L {
+				codec.checkValue(elem, t.Elem(), nil, fmt.Sprintf("%s[%d]", path, i), depth, opts, errs)
+			}
+		case av.NS != nil:
+			for i, n := range av.NS {
+				codec.checkValue(&dynamodb.AttributeValue{N: n}, t.Elem(), nil, fmt.Sprintf("%s[%d]", path, i), depth, opts, errs)
+			}
+		case av.SS == nil && av.BS == nil:
+			mismatch()
+		}
+	case reflect.Map:
+		if av.M == nil {
+			mismatch()
+			return
+		}
+		for _, name := range attributeNames(av.M) {
+			codec.checkValue(av.M[name], t.Elem(), nil, keyPath(path, name), depth+1, opts, errs)
+		}
+	case reflect.Struct:
+		if av.M == nil {
+			mismatch()
+			return
+		}
+		codec.checkStruct(av.M, t, path, depth, opts, errs)
+	}
+}
+
+func (codec Codec) checkStruct(item map[string]*dynamodb.AttributeValue, t reflect.Type, path string, depth int, opts strictOptions, errs *[]DecodeFieldError) {
+	fields := structFields(t)
+	for _, name := range attributeNames(item) {
+		f, ok := lookupField(fields, name)
+		if !ok {
+			if depth == 0 && opts.ignore[name] {
+				continue
+			}
+			*errs = append(*errs, DecodeFieldError{Path: joinPath(path, name), Reason: decodeUnknown})
+			continue
+		}
+		codec.checkValue(item[name], f.Type, f, joinPath(path, name), depth+1, opts, errs)
+	}
+	if depth == 0 && opts.partial {
+		return
+	}
+	for _, f := range fields {
+		if !f.Rotor.Has("required") {
+			continue
+		}
+		name, ok := lookupAttribute(item, f.Name)
+		if !ok || item[name].NULL != nil {
+			*errs = append(*errs, DecodeFieldError{Path: joinPath(path, f.Name), Reason: decodeRequired})
+		}
+	}
+}
+
+// checkNumber 检查数字 n 能否无损地解码为 t, 有问题时返回原因
+func checkNumber(n string, t reflect.Type) string {
+	var err error
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		_, err = strconv.ParseInt(n, 10, t.Bits())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if strings.HasPrefix(n, "-") {
+			if _, err := strconv.ParseInt(n, 10, 64); err == nil {
+				return fmt.Sprintf("number %s overflows %s", n, t)
+			}
+		}
+		_, err = strconv.ParseUint(n, 10, t.Bits())
+	case reflect.Float32, reflect.Float64:
+		_, err = strconv.ParseFloat(n, t.Bits())
+	}
+	if err == nil {
+		return ""
+	}
+	if errors.Is(err, strconv.ErrRange) {
+		return fmt.Sprintf("number %s overflows %s", n, t)
+	}
+	if t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64 {
+		return fmt.Sprintf("%s is not a number", n)
+	}
+	return fmt.Sprintf("number %s is not an integer for %s", n, t)
+}
+
+// attributeType AttributeValue 的类型名
+func attributeType(av *dynamodb.AttributeValue) string {
+	switch {
+	case av.S != nil:
+		return "S"
+	case av.N != nil:
+		return "N"
+	case av.B != nil:
+		return "B"
+	case av.BOOL != nil:
+		return "BOOL"
+	case av.NULL != nil:
+		return "NULL"
+	case av.SS != nil:
+		return "SS"
+	case av.NS != nil:
+		return "NS"
+	case av.BS != nil:
+		return "BS"
+	case av.L != nil:
+		return "L"
+	case av.M != nil:
+		return "M"
+	}
+	return "empty"
+}
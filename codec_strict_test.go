@@ -0,0 +1,191 @@
+package rotor
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+type strictTestAddress struct {
+	City string `rotor:"required"`
+	Zip  int
+}
+
+type strictTestUser struct {
+	BaseSchema
+
+	Name    string `rotor:"required"`
+	Age     int
+	Address strictTestAddress
+	Tags    []string
+}
+
+func strictTestItem() map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"PK":   {S: aws.String("User#1")},
+		"SK":   {S: aws.String("User")},
+		"Name": {S: aws.String("n")},
+		"Age":  {N: aws.String("3")},
+		"Address": {M: map[string]*dynamodb.AttributeValue{
+			"City": {S: aws.String("c")},
+			"Zip":  {N: aws.String("1")},
+		}},
+		"Tags": {SS: []*string{aws.String("a")}},
+	}
+}
+
+func TestCodecStrict(t *testing.T) {
+	codec := NewCodec(CodecStrict())
+	var user strictTestUser
+	if err := codec.UnmarshalMap(strictTestItem(), &user); err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "n" || user.Address.City != "c" || len(user.Tags) != 1 {
+		t.Errorf("UnmarshalMap失败: 不是预期的值 %+v", user)
+	}
+
+	item := strictTestItem()
+	delete(item, "Name")
+	item["Extra"] = &dynamodb.AttributeValue{S: aws.String("x")}
+	item["Age"] = &dynamodb.AttributeValue{S: aws.String("3")}
+	item["Address"].M["Zip"] = &dynamodb.AttributeValue{BOOL: aws.Bool(true)}
+	delete(item["Address"].M, "City")
+	item["Tags"] = &dynamodb.AttributeValue{L: []*dynamodb.AttributeValue{{S: aws.String("a")}, {N: aws.String("1")}}}
+
+	err := codec.UnmarshalMap(item, &user)
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || !errors.Is(err, ErrDecode) {
+		t.Fatalf("UnmarshalMap失败: 不是 DecodeError %v", err)
+	}
+	var paths []string
+	for _, fe := range decodeErr.Errors {
+		paths = append(paths, fe.Path)
+	}
+	expect := []string{"Address.Zip", "Address.City", "Age", "Extra", "Tags[1]", "Name"}
+	if !reflect.DeepEqual(paths, expect) {
+		t.Errorf("UnmarshalMap失败: 不是预期的路径 %v", paths)
+	}
+
+	if err := NewCodec().UnmarshalMap(item, &user); err != nil && errors.Is(err, ErrDecode) {
+		t.Errorf("UnmarshalMap失败: 非严格模式不应该返回 DecodeError %v", err)
+	}
+}
+
+func TestServiceStrict(t *testing.T) {
+	registry := NewSchemaRegistry("")
+	if err := registry.Register(strictTestUser{}); err != nil {
+		t.Fatal(err)
+	}
+	rs := &Service{codec: NewCodec(), tableName: aws.String("test"), schemas: registry}
+	ServiceStrict()(rs)
+	ctx := context.TODO()
+
+	item := strictTestItem()
+	item[defaultSchemaVersionAttr] = &dynamodb.AttributeValue{N: aws.String("0")}
+	var users []strictTestUser
+	if err := rs.decodeItems(ctx, []map[string]*dynamodb.AttributeValue{item}, &users, decodeOptions{}); err != nil {
+		t.Errorf("decodeItems失败: schema 版本不应该算作未知属性 %v", err)
+	}
+
+	item["Extra"] = &dynamodb.AttributeValue{S: aws.String("x")}
+	err := rs.decodeItems(ctx, []map[string]*dynamodb.AttributeValue{item}, &users, decodeOptions{})
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || len(decodeErr.Errors) != 1 || decodeErr.Errors[0].Path != "[0].Extra" {
+		t.Errorf("decodeItems失败: 不是预期的错误 %v", err)
+	}
+
+	var user strictTestUser
+	if err := rs.decodeItem(ctx, item, &user, decodeOptions{strict: aws.Bool(false)}); err != nil {
+		t.Errorf("decodeItem失败: 单次调用应该可以关闭严格模式 %v", err)
+	}
+
+	partial := map[string]*dynamodb.AttributeValue{"Age": {N: aws.String("1")}}
+	if err := rs.decodeItem(ctx, partial, &user, decodeOptions{partial: true}); err != nil {
+		t.Errorf("decodeItem失败: projection 不应该检查必填字段 %v", err)
+	}
+}
+
+func TestQueryStrict(t *testing.T) {
+	var items []map[string]*dynamodb.AttributeValue
+	rs, _ := newFakeService(func(op string, input interface{}) (interface{}, error) {
+		return &dynamodb.QueryOutput{Items: items}, nil
+	}, ServiceStrict())
+	ctx := context.TODO()
+	keyCond := expression.Key(tablePK).Equal(expression.Value("User#1"))
+
+	// KEYS_ONLY 的索引只返回主键
+	items = []map[string]*dynamodb.AttributeValue{{"PK": {S: aws.String("User#1")}, "SK": {S: aws.String("User")}}}
+	var users []strictTestUser
+	if err := rs.Query(ctx, keyCond, &users, QueryIndex("GSI1")); err != nil {
+		t.Errorf("Query失败: 索引不应该检查必填字段 %v", err)
+	}
+	var decodeErr *DecodeError
+	if err := rs.Query(ctx, keyCond, &users); !errors.As(err, &decodeErr) {
+		t.Errorf("Query失败: 缺少必填字段应该返回 DecodeError %v", err)
+	}
+
+	item := strictTestItem()
+	item["Extra"] = &dynamodb.AttributeValue{S: aws.String("x")}
+	items = []map[string]*dynamodb.AttributeValue{item}
+	registry := NewCollectionRegistry("").RegisterPrefix("User", strictTestUser{})
+	var collection struct{ Users []strictTestUser }
+	err := rs.QueryCollection(ctx, keyCond, registry, &collection)
+	if !errors.As(err, &decodeErr) || len(decodeErr.Errors) != 1 || decodeErr.Errors[0].Path != "Extra" {
+		t.Errorf("QueryCollection失败: 不是预期的错误 %v", err)
+	}
+	if err := rs.QueryCollection(ctx, keyCond, registry, &collection, QueryStrict(false)); err != nil || len(collection.Users) != 1 {
+		t.Errorf("QueryCollection失败: 单次调用应该可以关闭严格模式 %v", err)
+	}
+}
+
+func TestCodecStrictNumber(t *testing.T) {
+	type numbers struct {
+		Small  int8
+		Count  uint
+		Ratio  float32
+		Scores []int `dynamodbav:",numberset"`
+		Level  int   `dynamodbav:",string"`
+	}
+	codec := NewCodec(CodecStrict())
+	item := map[string]*dynamodb.AttributeValue{
+		"Small":  {N: aws.String("127")},
+		"Count":  {N: aws.String("3")},
+		"Ratio":  {N: aws.String("0.5")},
+		"Scores": {NS: aws.StringSlice([]string{"1", "2"})},
+		"Level":  {S: aws.String("4")},
+	}
+	var out numbers
+	if err := codec.UnmarshalMap(item, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	item["Small"] = &dynamodb.AttributeValue{N: aws.String("128")}
+	item["Count"] = &dynamodb.AttributeValue{N: aws.String("-1")}
+	item["Ratio"] = &dynamodb.AttributeValue{N: aws.String("1e40")}
+	item["Scores"] = &dynamodb.AttributeValue{NS: aws.StringSlice([]string{"1", "2.5"})}
+	item["Level"] = &dynamodb.AttributeValue{S: aws.String("4.0")}
+	err := codec.UnmarshalMap(item, &out)
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("UnmarshalMap失败: 不是 DecodeError %v", err)
+	}
+	var got []string
+	for _, fe := range decodeErr.Errors {
+		got = append(got, fe.Path+": "+fe.Reason)
+	}
+	expect := []string{
+		"Count: number -1 overflows uint",
+		"Level: number 4.0 is not an integer for int",
+		"Ratio: number 1e40 overflows float32",
+		"Scores[1]: number 2.5 is not an integer for int",
+		"Small: number 128 overflows int8",
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("UnmarshalMap失败: 不是预期的错误 %v", got)
+	}
+}
@@ -0,0 +1,100 @@
+package rotor
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// TimeEncoding time.Time 的编码方式
+type TimeEncoding string
+
+const (
+	// TimeDefault dynamodbattribute 的默认行为, RFC3339 字符串, 小数位数不固定, 不能按字符串排序
+	TimeDefault TimeEncoding = ""
+	// TimeRFC3339 UTC 的 RFC3339 字符串, 固定 9 位小数, 字符串顺序与时间顺序一致, 可以作为排序键
+	TimeRFC3339 TimeEncoding = "rfc3339"
+	// TimeUnix 秒级时间戳, 可以作为 TTL 属性
+	TimeUnix TimeEncoding = "unix"
+	// TimeUnixMilli 毫秒级时间戳
+	TimeUnixMilli TimeEncoding = "unixmilli"
+)
+
+// sortableTimeLayout 固定宽度的 RFC3339
+const sortableTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func isTimeType(t reflect.Type) bool {
+	return t == timeType || (t.Kind() == reflect.Ptr && t.Elem() == timeType)
+}
+
+// CodecTimeEncoding 设置 time.Time 默认的编码方式
+// 字段可以用 `rotor:"time=unix"` 单独指定, 优先级高于 dynamodbav 的 unixtime 和默认值
+func CodecTimeEncoding(enc TimeEncoding) CodecOption {
+	return func(codec *Codec) {
+		codec.config.timeEncoding = enc
+	}
+}
+
+// timeEncodingOf 字段 f 上 time.Time 的编码方式, 返回 TimeDefault 时交给 dynamodbattribute
+func (c *codecConfig) timeEncodingOf(f *field) TimeEncoding {
+	if f != nil {
+		if enc, ok := f.Rotor["time"]; ok {
+			return TimeEncoding(enc)
+		}
+		if f.AsUnixTime {
+			return TimeDefault
+		}
+	}
+	return c.timeEncoding
+}
+
+// EncodeTime 按默认的编码方式编码 t, 用于在条件表达式中与 time.Time 字段比较
+// 例如 expression.Key("SK").GreaterThan(expression.Value(codec.EncodeTime(t)))
+func (codec Codec) EncodeTime(t time.Time) *dynamodb.AttributeValue {
+	enc := TimeDefault
+	if codec.config != nil {
+		enc = codec.config.timeEncoding
+	}
+	av, err := encodeTime(t, enc)
+	if err != nil {
+		av, _ = codec.Encoder.Encode(t)
+	}
+	return av
+}
+
+func encodeTime(t time.Time, enc TimeEncoding) (*dynamodb.AttributeValue, error) {
+	switch enc {
+	case TimeRFC3339:
+		return &dynamodb.AttributeValue{S: aws.String(t.UTC().Format(sortableTimeLayout))}, nil
+	case TimeUnix:
+		return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(t.Unix(), 10))}, nil
+	case TimeUnixMilli:
+		return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10))}, nil
+	}
+	return nil, fmt.Errorf("rotor: unknown time encoding %q", enc)
+}
+
+// decodeTime 按属性的实际类型解码, 数值按 enc 区分秒和毫秒, 编码方式变更后旧数据仍然可以读取
+func decodeTime(av *dynamodb.AttributeValue, enc TimeEncoding) (time.Time, error) {
+	switch {
+	case av.S != nil:
+		return time.Parse(time.RFC3339Nano, *av.S)
+	case av.N != nil:
+		n, err := strconv.ParseInt(*av.N, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if enc == TimeUnixMilli {
+			return time.Unix(0, n*int64(time.Millisecond)), nil
+		}
+		return time.Unix(n, 0), nil
+	}
+	return time.Time{}, &dynamodbattribute.UnmarshalTypeError{Value: "non-S/N", Type: timeType}
+}
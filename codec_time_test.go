@@ -0,0 +1,54 @@
+package rotor_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/lixw1994/rotor"
+)
+
+type timeSchema struct {
+	rotor.BaseSchema
+
+	At      time.Time
+	Seen    *time.Time `rotor:"time=unixmilli"`
+	Settled time.Time  `dynamodbav:",omitempty" rotor:"time=unix"`
+}
+
+func TestCodecTimeEncoding(t *testing.T) {
+	codec := rotor.NewCodec(rotor.CodecTimeEncoding(rotor.TimeRFC3339))
+	at := time.Date(2022, 3, 4, 5, 6, 7, 8000, time.FixedZone("CST", 8*3600))
+	seen := at.Add(time.Second)
+	in := timeSchema{At: at, Seen: &seen}
+	in.SetTTL(time.Hour)
+	m, err := codec.MarshalMap(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(m["At"].S) != "2022-03-03T21:06:07.000008000Z" {
+		t.Errorf("MarshalMap失败: 不是预期的时间 %v", m["At"])
+	}
+	if aws.StringValue(m["Seen"].N) != "1646341568000" {
+		t.Errorf("MarshalMap失败: 不是预期的毫秒时间戳 %v", m["Seen"])
+	}
+	if m["ExpireTime"] == nil || m["ExpireTime"].N == nil {
+		t.Errorf("MarshalMap失败: unixtime 应该保持秒级时间戳 %v", m["ExpireTime"])
+	}
+	if _, ok := m["Settled"]; !ok {
+		t.Errorf("MarshalMap失败: 零值 time.Time 不是 omitempty 的空值")
+	}
+
+	var out timeSchema
+	if err := codec.UnmarshalMap(m, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !out.At.Equal(at) || out.Seen == nil || !out.Seen.Equal(seen.Truncate(time.Millisecond)) || out.ExpireTime == nil {
+		t.Errorf("UnmarshalMap失败: 不是预期的值 %+v", out)
+	}
+
+	earlier := codec.EncodeTime(at.Add(-time.Nanosecond * 999))
+	if !(aws.StringValue(earlier.S) < aws.StringValue(m["At"].S)) {
+		t.Errorf("EncodeTime失败: 字符串顺序与时间顺序不一致 %v", earlier)
+	}
+}
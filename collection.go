@@ -0,0 +1,194 @@
+package rotor
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// CollectionRegistry 单表设计下同一个分区内不同类型 item 的注册表
+// 优先按类型属性的值匹配, 其次按 SK 前缀匹配 (最长前缀优先)
+type CollectionRegistry struct {
+	typeAttr string
+	byType   map[string]reflect.Type
+	prefixes []collectionPrefix
+}
+
+type collectionPrefix struct {
+	prefix string
+	typ    reflect.Type
+}
+
+// NewCollectionRegistry NewCollectionRegistry
+// typeAttr 为保存类型名的属性, 为空时只按 SK 前缀匹配
+func NewCollectionRegistry(typeAttr string) *CollectionRegistry {
+	return &CollectionRegistry{
+		typeAttr: typeAttr,
+		byType:   map[string]reflect.Type{},
+	}
+}
+
+// RegisterType 类型属性值为 typeValue 的 item 解码为 sample 的类型
+// sample 为指针时解码到 []interface{} 的元素也是指针
+func (r *CollectionRegistry) RegisterType(typeValue string, sample interface{}) *CollectionRegistry {
+	r.byType[typeValue] = reflect.TypeOf(sample)
+	return r
+}
+
+// RegisterPrefix SK 以 skPrefix 开头的 item 解码为 sample 的类型
+func (r *CollectionRegistry) RegisterPrefix(skPrefix string, sample interface{}) *CollectionRegistry {
+	r.prefixes = append(r.prefixes, collectionPrefix{prefix: skPrefix, typ: reflect.TypeOf(sample)})
+	sort.SliceStable(r.prefixes, func(i, j int) bool {
+		return len(r.prefixes[i].prefix) > len(r.prefixes[j].prefix)
+	})
+	return r
+}
+
+func (r *CollectionRegistry) resolve(item map[string]*dynamodb.AttributeValue) (reflect.Type, bool) {
+	if r.typeAttr != "" {
+		if av, ok := item[r.typeAttr]; ok && av.S != nil {
+			if typ, ok := r.byType[*av.S]; ok {
+				return typ, true
+			}
+		}
+	}
+	if av, ok := item[tableSK]; ok && av.S != nil {
+		for _, p := range r.prefixes {
+			if strings.HasPrefix(*av.S, p.prefix) {
+				return p.typ, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Decode 将 items 按注册的类型解码到 out, 未注册的 item 会被忽略
+// out 可以是 *[]interface{}, 也可以是结构体指针: 字段类型为 []T, []*T, T 或 *T,
+// 其中 T 为注册的类型, 单值字段保存最后一个匹配的 item
+func (r *CollectionRegistry) Decode(codec Codec, items []map[string]*dynamodb.AttributeValue, out interface{}) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return ErrInput
+	}
+	rv = rv.Elem()
+	switch {
+	case rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Interface:
+		for _, item := range items {
+			typ, ok := r.resolve(item)
+			if !ok {
+				continue
+			}
+			v, err := decodeCollectionItem(codec, item, typ)
+			if err != nil {
+				return err
+			}
+			if !v.Type().AssignableTo(rv.Type().Elem()) {
+				return ErrInput
+			}
+			rv.Set(reflect.Append(rv, v))
+		}
+		return nil
+	case rv.Kind() == reflect.Struct:
+		fields := collectionFields(rv.Type())
+		for _, item := range items {
+			typ, ok := r.resolve(item)
+			if !ok {
+				continue
+			}
+			base := typ
+			if base.Kind() == reflect.Ptr {
+				base = base.Elem()
+			}
+			index, ok := fields[base]
+			if !ok {
+				continue
+			}
+			field := rv.FieldByIndex(index)
+			v, err := decodeCollectionItem(codec, item, base)
+			if err != nil {
+				return err
+			}
+			setCollectionField(field, v)
+		}
+		return nil
+	default:
+		return ErrInput
+	}
+}
+
+// collectionFields 结构体中可以接收类型 T 的字段: []T, []*T, T, *T
+func collectionFields(t reflect.Type) map[reflect.Type][]int {
+	fields := map[reflect.Type][]int{}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		ft := sf.Type
+		if ft.Kind() == reflect.Slice {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if _, ok := fields[ft]; !ok {
+			fields[ft] = sf.Index
+		}
+	}
+	return fields
+}
+
+func decodeCollectionItem(codec Codec, item map[string]*dynamodb.AttributeValue, typ reflect.Type) (reflect.Value, error) {
+	if typ.Kind() == reflect.Ptr {
+		v := reflect.New(typ.Elem())
+		if err := codec.UnmarshalMap(item, v.Interface()); err != nil {
+			return reflect.Value{}, err
+		}
+		return v, nil
+	}
+	v := reflect.New(typ)
+	if err := codec.UnmarshalMap(item, v.Interface()); err != nil {
+		return reflect.Value{}, err
+	}
+	return v.Elem(), nil
+}
+
+// setCollectionField v 为 T 类型的值
+func setCollectionField(field reflect.Value, v reflect.Value) {
+	ft := field.Type()
+	if ft.Kind() == reflect.Slice {
+		elem := v
+		if ft.Elem().Kind() == reflect.Ptr {
+			elem = reflect.New(v.Type())
+			elem.Elem().Set(v)
+		}
+		field.Set(reflect.Append(field, elem))
+		return
+	}
+	if ft.Kind() == reflect.Ptr {
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		field.Set(p)
+		return
+	}
+	field.Set(v)
+}
+
+type collectionOut struct {
+	registry *CollectionRegistry
+	out      interface{}
+}
+
+// QueryCollection query items of different types
+// 每个 item 按 registry 解码为对应的类型, out 的形式见 CollectionRegistry.Decode
+func (rs *Service) QueryCollection(ctx context.Context, keyCond expression.KeyConditionBuilder, registry *CollectionRegistry, out interface{}, opts ...QueryOption) error {
+	if registry == nil {
+		return ErrInput
+	}
+	_, _, err := rs.query(ctx, keyCond, &collectionOut{registry: registry, out: out}, opts...)
+	return err
+}
@@ -0,0 +1,77 @@
+package rotor_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/lixw1994/rotor"
+)
+
+type customer struct {
+	rotor.BaseSchema
+	Name string
+}
+
+type order struct {
+	rotor.BaseSchema
+	Amount int64
+}
+
+type address struct {
+	rotor.BaseSchema
+	City string
+}
+
+func TestCollectionDecode(t *testing.T) {
+	codec := rotor.NewCodec()
+	items := []map[string]*dynamodb.AttributeValue{}
+	for _, in := range []interface{}{
+		customer{BaseSchema: rotor.BaseSchema{PK: "C#1", SK: "C#1"}, Name: "n1"},
+		order{BaseSchema: rotor.BaseSchema{PK: "C#1", SK: "ORDER#1"}, Amount: 1},
+		order{BaseSchema: rotor.BaseSchema{PK: "C#1", SK: "ORDER#2"}, Amount: 2},
+		address{BaseSchema: rotor.BaseSchema{PK: "C#1", SK: "ADDR#1"}, City: "c1"},
+		address{BaseSchema: rotor.BaseSchema{PK: "C#1", SK: "UNKNOWN#1"}},
+	} {
+		item, err := codec.MarshalMap(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, item)
+	}
+	registry := rotor.NewCollectionRegistry("").
+		RegisterPrefix("C#", customer{}).
+		RegisterPrefix("ORDER#", &order{}).
+		RegisterPrefix("ADDR#", address{})
+
+	var out struct {
+		Customer  *customer
+		Orders    []order
+		Addresses []*address
+	}
+	if err := registry.Decode(codec, items, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Customer == nil || out.Customer.Name != "n1" {
+		t.Errorf("Customer不是预期的值: %v", out.Customer)
+	}
+	if len(out.Orders) != 2 || out.Orders[1].Amount != 2 {
+		t.Errorf("Orders不是预期的值: %v", out.Orders)
+	}
+	if len(out.Addresses) != 1 || out.Addresses[0].City != "c1" {
+		t.Errorf("Addresses不是预期的值: %v", out.Addresses)
+	}
+
+	var all []interface{}
+	if err := registry.Decode(codec, items, &all); err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 4 {
+		t.Fatalf("不是预期的长度: %d", len(all))
+	}
+	if _, ok := all[0].(customer); !ok {
+		t.Errorf("不是预期的类型: %T", all[0])
+	}
+	if _, ok := all[1].(*order); !ok {
+		t.Errorf("不是预期的类型: %T", all[1])
+	}
+}
@@ -0,0 +1,164 @@
+package rotor
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+const shardedCounterAttr = "Count"
+
+// ShardedCounter 分片计数器
+// 热点计数分散写到 N 个分片 item (PK#shard{i}, SK), 汇总 item (PK, SK) 保存 Rollup 合并后的值
+type ShardedCounter struct {
+	rs     *Service
+	pk     string
+	sk     string
+	shards int
+	// intn 选择分片, 默认使用 math/rand 的全局随机源
+	intn func(n int) int
+}
+
+// ShardedCounterOption ShardedCounterOption
+type ShardedCounterOption func(c *ShardedCounter)
+
+// ShardedCounterSource 使用 src 选择分片, 例如测试时使用固定种子
+func ShardedCounterSource(src rand.Source) ShardedCounterOption {
+	return func(c *ShardedCounter) {
+		r := rand.New(src)
+		var mu sync.Mutex
+		c.intn = func(n int) int {
+			mu.Lock()
+			defer mu.Unlock()
+			return r.Intn(n)
+		}
+	}
+}
+
+type shardedCounterItem struct {
+	PK    string `dynamodbav:"PK"`
+	Count int64
+}
+
+// ShardedCounter 创建分片计数器, shards 范围为 [1, 499]
+func (rs *Service) ShardedCounter(pk, sk string, shards int, opts ...ShardedCounterOption) (*ShardedCounter, error) {
+	if shards <= 0 || shards >= maxReadNum {
+		return nil, ErrInput
+	}
+	c := &ShardedCounter{
+		rs:     rs,
+		pk:     pk,
+		sk:     sk,
+		shards: shards,
+		intn:   rand.Intn,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+func (c *ShardedCounter) shardKey(i int) PrimaryKeyType {
+	return PrimaryKey(fmt.Sprintf("%s#shard%d", c.pk, i), c.sk)
+}
+
+func (c *ShardedCounter) keys() []PrimaryKeyType {
+	keys := make([]PrimaryKeyType, 0, c.shards+1)
+	keys = append(keys, PrimaryKey(c.pk, c.sk))
+	for i := 0; i < c.shards; i++ {
+		keys = append(keys, c.shardKey(i))
+	}
+	return keys
+}
+
+// Add 随机选择一个分片加上 delta
+func (c *ShardedCounter) Add(ctx context.Context, delta int64) error {
+	_, err := c.rs.Increment(ctx, c.shardKey(c.intn(c.shards)), shardedCounterAttr, delta)
+	return err
+}
+
+// Total 汇总 item 和所有分片的和
+// 使用非事务的 GetBatch 读取, 与 Rollup 并发时可能重复计算或者漏掉正在合并的值,
+// 需要准确值时应避免同时执行 Rollup
+func (c *ShardedCounter) Total(ctx context.Context, opts ...GetOption) (int64, error) {
+	items, err := c.load(ctx, opts...)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, item := range items {
+		total += item.Count
+	}
+	return total, nil
+}
+
+func (c *ShardedCounter) load(ctx context.Context, opts ...GetOption) ([]shardedCounterItem, error) {
+	opts = append([]GetOption{
+		GetProjection(expression.NamesList(expression.Name(tablePK), expression.Name(shardedCounterAttr))),
+	}, opts...)
+	var items []shardedCounterItem
+	if err := c.rs.GetBatch(ctx, c.keys(), &items, opts...); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// Rollup 把分片的值合并到汇总 item
+// 每个事务从分片减去读到的值并加到汇总 item, 期间的并发 Add 不会丢失
+func (c *ShardedCounter) Rollup(ctx context.Context) error {
+	items, err := c.load(ctx, GetConsistent(true))
+	if err != nil {
+		return err
+	}
+	var updates []TransactUpdateItem
+	var sum int64
+	flush := func() error {
+		if len(updates) == 0 {
+			return nil
+		}
+		builder := expression.NewBuilder().WithUpdate(
+			expression.Add(expression.Name(shardedCounterAttr), expression.Value(sum)))
+		updates = append(updates, TransactUpdateItem{Key: PrimaryKey(c.pk, c.sk), Builder: &builder})
+		err := c.rs.Transact(ctx, func(options *TransactOptions) {
+			options.UpdateItems = append(options.UpdateItems, updates...)
+		})
+		updates, sum = nil, 0
+		return err
+	}
+	for _, item := range items {
+		if item.PK == c.pk || item.Count == 0 {
+			continue
+		}
+		builder := expression.NewBuilder().WithUpdate(
+			expression.Add(expression.Name(shardedCounterAttr), expression.Value(-item.Count)))
+		updates = append(updates, TransactUpdateItem{Key: PrimaryKey(item.PK, c.sk), Builder: &builder})
+		sum += item.Count
+		if len(updates) == maxWriteNum-1 {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// RunRollup 每隔 interval 执行一次 Rollup, 直到 ctx 结束
+// 单次 Rollup 的错误交给 onError 处理, 不会中断循环
+func (c *ShardedCounter) RunRollup(ctx context.Context, interval time.Duration, onError func(error)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := c.Rollup(ctx); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
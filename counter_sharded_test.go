@@ -0,0 +1,97 @@
+package rotor
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func counterItem(pk string, count string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{"PK": {S: aws.String(pk)}, "Count": {N: aws.String(count)}}
+}
+
+func TestShardedCounter(t *testing.T) {
+	rs, fake := newFakeService(func(op string, input interface{}) (interface{}, error) {
+		switch op {
+		case "UpdateItem":
+			return &dynamodb.UpdateItemOutput{Attributes: map[string]*dynamodb.AttributeValue{"Count": {N: aws.String("1")}}}, nil
+		case "BatchGetItem":
+			return &dynamodb.BatchGetItemOutput{Responses: map[string][]map[string]*dynamodb.AttributeValue{
+				"test": {counterItem("Like#1", "10"), counterItem("Like#1#shard0", "3"), counterItem("Like#1#shard1", "0"), counterItem("Like#1#shard2", "4")},
+			}}, nil
+		case "TransactWriteItems":
+			return &dynamodb.TransactWriteItemsOutput{}, nil
+		}
+		return nil, errors.New(op)
+	})
+	ctx := context.TODO()
+	if _, err := rs.ShardedCounter("Like#1", "Like", 0); !errors.Is(err, ErrInput) {
+		t.Errorf("ShardedCounter失败: 分片数为 0 应该返回 ErrInput %v", err)
+	}
+	c, err := rs.ShardedCounter("Like#1", "Like", 3, ShardedCounterSource(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pk := aws.StringValue(c.shardKey(2)[tablePK].S); pk != "Like#1#shard2" {
+		t.Errorf("shardKey失败: 不是预期的主键 %s", pk)
+	}
+
+	t.Run("Add", func(t *testing.T) {
+		expect := rand.New(rand.NewSource(1))
+		for i := 0; i < 5; i++ {
+			if err := c.Add(ctx, 2); err != nil {
+				t.Fatal(err)
+			}
+			input := fake.calls[len(fake.calls)-1].input.(*dynamodb.UpdateItemInput)
+			pk := aws.StringValue(input.Key[tablePK].S)
+			if shard := aws.StringValue(c.shardKey(expect.Intn(3))[tablePK].S); pk != shard {
+				t.Errorf("Add失败: 不是预期的分片 %s %s", pk, shard)
+			}
+			if aws.StringValue(input.Key[tableSK].S) != "Like" || strings.TrimSpace(aws.StringValue(input.UpdateExpression)) != "ADD #0 :0" {
+				t.Errorf("Add失败: 不是预期的请求 %v", input)
+			}
+		}
+	})
+
+	t.Run("Total", func(t *testing.T) {
+		total, err := c.Total(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != 17 {
+			t.Errorf("Total失败: 不是预期的值 %d", total)
+		}
+		input := fake.calls[len(fake.calls)-1].input.(*dynamodb.BatchGetItemInput)
+		if keys := input.RequestItems["test"].Keys; len(keys) != 4 || aws.StringValue(keys[0][tablePK].S) != "Like#1" {
+			t.Errorf("Total失败: 不是预期的 keys %v", keys)
+		}
+	})
+
+	t.Run("Rollup", func(t *testing.T) {
+		if err := c.Rollup(ctx); err != nil {
+			t.Fatal(err)
+		}
+		input := fake.calls[len(fake.calls)-1].input.(*dynamodb.TransactWriteItemsInput)
+		var got []string
+		for _, item := range input.TransactItems {
+			update := item.Update
+			got = append(got, aws.StringValue(update.Key[tablePK].S)+" "+aws.StringValue(update.ExpressionAttributeValues[":0"].N))
+		}
+		sort.Strings(got)
+		expect := []string{"Like#1 7", "Like#1#shard0 -3", "Like#1#shard2 -4"}
+		if !reflect.DeepEqual(got, expect) {
+			t.Errorf("Rollup失败: 不是预期的更新 %v", got)
+		}
+		get := fake.calls[len(fake.calls)-2].input.(*dynamodb.BatchGetItemInput)
+		if !aws.BoolValue(get.RequestItems["test"].ConsistentRead) {
+			t.Error("Rollup失败: 应该使用强一致读")
+		}
+	})
+}
@@ -0,0 +1,305 @@
+package rotor
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// cursor 格式: version | flags | len(key id) | key id | body [| hmac]
+// body 明文为 sha256(查询绑定信息) + 主键 json, 加密时 body 为 nonce + AES-GCM 密文
+const (
+	cursorVersion = 1
+
+	cursorFlagSigned    = 1 << 0
+	cursorFlagEncrypted = 1 << 1
+)
+
+// CursorKey 游标密钥
+type CursorKey struct {
+	ID     string
+	Secret []byte
+}
+
+// CursorKeyRing 游标密钥环
+// 第一个 key 用于生成新游标, 所有 key 都可以校验游标, 轮换时旧 key 保留到对应游标过期即可
+type CursorKeyRing struct {
+	mu      sync.RWMutex
+	keys    []CursorKey
+	encrypt bool
+}
+
+// NewCursorKeyRing NewCursorKeyRing
+// encrypt 为 true 时游标使用 AES-GCM 加密, 否则只做 HMAC 签名
+func NewCursorKeyRing(encrypt bool, keys ...CursorKey) (*CursorKeyRing, error) {
+	ring := &CursorKeyRing{encrypt: encrypt}
+	for i := len(keys) - 1; i >= 0; i-- {
+		if err := ring.Rotate(keys[i]); err != nil {
+			return nil, err
+		}
+	}
+	return ring, nil
+}
+
+// Rotate 将 key 设为生成游标的 key, 之前的 key 仍可校验游标
+func (ring *CursorKeyRing) Rotate(key CursorKey) error {
+	if key.ID == "" || len(key.ID) > 255 || len(key.Secret) == 0 {
+		return ErrInput
+	}
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	keys := []CursorKey{key}
+	for _, k := range ring.keys {
+		if k.ID != key.ID {
+			keys = append(keys, k)
+		}
+	}
+	ring.keys = keys
+	return nil
+}
+
+// Retire 移除 key, 由它生成的游标将无法通过校验
+func (ring *CursorKeyRing) Retire(id string) {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	keys := make([]CursorKey, 0, len(ring.keys))
+	for _, k := range ring.keys {
+		if k.ID != id {
+			keys = append(keys, k)
+		}
+	}
+	ring.keys = keys
+}
+
+func (ring *CursorKeyRing) active() (CursorKey, bool) {
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+	if len(ring.keys) == 0 {
+		return CursorKey{}, false
+	}
+	return ring.keys[0], true
+}
+
+func (ring *CursorKeyRing) lookup(id string) (CursorKey, bool) {
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+	for _, k := range ring.keys {
+		if k.ID == id {
+			return k, true
+		}
+	}
+	return CursorKey{}, false
+}
+
+// deriveKey 从 secret 派生出用途不同的子密钥, 签名和加密不共用同一个密钥
+func (key CursorKey) deriveKey(purpose string) []byte {
+	mac := hmac.New(sha256.New, key.Secret)
+	mac.Write([]byte("rotor:cursor:" + purpose))
+	return mac.Sum(nil)
+}
+
+type cursorValue struct {
+	S *string `json:"S,omitempty"`
+	N *string `json:"N,omitempty"`
+	B []byte  `json:"B,omitempty"`
+}
+
+// cursorBinding 游标绑定的查询信息: 表, 索引, 方向和 key condition
+func cursorBinding(input *dynamodb.QueryInput) ([]byte, error) {
+	keyCond, err := resolveKeyCondition(input.KeyConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+	forward := input.ScanIndexForward == nil || *input.ScanIndexForward
+	h := sha256.New()
+	h.Write([]byte(aws.StringValue(input.TableName)))
+	h.Write([]byte{0})
+	h.Write([]byte(aws.StringValue(input.IndexName)))
+	h.Write([]byte{0})
+	h.Write([]byte(strconv.FormatBool(forward)))
+	h.Write([]byte{0})
+	h.Write([]byte(keyCond))
+	return h.Sum(nil), nil
+}
+
+// resolveKeyCondition 把 expression 中的 #N/:N 占位符替换成真实的名字和值.
+// 占位符的编号依赖 filter/projection, 只有替换后的结果才是稳定的
+func resolveKeyCondition(expr *string, names map[string]*string, values map[string]*dynamodb.AttributeValue) (string, error) {
+	s := aws.StringValue(expr)
+	var buf strings.Builder
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c != '#' && c != ':' {
+			buf.WriteByte(c)
+			i++
+			continue
+		}
+		end := i + 1
+		for end < len(s) && isTokenChar(s[end]) {
+			end++
+		}
+		token := s[i:end]
+		if c == '#' {
+			name, ok := names[token]
+			if !ok {
+				return "", ErrCursor
+			}
+			buf.WriteString(strconv.Quote(aws.StringValue(name)))
+		} else {
+			av, ok := values[token]
+			if !ok {
+				return "", ErrCursor
+			}
+			b, err := json.Marshal(av)
+			if err != nil {
+				return "", err
+			}
+			buf.Write(b)
+		}
+		i = end
+	}
+	return buf.String(), nil
+}
+
+func isTokenChar(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+func (rs *Service) encodeCursor(input *dynamodb.QueryInput, key PrimaryKeyType) (string, error) {
+	binding, err := cursorBinding(input)
+	if err != nil {
+		return "", err
+	}
+	values := make(map[string]cursorValue, len(key))
+	for name, av := range key {
+		values[name] = cursorValue{S: av.S, N: av.N, B: av.B}
+	}
+	keyJSON, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	body := append(binding, keyJSON...)
+
+	header := []byte{cursorVersion, 0, 0}
+	if rs.cursorRing == nil {
+		return base64.RawURLEncoding.EncodeToString(append(header, body...)), nil
+	}
+	ck, ok := rs.cursorRing.active()
+	if !ok {
+		return "", ErrCursor
+	}
+	header = append([]byte{cursorVersion, 0, byte(len(ck.ID))}, ck.ID...)
+	if rs.cursorRing.encrypt {
+		header[1] = cursorFlagEncrypted
+		gcm, err := newCursorGCM(ck)
+		if err != nil {
+			return "", err
+		}
+		nonce := make([]byte, gcm.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		sealed := gcm.Seal(nonce, nonce, body, header)
+		return base64.RawURLEncoding.EncodeToString(append(header, sealed...)), nil
+	}
+	header[1] = cursorFlagSigned
+	mac := hmac.New(sha256.New, ck.deriveKey("mac"))
+	mac.Write(header)
+	mac.Write(body)
+	raw := append(append(header, body...), mac.Sum(nil)...)
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func (rs *Service) decodeCursor(input *dynamodb.QueryInput, cursor string) (PrimaryKeyType, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(raw) < 3 || raw[0] != cursorVersion {
+		return nil, ErrCursor
+	}
+	flags := raw[1]
+	idLen := int(raw[2])
+	if len(raw) < 3+idLen {
+		return nil, ErrCursor
+	}
+	header, rest := raw[:3+idLen], raw[3+idLen:]
+	id := string(header[3:])
+
+	var body []byte
+	switch {
+	case rs.cursorRing == nil:
+		if flags != 0 {
+			return nil, ErrCursor
+		}
+		body = rest
+	case flags == cursorFlagSigned && !rs.cursorRing.encrypt:
+		ck, ok := rs.cursorRing.lookup(id)
+		if !ok || len(rest) < sha256.Size {
+			return nil, ErrCursor
+		}
+		body = rest[:len(rest)-sha256.Size]
+		mac := hmac.New(sha256.New, ck.deriveKey("mac"))
+		mac.Write(header)
+		mac.Write(body)
+		if !hmac.Equal(rest[len(rest)-sha256.Size:], mac.Sum(nil)) {
+			return nil, ErrCursor
+		}
+	case flags == cursorFlagEncrypted && rs.cursorRing.encrypt:
+		ck, ok := rs.cursorRing.lookup(id)
+		if !ok {
+			return nil, ErrCursor
+		}
+		gcm, err := newCursorGCM(ck)
+		if err != nil {
+			return nil, err
+		}
+		if len(rest) < gcm.NonceSize() {
+			return nil, ErrCursor
+		}
+		body, err = gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], header)
+		if err != nil {
+			return nil, ErrCursor
+		}
+	default:
+		return nil, ErrCursor
+	}
+	return decodeCursorBody(input, body)
+}
+
+func decodeCursorBody(input *dynamodb.QueryInput, body []byte) (PrimaryKeyType, error) {
+	if len(body) < sha256.Size {
+		return nil, ErrCursor
+	}
+	binding, err := cursorBinding(input)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(binding, body[:sha256.Size]) {
+		return nil, ErrCursor
+	}
+	values := map[string]cursorValue{}
+	if err := json.Unmarshal(body[sha256.Size:], &values); err != nil || len(values) == 0 {
+		return nil, ErrCursor
+	}
+	key := make(PrimaryKeyType, len(values))
+	for name, v := range values {
+		key[name] = &dynamodb.AttributeValue{S: v.S, N: v.N, B: v.B}
+	}
+	return key, nil
+}
+
+func newCursorGCM(key CursorKey) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key.deriveKey("enc"))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,113 @@
+package rotor
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+func testQueryInput(t *testing.T, pk string) *dynamodb.QueryInput {
+	expr, err := expression.NewBuilder().
+		WithKeyCondition(expression.Key(tablePK).Equal(expression.Value(pk))).
+		WithFilter(expression.Equal(expression.Name("TestV"), expression.Value("v1"))).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &dynamodb.QueryInput{
+		TableName:                 aws.String("test"),
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}
+}
+
+func TestCursor(t *testing.T) {
+	key := PrimaryKey("Test#id1", "Test")
+	for _, encrypt := range []bool{false, true} {
+		ring, err := NewCursorKeyRing(encrypt, CursorKey{ID: "k1", Secret: []byte("secret1")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs := &Service{tableName: aws.String("test"), cursorRing: ring}
+		cursor, err := rs.encodeCursor(testQueryInput(t, "Test#id1"), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := rs.decodeCursor(testQueryInput(t, "Test#id1"), cursor)
+		if err != nil {
+			t.Fatalf("decode失败: %v", err)
+		}
+		if aws.StringValue(got[tableSK].S) != "Test" || aws.StringValue(got[tablePK].S) != "Test#id1" {
+			t.Fatalf("decode失败: 不是预期的值 %v", got)
+		}
+		// 换了 key condition 的查询不能使用
+		if _, err := rs.decodeCursor(testQueryInput(t, "Test#id2"), cursor); !errors.Is(err, ErrCursor) {
+			t.Errorf("replay应该失败: %v", err)
+		}
+		// 篡改
+		tampered := []byte(cursor)
+		tampered[len(tampered)/2] ^= 1
+		if _, err := rs.decodeCursor(testQueryInput(t, "Test#id1"), string(tampered)); err == nil {
+			t.Error("篡改的游标应该失败")
+		}
+		// 轮换后旧游标可用, 退役后不可用
+		if err := ring.Rotate(CursorKey{ID: "k2", Secret: []byte("secret2")}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rs.decodeCursor(testQueryInput(t, "Test#id1"), cursor); err != nil {
+			t.Errorf("轮换后旧游标应该可用: %v", err)
+		}
+		ring.Retire("k1")
+		if _, err := rs.decodeCursor(testQueryInput(t, "Test#id1"), cursor); !errors.Is(err, ErrCursor) {
+			t.Errorf("退役后旧游标应该失败: %v", err)
+		}
+	}
+}
+
+func TestCursorBinding(t *testing.T) {
+	rs := &Service{tableName: aws.String("test")}
+	cursor, err := rs.encodeCursor(testQueryInput(t, "Test#id1"), PrimaryKey("Test#id1", "Test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// filter 先编号, 改变 filter 会改变 key condition 的占位符编号, 游标仍然可用
+	expr, err := expression.NewBuilder().
+		WithKeyCondition(expression.Key(tablePK).Equal(expression.Value("Test#id1"))).
+		WithFilter(expression.Equal(expression.Name("TestA"), expression.Value("a")).
+			And(expression.Equal(expression.Name("TestB"), expression.Value("b")))).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String("test"),
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}
+	if _, err := rs.decodeCursor(input, cursor); err != nil {
+		t.Errorf("改变filter后游标应该可用: %v", err)
+	}
+	// 方向不同
+	input = testQueryInput(t, "Test#id1")
+	input.ScanIndexForward = aws.Bool(false)
+	if _, err := rs.decodeCursor(input, cursor); !errors.Is(err, ErrCursor) {
+		t.Errorf("方向不同的游标应该失败: %v", err)
+	}
+	input.ScanIndexForward = aws.Bool(true)
+	if _, err := rs.decodeCursor(input, cursor); err != nil {
+		t.Errorf("ScanIndexForward 为 true 和默认值相同: %v", err)
+	}
+	// 索引不同
+	input = testQueryInput(t, "Test#id1")
+	input.IndexName = aws.String("GSI")
+	if _, err := rs.decodeCursor(input, cursor); !errors.Is(err, ErrCursor) {
+		t.Errorf("索引不同的游标应该失败: %v", err)
+	}
+}
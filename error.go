@@ -1,6 +1,12 @@
 package rotor
 
-import "errors"
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
 
 // error
 var (
@@ -9,4 +15,57 @@ var (
 	ErrItemNotFound     = errors.New("rotor:ErrItemNotFound")
 	ErrConditionalCheck = errors.New("rotor:ErrConditionalCheck")
 	ErrReturnValue      = errors.New("rotor:ErrReturnValue")
+	ErrCursor           = errors.New("rotor:ErrCursor")
+	ErrCounterBound     = errors.New("rotor:ErrCounterBound")
+	ErrEncryption       = errors.New("rotor:ErrEncryption")
+	ErrItemSize         = errors.New("rotor:ErrItemSize")
+	ErrBlobNotFound     = errors.New("rotor:ErrBlobNotFound")
+	ErrDecode           = errors.New("rotor:ErrDecode")
+	ErrValidation       = errors.New("rotor:ErrValidation")
 )
+
+// ConditionalCheckError 条件检查失败, 带有失败时的 item
+// errors.Is(err, ErrConditionalCheck) 仍然成立
+type ConditionalCheckError struct {
+	// Item 条件检查失败时的 item, item 不存在时为 nil
+	Item map[string]*dynamodb.AttributeValue
+	rs   *Service
+}
+
+func (e *ConditionalCheckError) Error() string {
+	return ErrConditionalCheck.Error()
+}
+
+// Unwrap Unwrap
+func (e *ConditionalCheckError) Unwrap() error {
+	return ErrConditionalCheck
+}
+
+// Decode 将失败时的 item 解码到 out, item 不存在时返回 ErrItemNotFound
+func (e *ConditionalCheckError) Decode(out interface{}) error {
+	return e.DecodeContext(context.Background(), out)
+}
+
+// DecodeContext 与 Get 相同, 解码前升级 schema, 加载转存的对象, 解密并调用 AfterLoad
+func (e *ConditionalCheckError) DecodeContext(ctx context.Context, out interface{}) error {
+	if e.Item == nil {
+		return ErrItemNotFound
+	}
+	if e.rs == nil {
+		return NewCodec().UnmarshalMap(e.Item, out)
+	}
+	return e.rs.decodeItem(ctx, e.Item, out, decodeOptions{})
+}
+
+// conditionalCheckFailed 事务中第 i 个请求是否因为条件检查失败
+func conditionalCheckFailed(err error, i int) (*dynamodb.CancellationReason, bool) {
+	var canceled *dynamodb.TransactionCanceledException
+	if !errors.As(err, &canceled) || i >= len(canceled.CancellationReasons) {
+		return nil, false
+	}
+	reason := canceled.CancellationReasons[i]
+	if reason == nil || aws.StringValue(reason.Code) != dynamodb.BatchStatementErrorCodeEnumConditionalCheckFailed {
+		return nil, false
+	}
+	return reason, true
+}
@@ -0,0 +1,92 @@
+package rotor
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// fakeCall 一次 dynamodb 请求
+type fakeCall struct {
+	op    string
+	input interface{}
+}
+
+// fakeDynamo 不发出请求, 由 handle 按操作名返回输出, 例如 *dynamodb.GetItemOutput, 或者错误
+type fakeDynamo struct {
+	mu     sync.Mutex
+	calls  []fakeCall
+	handle func(op string, input interface{}) (interface{}, error)
+}
+
+func (f *fakeDynamo) ops() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ops := make([]string, len(f.calls))
+	for i, c := range f.calls {
+		ops[i] = c.op
+	}
+	return ops
+}
+
+// waitCalls 等待后台请求, 最多 1 秒, 返回此时的全部请求
+func (f *fakeDynamo) waitCalls(n int) []fakeCall {
+	deadline := time.Now().Add(time.Second)
+	for {
+		f.mu.Lock()
+		calls := append([]fakeCall{}, f.calls...)
+		f.mu.Unlock()
+		if len(calls) >= n || time.Now().After(deadline) {
+			return calls
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// newFakeService 返回使用 fakeDynamo 的 Service, 表名为 test
+func newFakeService(handle func(op string, input interface{}) (interface{}, error), opts ...ServiceOption) (*Service, *fakeDynamo) {
+	fake := &fakeDynamo{handle: handle}
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String("http://localhost"),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	}))
+	rs := New(sess, "test", opts...)
+	handlers := &rs.dynamo.Handlers
+	handlers.Send.Clear()
+	handlers.ValidateResponse.Clear()
+	handlers.UnmarshalMeta.Clear()
+	handlers.Unmarshal.Clear()
+	handlers.UnmarshalError.Clear()
+	handlers.Send.PushBack(func(r *request.Request) {
+		fake.mu.Lock()
+		fake.calls = append(fake.calls, fakeCall{op: r.Operation.Name, input: r.Params})
+		fake.mu.Unlock()
+		out, err := fake.handle(r.Operation.Name, r.Params)
+		r.HTTPResponse = &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: ioutil.NopCloser(&bytes.Buffer{})}
+		if err != nil {
+			r.HTTPResponse.StatusCode = http.StatusBadRequest
+			r.Error = err
+			return
+		}
+		if out != nil {
+			reflect.ValueOf(r.Data).Elem().Set(reflect.ValueOf(out).Elem())
+		}
+	})
+	return rs, fake
+}
+
+// fakeError dynamodb 返回的错误, 例如 ConditionalCheckFailedException
+func fakeError(code string) error {
+	return awserr.New(code, code, nil)
+}
@@ -0,0 +1,248 @@
+package rotor
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// field 与 dynamodbattribute 的字段规则保持一致: 导出字段, 匿名结构体展开, dynamodbav tag 改名
+type field struct {
+	Name        string
+	NameFromTag bool
+	Index       []int
+	Type        reflect.Type
+
+	OmitEmpty     bool
+	OmitEmptyElem bool
+	AsString      bool
+	AsBinSet      bool
+	AsNumSet      bool
+	AsStrSet      bool
+	AsUnixTime    bool
+
+	// rotor tag, 例如 `rotor:"insertonly"`
+	Rotor rotorTag
+}
+
+// rotorTag rotor tag 的选项, 形如 name 或 name=value, 逗号分隔
+// regex=value 的值可能包含逗号, 只能作为最后一个选项
+type rotorTag map[string]string
+
+// Has 是否设置了选项 name
+func (t rotorTag) Has(name string) bool {
+	_, ok := t[name]
+	return ok
+}
+
+func parseRotorTag(tagStr string) rotorTag {
+	if tagStr == "" {
+		return nil
+	}
+	t := rotorTag{}
+	for rest := tagStr; rest != ""; {
+		opt := strings.TrimSpace(rest)
+		rest = ""
+		if !strings.HasPrefix(opt, "regex=") {
+			if i := strings.IndexByte(opt, ','); i >= 0 {
+				opt, rest = strings.TrimSpace(opt[:i]), opt[i+1:]
+			}
+		}
+		if opt == "" {
+			continue
+		}
+		if i := strings.IndexByte(opt, '='); i >= 0 {
+			t[opt[:i]] = opt[i+1:]
+			continue
+		}
+		t[opt] = ""
+	}
+	return t
+}
+
+var fieldCache sync.Map // reflect.Type => []field
+
+// structFields 返回结构体的可见字段, 结果会被缓存
+func structFields(t reflect.Type) []field {
+	if cached, ok := fieldCache.Load(t); ok {
+		return cached.([]field)
+	}
+	fields := enumFields(t)
+	sort.Slice(fields, func(i, j int) bool {
+		x, y := fields[i], fields[j]
+		if x.Name != y.Name {
+			return x.Name < y.Name
+		}
+		if len(x.Index) != len(y.Index) {
+			return len(x.Index) < len(y.Index)
+		}
+		if x.NameFromTag != y.NameFromTag {
+			return x.NameFromTag
+		}
+		return indexLess(x.Index, y.Index)
+	})
+	fields = visibleFields(fields)
+	cached, _ := fieldCache.LoadOrStore(t, fields)
+	return cached.([]field)
+}
+
+func enumFields(t reflect.Type) []field {
+	current := []field{}
+	next := []field{{Type: t}}
+	count := map[reflect.Type]int{}
+	nextCount := map[reflect.Type]int{}
+	visited := map[reflect.Type]struct{}{}
+	fields := []field{}
+
+	for len(next) > 0 {
+		current, next = next, current[:0]
+		count, nextCount = nextCount, map[reflect.Type]int{}
+
+		for _, f := range current {
+			if _, ok := visited[f.Type]; ok {
+				continue
+			}
+			visited[f.Type] = struct{}{}
+
+			for i := 0; i < f.Type.NumField(); i++ {
+				sf := f.Type.Field(i)
+				if sf.PkgPath != "" && !sf.Anonymous {
+					continue
+				}
+				sub, ignore := parseField(sf)
+				if ignore {
+					continue
+				}
+				ft := sf.Type
+				if ft.Name() == "" && ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				sub.Index = append(append([]int{}, f.Index...), i)
+				sub.Type = ft
+
+				if !sf.Anonymous || ft.Kind() != reflect.Struct {
+					fields = append(fields, sub)
+					if count[f.Type] > 1 {
+						fields = append(fields, sub)
+					}
+					continue
+				}
+				nextCount[ft]++
+				if nextCount[ft] == 1 {
+					next = append(next, sub)
+				}
+			}
+		}
+	}
+	return fields
+}
+
+func parseField(sf reflect.StructField) (field, bool) {
+	f := field{Name: sf.Name, Rotor: parseRotorTag(sf.Tag.Get("rotor"))}
+	tagStr := sf.Tag.Get("dynamodbav")
+	if tagStr == "" {
+		return f, false
+	}
+	parts := strings.Split(tagStr, ",")
+	if parts[0] == "-" {
+		return f, true
+	}
+	if parts[0] != "" {
+		f.Name = parts[0]
+		f.NameFromTag = true
+	}
+	for _, opt := range parts[1:] {
+		switch opt {
+		case "omitempty":
+			f.OmitEmpty = true
+		case "omitemptyelem":
+			f.OmitEmptyElem = true
+		case "string":
+			f.AsString = true
+		case "binaryset":
+			f.AsBinSet = true
+		case "numberset":
+			f.AsNumSet = true
+		case "stringset":
+			f.AsStrSet = true
+		case "unixtime":
+			f.AsUnixTime = true
+		}
+	}
+	return f, false
+}
+
+// visibleFields 同名字段按 Go 的嵌入规则选出一个, 有 tag 的优先
+func visibleFields(fields []field) []field {
+	out := fields[:0]
+	for advance, i := 0, 0; i < len(fields); i += advance {
+		fi := fields[i]
+		for advance = 1; i+advance < len(fields); advance++ {
+			if fields[i+advance].Name != fi.Name {
+				break
+			}
+		}
+		if advance == 1 {
+			out = append(out, fi)
+			continue
+		}
+		if dominant, ok := dominantField(fields[i : i+advance]); ok {
+			out = append(out, dominant)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return indexLess(out[i].Index, out[j].Index)
+	})
+	return out
+}
+
+func dominantField(fields []field) (field, bool) {
+	length := len(fields[0].Index)
+	tagged := -1
+	for i, f := range fields {
+		if len(f.Index) > length {
+			fields = fields[:i]
+			break
+		}
+		if f.NameFromTag {
+			if tagged >= 0 {
+				return field{}, false
+			}
+			tagged = i
+		}
+	}
+	if tagged >= 0 {
+		return fields[tagged], true
+	}
+	if len(fields) > 1 {
+		return field{}, false
+	}
+	return fields[0], true
+}
+
+func indexLess(a, b []int) bool {
+	for k, ak := range a {
+		if k >= len(b) {
+			return false
+		}
+		if ak != b[k] {
+			return ak < b[k]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// fieldByIndex 按 index 取字段, 路径上的 nil 指针返回 false
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
@@ -1,7 +1,11 @@
 module github.com/lixw1994/rotor
 
-go 1.17
+go 1.22
 
-require github.com/aws/aws-sdk-go v1.43.11
+require (
+	github.com/aws/aws-sdk-go v1.43.11
+	github.com/klauspost/compress v1.18.0
+	google.golang.org/protobuf v1.28.1
+)
 
 require github.com/jmespath/go-jmespath v0.4.0 // indirect
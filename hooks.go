@@ -0,0 +1,195 @@
+package rotor
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+)
+
+// BeforePutHook Put/PutOut/PutBatch/Upsert/Transact 写入前调用, 在校验和编码之前,
+// 可以规范化字段或者计算 GSI 的键; 传入的是值时修改的是它的副本
+type BeforePutHook interface {
+	BeforePut(ctx context.Context) error
+}
+
+// AfterLoadHook Get/GetBatch/Query 等读取路径解码之后调用, 包括 QueryCollection 的每个 item
+type AfterLoadHook interface {
+	AfterLoad(ctx context.Context) error
+}
+
+// BeforeDeleteHook DeleteItem 和设置了 Item 的 TransactDeleteItem 删除前调用
+// 传入的是值时修改的是它的副本, 之后的 AfterWrite 在副本上调用
+type BeforeDeleteHook interface {
+	BeforeDelete(ctx context.Context) error
+}
+
+// AfterWriteHook 写入或删除成功后调用, 返回的错误会原样返回, 此时写入已经生效
+// PutBatch/Transact 会调用每个 item 的 AfterWrite, 多个错误合并为 *AfterWriteError
+type AfterWriteHook interface {
+	AfterWrite(ctx context.Context) error
+}
+
+// AfterWriteError 多个 item 的 AfterWrite 返回的错误, 此时写入已经生效
+// errors.Is/errors.As 对其中任意一个错误成立
+type AfterWriteError struct {
+	Errors []error
+}
+
+func (e *AfterWriteError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return "rotor: after write: " + strings.Join(msgs, "; ")
+}
+
+// Is Is
+func (e *AfterWriteError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As As
+func (e *AfterWriteError) As(target interface{}) bool {
+	for _, err := range e.Errors {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	beforePutType    = reflect.TypeOf((*BeforePutHook)(nil)).Elem()
+	afterLoadType    = reflect.TypeOf((*AfterLoadHook)(nil)).Elem()
+	beforeDeleteType = reflect.TypeOf((*BeforeDeleteHook)(nil)).Elem()
+	afterWriteType   = reflect.TypeOf((*AfterWriteHook)(nil)).Elem()
+)
+
+// hookValue 返回可以调用 iface 方法的值, 方法是指针接收者而 v 不可寻址时复制一份
+func hookValue(v reflect.Value, iface reflect.Type) (reflect.Value, bool) {
+	for v.Kind() == reflect.Interface && !v.IsNil() {
+		v = v.Elem()
+	}
+	if !v.IsValid() || ((v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil()) {
+		return reflect.Value{}, false
+	}
+	if v.Type().Implements(iface) {
+		return v, true
+	}
+	if v.Kind() == reflect.Ptr || !reflect.PtrTo(v.Type()).Implements(iface) {
+		return reflect.Value{}, false
+	}
+	if v.CanAddr() {
+		return v.Addr(), true
+	}
+	p := reflect.New(v.Type())
+	p.Elem().Set(v)
+	return p, true
+}
+
+// beforePut 返回应该写入的值, BeforePut 修改的是副本时返回副本
+func (rs *Service) beforePut(ctx context.Context, in interface{}) (interface{}, error) {
+	hv, ok := hookValue(reflect.ValueOf(in), beforePutType)
+	if !ok {
+		return in, nil
+	}
+	hook := hv.Interface()
+	return hook, hook.(BeforePutHook).BeforePut(ctx)
+}
+
+// beforeDelete 调用 BeforeDelete, 返回删除的值和它的主键, BeforeDelete 修改的是副本时返回副本
+func (rs *Service) beforeDelete(ctx context.Context, in interface{}) (interface{}, PrimaryKeyType, error) {
+	hv, ok := hookValue(reflect.ValueOf(in), beforeDeleteType)
+	if ok {
+		if err := hv.Interface().(BeforeDeleteHook).BeforeDelete(ctx); err != nil {
+			return nil, nil, err
+		}
+		in = hv.Interface()
+	}
+	item, err := rs.codec.MarshalMap(in)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := itemKey(item, []string{tablePK, tableSK})
+	if err != nil {
+		return nil, nil, err
+	}
+	return in, key, nil
+}
+
+func (rs *Service) afterWrite(ctx context.Context, in interface{}) error {
+	hv, ok := hookValue(reflect.ValueOf(in), afterWriteType)
+	if !ok {
+		return nil
+	}
+	return hv.Interface().(AfterWriteHook).AfterWrite(ctx)
+}
+
+// afterWriteAll 调用每个 in 的 AfterWrite, 一个失败不影响其他的, 多个错误时返回 *AfterWriteError
+func (rs *Service) afterWriteAll(ctx context.Context, ins []interface{}) error {
+	var errs []error
+	for _, in := range ins {
+		if err := rs.afterWrite(ctx, in); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return &AfterWriteError{Errors: errs}
+}
+
+// afterLoad 调用 v 的 AfterLoad, v 为 item 或者它的指针
+func afterLoad(ctx context.Context, v reflect.Value) error {
+	hv, ok := hookValue(v, afterLoadType)
+	if !ok {
+		return nil
+	}
+	return hv.Interface().(AfterLoadHook).AfterLoad(ctx)
+}
+
+// afterLoadAll 调用 out 中每个 item 的 AfterLoad, out 为 slice 的指针或者 QueryCollection 的结构体指针
+func afterLoadAll(ctx context.Context, out interface{}) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return nil
+	}
+	rv = rv.Elem()
+	switch rv.Kind() {
+	case reflect.Slice:
+		for i := 0; i < rv.Len(); i++ {
+			if err := afterLoad(ctx, rv.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		for _, index := range collectionFields(rv.Type()) {
+			fv := rv.FieldByIndex(index)
+			if fv.Kind() != reflect.Slice {
+				// 单值字段没有匹配的 item 时为零值
+				if fv.IsZero() {
+					continue
+				}
+				if err := afterLoad(ctx, fv); err != nil {
+					return err
+				}
+				continue
+			}
+			for i := 0; i < fv.Len(); i++ {
+				if err := afterLoad(ctx, fv.Index(i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
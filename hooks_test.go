@@ -0,0 +1,203 @@
+package rotor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+type hookTestUser struct {
+	BaseSchema
+
+	Email  string
+	GSI1PK string `dynamodbav:",omitempty"`
+	Loaded bool   `dynamodbav:"-"`
+}
+
+func (u *hookTestUser) BeforePut(ctx context.Context) error {
+	u.Email = strings.ToLower(u.Email)
+	u.GSI1PK = "Email#" + u.Email
+	return nil
+}
+
+func (u *hookTestUser) AfterLoad(ctx context.Context) error {
+	u.Loaded = true
+	return nil
+}
+
+func (u *hookTestUser) BeforeDelete(ctx context.Context) error {
+	if u.PK == "" {
+		return ErrInput
+	}
+	return nil
+}
+
+type hookTestCollection struct {
+	Users []hookTestUser
+}
+
+func TestServiceHooks(t *testing.T) {
+	rs := &Service{codec: NewCodec(), tableName: aws.String("test")}
+	ctx := context.TODO()
+
+	u := hookTestUser{Email: "A@B.com"}
+	u.PK, u.SK = "User#1", "User"
+	in, err := rs.beforePut(ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, err := rs.marshalItem(ctx, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(item["GSI1PK"].S) != "Email#a@b.com" || u.Email != "A@B.com" {
+		t.Errorf("beforePut失败: 不是预期的 item %v", item)
+	}
+
+	var out hookTestUser
+	if err := rs.decodeItem(ctx, item, &out, decodeOptions{}); err != nil || !out.Loaded {
+		t.Errorf("decodeItem失败: 没有调用 AfterLoad %v", err)
+	}
+	var empty hookTestUser
+	if err := rs.decodeItem(ctx, nil, &empty, decodeOptions{}); err != nil || empty.Loaded {
+		t.Errorf("decodeItem失败: 没有 item 时不应该调用 AfterLoad %v", err)
+	}
+
+	items := []map[string]*dynamodb.AttributeValue{item, item}
+	var users []hookTestUser
+	if err := rs.decodeItems(ctx, items, &users, decodeOptions{}); err != nil || len(users) != 2 || !users[0].Loaded || !users[1].Loaded {
+		t.Errorf("decodeItems失败: 没有调用 AfterLoad %v %+v", err, users)
+	}
+	var ptrs []*hookTestUser
+	if err := rs.decodeItems(ctx, items, &ptrs, decodeOptions{}); err != nil || len(ptrs) != 2 || !ptrs[1].Loaded {
+		t.Errorf("decodeItems失败: 没有调用 AfterLoad %v", err)
+	}
+
+	registry := NewCollectionRegistry("").RegisterPrefix("User", hookTestUser{})
+	var collection hookTestCollection
+	if err := rs.decodeItems(ctx, items, &collectionOut{registry: registry, out: &collection}, decodeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(collection.Users) != 2 || !collection.Users[0].Loaded {
+		t.Errorf("decodeItems失败: QueryCollection 没有调用 AfterLoad %+v", collection)
+	}
+	var single struct{ User *hookTestUser }
+	if err := rs.decodeItems(ctx, items, &collectionOut{registry: registry, out: &single}, decodeOptions{}); err != nil || single.User == nil || !single.User.Loaded {
+		t.Errorf("decodeItems失败: QueryCollection 没有调用 AfterLoad %v", err)
+	}
+
+	_, key, err := rs.beforeDelete(ctx, &u)
+	if err != nil || !reflect.DeepEqual(key, PrimaryKey("User#1", "User")) {
+		t.Errorf("beforeDelete失败: 不是预期的主键 %v %v", key, err)
+	}
+	if _, _, err := rs.beforeDelete(ctx, &hookTestUser{}); !errors.Is(err, ErrInput) {
+		t.Errorf("beforeDelete失败: 应该返回 BeforeDelete 的错误 %v", err)
+	}
+}
+
+type hookTestTask struct {
+	BaseSchema
+
+	deleted bool
+	err     error
+	log     *[]string
+}
+
+func (t *hookTestTask) BeforeDelete(ctx context.Context) error {
+	t.deleted = true
+	return nil
+}
+
+func (t *hookTestTask) AfterWrite(ctx context.Context) error {
+	*t.log = append(*t.log, fmt.Sprintf("%s %v", t.PK, t.deleted))
+	return t.err
+}
+
+func TestServiceAfterWrite(t *testing.T) {
+	rs, _ := newFakeService(func(op string, input interface{}) (interface{}, error) {
+		switch op {
+		case "DeleteItem":
+			return &dynamodb.DeleteItemOutput{}, nil
+		case "TransactWriteItems":
+			return &dynamodb.TransactWriteItemsOutput{}, nil
+		}
+		return nil, errors.New(op)
+	})
+	ctx := context.TODO()
+	var log []string
+	task := func(pk string, err error) hookTestTask {
+		return hookTestTask{BaseSchema: BaseSchema{PK: pk, SK: "Task"}, err: err, log: &log}
+	}
+
+	if err := rs.DeleteItem(ctx, task("Task#1", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.Transact(ctx, func(options *TransactOptions) {
+		options.DeleteItems = append(options.DeleteItems, TransactDeleteItem{Item: task("Task#2", nil)})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if expect := []string{"Task#1 true", "Task#2 true"}; !reflect.DeepEqual(log, expect) {
+		t.Errorf("AfterWrite失败: 应该使用 BeforeDelete 修改后的值 %v", log)
+	}
+
+	log = nil
+	errA, errB := errors.New("a"), errors.New("b")
+	err := rs.PutBatch(ctx, []interface{}{task("Task#3", errA), task("Task#4", nil), task("Task#5", errB)})
+	var werr *AfterWriteError
+	if !errors.As(err, &werr) || len(werr.Errors) != 2 || !errors.Is(err, errA) || !errors.Is(err, errB) {
+		t.Errorf("PutBatch失败: 不是预期的错误 %v", err)
+	}
+	if len(log) != 3 {
+		t.Errorf("PutBatch失败: 应该调用每个 item 的 AfterWrite %v", log)
+	}
+}
+
+func TestConditionalCheckErrorDecode(t *testing.T) {
+	store, err := NewFileBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var current map[string]*dynamodb.AttributeValue
+	rs, _ := newFakeService(func(op string, input interface{}) (interface{}, error) {
+		if op == "GetItem" {
+			return &dynamodb.GetItemOutput{Item: current}, nil
+		}
+		return nil, &dynamodb.TransactionCanceledException{CancellationReasons: []*dynamodb.CancellationReason{{
+			Code: aws.String(dynamodb.BatchStatementErrorCodeEnumConditionalCheckFailed),
+			Item: current,
+		}}}
+	}, ServiceBlobStore(store))
+	ctx := context.TODO()
+	key := PrimaryKey("Test#id1", "Test")
+
+	if current, err = rs.marshalItem(ctx, &hookTestUser{BaseSchema: BaseSchema{PK: "Test#id1", SK: "Test"}, Email: "a@b.c"}); err != nil {
+		t.Fatal(err)
+	}
+	var condErr *ConditionalCheckError
+	if err := rs.Delete(ctx, key, DeleteConditionReturnOld()); !errors.As(err, &condErr) {
+		t.Fatalf("Delete失败: 应该返回 ConditionalCheckError %v", err)
+	}
+	var user hookTestUser
+	if err := condErr.Decode(&user); err != nil || !user.Loaded || user.Email != "a@b.c" {
+		t.Errorf("Decode失败: 应该调用 AfterLoad %+v %v", user, err)
+	}
+
+	// 转存的对象需要加载
+	if current, err = rs.marshalItem(ctx, blobTestSchema{BaseSchema: BaseSchema{PK: "Test#id1", SK: "Test"}, Attachment: []byte("v1")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.Delete(ctx, key, DeleteConditionReturnOld()); !errors.As(err, &condErr) {
+		t.Fatalf("Delete失败: 应该返回 ConditionalCheckError %v", err)
+	}
+	var blob blobTestSchema
+	if err := condErr.DecodeContext(ctx, &blob); err != nil || string(blob.Attachment) != "v1" {
+		t.Errorf("DecodeContext失败: 应该加载转存的对象 %q %v", blob.Attachment, err)
+	}
+}
@@ -0,0 +1,215 @@
+package rotor
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const (
+	// maxItemSize dynamodb 单个 item 的大小上限
+	maxItemSize = 400 * 1024
+
+	writeUnitSize = 1024
+	readUnitSize  = 4 * 1024
+
+	itemSizeErrorAttrs = 5
+)
+
+// AttributeSize 属性名和属性的大小
+type AttributeSize struct {
+	Name string
+	Size int
+}
+
+// ItemSizeError item 超过 400KB, errors.Is(err, ErrItemSize) 成立
+type ItemSizeError struct {
+	Size int
+	// Largest 最大的几个属性, 按大小降序
+	Largest []AttributeSize
+}
+
+func (e *ItemSizeError) Error() string {
+	attrs := make([]string, len(e.Largest))
+	for i, a := range e.Largest {
+		attrs[i] = fmt.Sprintf("%s=%d", a.Name, a.Size)
+	}
+	return fmt.Sprintf("%s: item size %d exceeds %d bytes, largest attributes: %s",
+		ErrItemSize.Error(), e.Size, maxItemSize, strings.Join(attrs, ", "))
+}
+
+// Unwrap Unwrap
+func (e *ItemSizeError) Unwrap() error {
+	return ErrItemSize
+}
+
+// ItemSize 按 dynamodb 的计费规则计算 item 的大小, 属性名和属性值都计算在内
+func ItemSize(item map[string]*dynamodb.AttributeValue) int {
+	size := 0
+	for name, av := range item {
+		size += len(name) + attributeValueSize(av)
+	}
+	return size
+}
+
+// attributeValueSize 属性值的大小, L/M 每个元素额外 1 字节, 另有 3 字节开销
+func attributeValueSize(av *dynamodb.AttributeValue) int {
+	if av == nil {
+		return 0
+	}
+	switch {
+	case av.S != nil:
+		return len(*av.S)
+	case av.N != nil:
+		return numberSize(*av.N)
+	case av.B != nil:
+		return len(av.B)
+	case av.BOOL != nil, av.NULL != nil:
+		return 1
+	case av.SS != nil:
+		size := 0
+		for _, s := range av.SS {
+			size += len(*s)
+		}
+		return size
+	case av.NS != nil:
+		size := 0
+		for _, n := range av.NS {
+			size += numberSize(*n)
+		}
+		return size
+	case av.BS != nil:
+		size := 0
+		for _, b := range av.BS {
+			size += len(b)
+		}
+		return size
+	case av.L != nil:
+		size := 3
+		for _, elem := range av.L {
+			size += 1 + attributeValueSize(elem)
+		}
+		return size
+	case av.M != nil:
+		size := 3
+		for name, elem := range av.M {
+			size += 1 + len(name) + attributeValueSize(elem)
+		}
+		return size
+	}
+	return 0
+}
+
+// numberSize 每两位有效数字 1 字节, 另加 1 字节, 首尾的 0 不计算
+func numberSize(n string) int {
+	if i := strings.IndexAny(n, "eE"); i >= 0 {
+		n = n[:i]
+	}
+	digits := strings.Replace(strings.TrimLeft(n, "+-"), ".", "", 1)
+	digits = strings.Trim(digits, "0")
+	return (len(digits)+1)/2 + 1
+}
+
+// largestAttributes 最大的 n 个属性
+func largestAttributes(item map[string]*dynamodb.AttributeValue, n int) []AttributeSize {
+	sizes := make([]AttributeSize, 0, len(item))
+	for name, av := range item {
+		sizes = append(sizes, AttributeSize{Name: name, Size: len(name) + attributeValueSize(av)})
+	}
+	sort.Slice(sizes, func(i, j int) bool {
+		if sizes[i].Size != sizes[j].Size {
+			return sizes[i].Size > sizes[j].Size
+		}
+		return sizes[i].Name < sizes[j].Name
+	})
+	if len(sizes) > n {
+		sizes = sizes[:n]
+	}
+	return sizes
+}
+
+// checkItemSize 超过 400KB 时返回 *ItemSizeError
+func checkItemSize(item map[string]*dynamodb.AttributeValue) error {
+	size := ItemSize(item)
+	if size <= maxItemSize {
+		return nil
+	}
+	return &ItemSizeError{Size: size, Largest: largestAttributes(item, itemSizeErrorAttrs)}
+}
+
+// ItemCapacity item 的大小和单次操作消耗的容量单位
+type ItemCapacity struct {
+	Size int
+
+	WriteUnits         int
+	TransactWriteUnits int
+
+	ReadUnits         float64 // 强一致读
+	EventualReadUnits float64 // 最终一致读
+	TransactReadUnits float64
+}
+
+// Capacity 计算 item 写入和读取消耗的 WCU/RCU
+func Capacity(item map[string]*dynamodb.AttributeValue) ItemCapacity {
+	size := ItemSize(item)
+	write := (size + writeUnitSize - 1) / writeUnitSize
+	read := (size + readUnitSize - 1) / readUnitSize
+	if write == 0 {
+		write = 1
+	}
+	if read == 0 {
+		read = 1
+	}
+	return ItemCapacity{
+		Size:               size,
+		WriteUnits:         write,
+		TransactWriteUnits: 2 * write,
+		ReadUnits:          float64(read),
+		EventualReadUnits:  float64(read) / 2,
+		TransactReadUnits:  float64(2 * read),
+	}
+}
+
+// ItemSize 编码 in 并计算 item 的大小
+func (codec Codec) ItemSize(in interface{}) (int, error) {
+	item, err := codec.MarshalMap(in)
+	if err != nil {
+		return 0, err
+	}
+	return ItemSize(item), nil
+}
+
+// Capacity 编码 in 并计算写入和读取消耗的容量单位
+func (codec Codec) Capacity(in interface{}) (ItemCapacity, error) {
+	item, err := codec.MarshalMap(in)
+	if err != nil {
+		return ItemCapacity{}, err
+	}
+	return Capacity(item), nil
+}
+
+// marshalItem 校验并编码要写入的 item, 转存大对象, 记录 schema 版本并检查大小
+// 写入完整 item 的路径都经过这里, 不需要再单独调用 Validate
+func (rs *Service) marshalItem(ctx context.Context, in interface{}) (map[string]*dynamodb.AttributeValue, error) {
+	if err := Validate(in); err != nil {
+		return nil, err
+	}
+	item, err := rs.codec.MarshalMap(in)
+	if err != nil {
+		return nil, err
+	}
+	if err := rs.offloadBlobs(ctx, reflect.TypeOf(in), item); err != nil {
+		rs.discardBlobs(ctx, item)
+		return nil, err
+	}
+	rs.schemas.stamp(reflect.TypeOf(in), item)
+	if err := checkItemSize(item); err != nil {
+		rs.discardBlobs(ctx, item)
+		return nil, err
+	}
+	return item, nil
+}
@@ -0,0 +1,48 @@
+package rotor_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/lixw1994/rotor"
+)
+
+func TestItemSize(t *testing.T) {
+	item := map[string]*dynamodb.AttributeValue{
+		"PK":   {S: aws.String("abc")},
+		"N":    {N: aws.String("-12345.6700")},
+		"Flag": {BOOL: aws.Bool(true)},
+		"L":    {L: []*dynamodb.AttributeValue{{S: aws.String("x")}, {NULL: aws.Bool(true)}}},
+		"M":    {M: map[string]*dynamodb.AttributeValue{"k": {S: aws.String("vv")}}},
+	}
+	// PK 2+3, N 1+5, Flag 4+1, L 1+3+2+2, M 1+3+1+1+2
+	if size := rotor.ItemSize(item); size != 5+6+5+8+8 {
+		t.Errorf("ItemSize失败: 不是预期的大小 %d", size)
+	}
+	c := rotor.Capacity(map[string]*dynamodb.AttributeValue{"Doc": {S: aws.String(strings.Repeat("x", 5000))}})
+	if c.WriteUnits != 5 || c.ReadUnits != 2 || c.EventualReadUnits != 1 || c.TransactWriteUnits != 10 {
+		t.Errorf("Capacity失败: 不是预期的容量 %+v", c)
+	}
+}
+
+func TestPutItemSize(t *testing.T) {
+	sess, err := session.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := rotor.New(sess, "")
+	in := newTestSchema("id1", strings.Repeat("x", 400*1024))
+	err = rs.Put(context.TODO(), in)
+	var sizeErr *rotor.ItemSizeError
+	if !errors.As(err, &sizeErr) || !errors.Is(err, rotor.ErrItemSize) {
+		t.Fatalf("Put应该在请求前失败: %v", err)
+	}
+	if len(sizeErr.Largest) == 0 || sizeErr.Largest[0].Name != "TestV" {
+		t.Errorf("ItemSizeError失败: 不是预期的属性 %v", sizeErr)
+	}
+}
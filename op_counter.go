@@ -0,0 +1,152 @@
+package rotor
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// IncrementOptions IncrementOptions
+type IncrementOptions struct {
+	condition *expression.ConditionBuilder
+	floor     *int64
+	ceiling   *int64
+}
+
+func defaultIncrementOptions() *IncrementOptions {
+	return &IncrementOptions{}
+}
+
+// IncrementOption IncrementOption
+type IncrementOption func(options *IncrementOptions)
+
+// IncrementCondition IncrementCondition
+// 条件不满足时返回 ErrConditionalCheck; 与上下限同时使用时, 失败后会重新读取 attr 判断是否超出上下限
+func IncrementCondition(condition expression.ConditionBuilder) IncrementOption {
+	return func(options *IncrementOptions) {
+		if options.condition != nil {
+			condition = options.condition.And(condition)
+		}
+		options.condition = &condition
+	}
+}
+
+// IncrementFloor 结果不能小于 floor, 例如库存不能小于 0
+func IncrementFloor(floor int64) IncrementOption {
+	return func(options *IncrementOptions) {
+		options.floor = aws.Int64(floor)
+	}
+}
+
+// IncrementCeiling 结果不能大于 ceiling
+func IncrementCeiling(ceiling int64) IncrementOption {
+	return func(options *IncrementOptions) {
+		options.ceiling = aws.Int64(ceiling)
+	}
+}
+
+// boundCondition 上下限对应的条件, 属性不存在时按 0 计算
+func (options *IncrementOptions) boundCondition(attr string, delta int64) (expression.ConditionBuilder, bool) {
+	if options.floor == nil && options.ceiling == nil {
+		return expression.ConditionBuilder{}, false
+	}
+	name := expression.Name(attr)
+	var bounds []expression.ConditionBuilder
+	createOK := true
+	if options.floor != nil {
+		bounds = append(bounds, name.GreaterThanEqual(expression.Value(*options.floor-delta)))
+		createOK = createOK && delta >= *options.floor
+	}
+	if options.ceiling != nil {
+		bounds = append(bounds, name.LessThanEqual(expression.Value(*options.ceiling-delta)))
+		createOK = createOK && delta <= *options.ceiling
+	}
+	cond := bounds[0]
+	if len(bounds) > 1 {
+		cond = expression.And(bounds[0], bounds[1])
+	}
+	if createOK {
+		cond = expression.Or(expression.AttributeNotExists(name), cond)
+	}
+	return cond, true
+}
+
+// Increment 原子地给数值属性 attr 加上 delta 并返回新值
+// 属性或 item 不存在时按 0 计算, 超出上下限时返回 ErrCounterBound
+func (rs *Service) Increment(ctx context.Context, key PrimaryKeyType, attr string, delta int64, opts ...IncrementOption) (int64, error) {
+	options := defaultIncrementOptions()
+	for _, opt := range opts {
+		opt(options)
+	}
+	builder := expression.NewBuilder().WithUpdate(expression.Add(expression.Name(attr), expression.Value(delta)))
+	bound, hasBound := options.boundCondition(attr, delta)
+	switch {
+	case hasBound && options.condition != nil:
+		builder = builder.WithCondition(expression.And(bound, *options.condition))
+	case hasBound:
+		builder = builder.WithCondition(bound)
+	case options.condition != nil:
+		builder = builder.WithCondition(*options.condition)
+	}
+	expr, err := builder.Build()
+	if err != nil {
+		return 0, err
+	}
+	ret, err := rs.dynamo.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 rs.tableName,
+		Key:                       key,
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ReturnValues:              aws.String(dynamodb.ReturnValueUpdatedNew),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case dynamodb.ErrCodeConditionalCheckFailedException:
+				if !hasBound {
+					return 0, ErrConditionalCheck
+				}
+				if options.condition == nil {
+					return 0, ErrCounterBound
+				}
+				return 0, rs.incrementFailure(ctx, key, attr, delta, options)
+			default:
+				return 0, err
+			}
+		}
+		return 0, err
+	}
+	av, ok := ret.Attributes[attr]
+	if !ok || av.N == nil {
+		return 0, ErrReturnValue
+	}
+	return strconv.ParseInt(*av.N, 10, 64)
+}
+
+// incrementFailure 同时有条件和上下限时, 按当前值判断失败的原因
+// 读取和失败的写入不是原子的, 期间有其他写入时结果只是近似的
+func (rs *Service) incrementFailure(ctx context.Context, key PrimaryKeyType, attr string, delta int64, options *IncrementOptions) error {
+	item, err := rs.getRaw(ctx, key, GetConsistent(true),
+		GetProjection(expression.NamesList(expression.Name(attr))))
+	if err != nil && !errors.Is(err, ErrItemNotFound) {
+		return err
+	}
+	var current int64
+	if av, ok := item[attr]; ok && av.N != nil {
+		if current, err = strconv.ParseInt(*av.N, 10, 64); err != nil {
+			return err
+		}
+	}
+	next := current + delta
+	if (options.floor != nil && next < *options.floor) || (options.ceiling != nil && next > *options.ceiling) {
+		return ErrCounterBound
+	}
+	return ErrConditionalCheck
+}
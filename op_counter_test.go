@@ -0,0 +1,46 @@
+package rotor
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+func TestIncrementBound(t *testing.T) {
+	stock := "0"
+	rs, fake := newFakeService(func(op string, input interface{}) (interface{}, error) {
+		switch op {
+		case "UpdateItem":
+			return nil, fakeError(dynamodb.ErrCodeConditionalCheckFailedException)
+		case "GetItem":
+			return &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{"Stock": {N: aws.String(stock)}}}, nil
+		}
+		return nil, errors.New(op)
+	})
+	ctx := context.TODO()
+	key := PrimaryKey("Item#1", "Item")
+	cond := IncrementCondition(expression.Name("Status").Equal(expression.Value("on")))
+
+	if _, err := rs.Increment(ctx, key, "Stock", -1, IncrementFloor(0)); !errors.Is(err, ErrCounterBound) {
+		t.Errorf("Increment失败: 应该返回 ErrCounterBound %v", err)
+	}
+	if _, err := rs.Increment(ctx, key, "Stock", -1, IncrementFloor(0), cond); !errors.Is(err, ErrCounterBound) {
+		t.Errorf("Increment失败: 超出下限应该返回 ErrCounterBound %v", err)
+	}
+	stock = "5"
+	if _, err := rs.Increment(ctx, key, "Stock", -1, IncrementFloor(0), cond); !errors.Is(err, ErrConditionalCheck) {
+		t.Errorf("Increment失败: 条件不满足应该返回 ErrConditionalCheck %v", err)
+	}
+	if _, err := rs.Increment(ctx, key, "Stock", -1, cond); !errors.Is(err, ErrConditionalCheck) {
+		t.Errorf("Increment失败: 条件不满足应该返回 ErrConditionalCheck %v", err)
+	}
+	expect := []string{"UpdateItem", "UpdateItem", "GetItem", "UpdateItem", "GetItem", "UpdateItem"}
+	if ops := fake.ops(); !reflect.DeepEqual(ops, expect) {
+		t.Errorf("Increment失败: 不是预期的请求 %v", ops)
+	}
+}
@@ -11,7 +11,8 @@ import (
 
 // DeleteOptions DeleteOptions
 type DeleteOptions struct {
-	builder *expression.Builder
+	builder   *expression.Builder
+	returnOld bool
 }
 
 func defaultDeleteOptions() *DeleteOptions {
@@ -32,12 +33,23 @@ func DeleteCondition(condition expression.ConditionBuilder) DeleteOption {
 	}
 }
 
+// DeleteConditionReturnOld 条件检查失败时返回带当前 item 的 ConditionalCheckError
+// 请求会以单条事务发出, 消耗双倍的写容量, 不能用于 DeleteOut
+func DeleteConditionReturnOld() DeleteOption {
+	return func(options *DeleteOptions) {
+		options.returnOld = true
+	}
+}
+
 // DeleteOut delete item
 func (rs *Service) DeleteOut(ctx context.Context, key PrimaryKeyType, out interface{}, opts ...DeleteOption) error {
 	options := defaultDeleteOptions()
 	for _, opt := range opts {
 		opt(options)
 	}
+	if options.returnOld {
+		return ErrReturnValue
+	}
 	var expr expression.Expression
 	var err error
 	if options.builder != nil {
@@ -65,7 +77,11 @@ func (rs *Service) DeleteOut(ctx context.Context, key PrimaryKeyType, out interf
 		}
 		return err
 	}
-	return rs.codec.UnmarshalMap(ret.Attributes, out)
+	if err := rs.decodeItem(ctx, ret.Attributes, out, decodeOptions{}); err != nil {
+		return err
+	}
+	rs.cleanupBlobs(ctx, ret.Attributes, nil)
+	return nil
 }
 
 // Delete delete item
@@ -82,13 +98,43 @@ func (rs *Service) Delete(ctx context.Context, key PrimaryKeyType, opts ...Delet
 			return err
 		}
 	}
-	_, err = rs.dynamo.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+	if options.returnOld {
+		// 事务拿不到旧 item, 写入前读取用来清理转存的对象
+		var old []map[string]*dynamodb.AttributeValue
+		if rs.blobStore != nil {
+			if old, err = rs.currentItems(ctx, key); err != nil {
+				return err
+			}
+		}
+		err := rs.transactOne(ctx, &dynamodb.TransactWriteItem{
+			Delete: &dynamodb.Delete{
+				TableName:                 rs.tableName,
+				Key:                       key,
+				ConditionExpression:       expr.Condition(),
+				ExpressionAttributeNames:  expr.Names(),
+				ExpressionAttributeValues: expr.Values(),
+			},
+		})
+		if err != nil {
+			return err
+		}
+		for _, item := range old {
+			rs.cleanupBlobs(ctx, item, nil)
+		}
+		return nil
+	}
+	// 需要旧 item 来清理转存的对象
+	returnValues := dynamodb.ReturnValueNone
+	if rs.blobStore != nil {
+		returnValues = dynamodb.ReturnValueAllOld
+	}
+	ret, err := rs.dynamo.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
 		TableName:                 rs.tableName,
 		Key:                       key,
 		ConditionExpression:       expr.Condition(),
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
-		ReturnValues:              aws.String(dynamodb.ReturnValueNone),
+		ReturnValues:              aws.String(returnValues),
 	})
 	if err != nil {
 		if aerr, ok := err.(awserr.Error); ok {
@@ -101,9 +147,23 @@ func (rs *Service) Delete(ctx context.Context, key PrimaryKeyType, opts ...Delet
 		}
 		return err
 	}
+	rs.cleanupBlobs(ctx, ret.Attributes, nil)
 	return nil
 }
 
+// DeleteItem 删除 in 对应的 item, 主键取自 in 的 PK/SK
+// 删除前调用 in 的 BeforeDelete, 成功后调用 AfterWrite
+func (rs *Service) DeleteItem(ctx context.Context, in interface{}, opts ...DeleteOption) error {
+	in, key, err := rs.beforeDelete(ctx, in)
+	if err != nil {
+		return err
+	}
+	if err := rs.Delete(ctx, key, opts...); err != nil {
+		return err
+	}
+	return rs.afterWrite(ctx, in)
+}
+
 // DeleteBatch Delete items
 func (rs *Service) DeleteBatch(ctx context.Context, keys []PrimaryKeyType, opts ...DeleteOption) error {
 	if len(keys) == 0 || len(keys) > maxWriteNum {
@@ -122,6 +182,13 @@ func (rs *Service) DeleteBatch(ctx context.Context, keys []PrimaryKeyType, opts
 		}
 	}
 
+	// 事务拿不到旧 item, 写入前读取用来清理转存的对象
+	var old []map[string]*dynamodb.AttributeValue
+	if rs.blobStore != nil {
+		if old, err = rs.currentItems(ctx, keys...); err != nil {
+			return err
+		}
+	}
 	inItems := make([]*dynamodb.TransactWriteItem, len(keys))
 	for i, key := range keys {
 		inItems[i] = &dynamodb.TransactWriteItem{
@@ -148,5 +215,8 @@ func (rs *Service) DeleteBatch(ctx context.Context, keys []PrimaryKeyType, opts
 		}
 		return err
 	}
+	for _, item := range old {
+		rs.cleanupBlobs(ctx, item, nil)
+	}
 	return nil
 }
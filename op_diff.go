@@ -0,0 +1,282 @@
+package rotor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// Diff 比较同一类型的 old 和 new, 生成把 old 更新为 new 的最小 update expression
+// map 逐层比较生成嵌套路径, list 和 set 整体替换, 主键 PK/SK 不参与比较
+// map 的 key 包含 '.' 或 '[' 等不能出现在路径中的字符时整体替换这个 map;
+// 有变化的顶层属性名包含这些字符时返回 ErrInput, expression.Name 会把它当成路径解析
+// 加密字段绑定 new 自己的主键, 更新其他主键的 item 时使用 Service.UpdateDiff
+func (codec Codec) Diff(old, new interface{}) (update expression.UpdateBuilder, changed bool, err error) {
+	return codec.diff(old, new, nil, nil)
+}
+
+// diff key 不为 nil 时加密字段绑定 key, offload 不为 nil 时转存字段整体比较, 有变化时转存后整体替换
+func (codec Codec) diff(old, new interface{}, key PrimaryKeyType, offload offloadHook) (update expression.UpdateBuilder, changed bool, err error) {
+	if indirectType(reflect.TypeOf(old)) != indirectType(reflect.TypeOf(new)) {
+		return update, false, ErrInput
+	}
+	if key != nil {
+		codec = codec.withItemKey(key)
+	}
+	oldItem, err := codec.MarshalMap(old)
+	if err != nil {
+		return update, false, err
+	}
+	newItem, err := codec.MarshalMap(new)
+	if err != nil {
+		return update, false, err
+	}
+	// 整体比较的属性, 有变化时整体替换: 加密字段每次的密文都不同, 比较明文;
+	// 包含嵌套加密字段的属性比较 Go 的值; 需要转存时转存字段比较转存前的值
+	t := indirectType(reflect.TypeOf(new))
+	var names []string
+	whole := map[string]*dynamodb.AttributeValue{}
+	record := func(name string, equal bool) error {
+		av := newItem[name]
+		delete(oldItem, name)
+		delete(newItem, name)
+		if equal {
+			return nil
+		}
+		if !plainName(name) {
+			return topNameError(name)
+		}
+		whole[name] = av
+		names = append(names, name)
+		return nil
+	}
+	for _, f := range structFields(t) {
+		var equal bool
+		switch {
+		case codec.config != nil && f.Rotor.Has("encrypt"):
+			oldPlain, err := codec.config.decryptAttribute(oldItem, f.Name, codec.itemKey)
+			if err != nil {
+				return update, false, err
+			}
+			newPlain, err := codec.config.decryptAttribute(newItem, f.Name, codec.itemKey)
+			if err != nil {
+				return update, false, err
+			}
+			equal = attributeValueEqual(oldPlain, newPlain)
+		case codec.config != nil && hasEncrypted(f.Type, map[reflect.Type]bool{}):
+			o, _ := fieldByIndex(reflect.Indirect(reflect.ValueOf(old)), f.Index)
+			n, _ := fieldByIndex(reflect.Indirect(reflect.ValueOf(new)), f.Index)
+			equal = o.IsValid() == n.IsValid() && (!o.IsValid() || reflect.DeepEqual(o.Interface(), n.Interface()))
+		case offload != nil && f.Rotor.Has("offload"):
+			equal = attributeValueEqual(oldItem[f.Name], newItem[f.Name])
+		default:
+			continue
+		}
+		if err := record(f.Name, equal); err != nil {
+			return update, false, err
+		}
+	}
+	if offload != nil {
+		set := map[string]*dynamodb.AttributeValue{}
+		var remove []string
+		for _, f := range offloadFields(t) {
+			av, ok := whole[f.Name]
+			switch {
+			case !ok:
+			case av == nil:
+				remove = append(remove, f.Name)
+			default:
+				set[f.Name] = av
+			}
+		}
+		if err := offload(t, set, remove); err != nil {
+			return update, false, err
+		}
+		for name, av := range set {
+			whole[name] = av
+		}
+	}
+	for _, name := range names {
+		if av := whole[name]; av != nil {
+			update = update.Set(expression.Name(name), expression.Value(av))
+		} else {
+			update = update.Remove(expression.Name(name))
+		}
+		changed = true
+	}
+	delete(oldItem, tablePK)
+	delete(oldItem, tableSK)
+	delete(newItem, tablePK)
+	delete(newItem, tableSK)
+	for _, items := range [][2]map[string]*dynamodb.AttributeValue{{oldItem, newItem}, {newItem, oldItem}} {
+		for name, av := range items[0] {
+			if !plainName(name) && !attributeValueEqual(av, items[1][name]) {
+				return update, false, topNameError(name)
+			}
+		}
+	}
+	if diffAttributes(&update, "", oldItem, newItem) {
+		changed = true
+	}
+	return update, changed, nil
+}
+
+func diffAttributes(update *expression.UpdateBuilder, path string, old, new map[string]*dynamodb.AttributeValue) bool {
+	changed := false
+	for _, name := range attributeNames(new) {
+		p := joinPath(path, name)
+		o, ok := old[name]
+		n := new[name]
+		switch {
+		case !ok:
+			*update = update.Set(expression.Name(p), expression.Value(n))
+			changed = true
+		case o.M != nil && n.M != nil && plainKeys(o.M) && plainKeys(n.M):
+			if diffAttributes(update, p, o.M, n.M) {
+				changed = true
+			}
+		case !attributeValueEqual(o, n):
+			*update = update.Set(expression.Name(p), expression.Value(n))
+			changed = true
+		}
+	}
+	for _, name := range attributeNames(old) {
+		if _, ok := new[name]; !ok {
+			*update = update.Remove(expression.Name(joinPath(path, name)))
+			changed = true
+		}
+	}
+	return changed
+}
+
+func topNameError(name string) error {
+	return fmt.Errorf("%w: attribute name %q cannot be used in an update expression", ErrInput, name)
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// plainName name 可以直接作为 document path 的一段
+func plainName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, ".[]")
+}
+
+func plainKeys(m map[string]*dynamodb.AttributeValue) bool {
+	for name := range m {
+		if !plainName(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// keyPath 用于错误信息的 map key 路径, 不能直接拼接的 key 写成 path["a.b"]
+func keyPath(path, key string) string {
+	if plainName(key) {
+		return joinPath(path, key)
+	}
+	return path + "[" + strconv.Quote(key) + "]"
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// attributeValueEqual 比较两个 AttributeValue, set 不区分顺序
+func attributeValueEqual(a, b *dynamodb.AttributeValue) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	switch {
+	case a.S != nil || b.S != nil:
+		return a.S != nil && b.S != nil && *a.S == *b.S
+	case a.N != nil || b.N != nil:
+		return a.N != nil && b.N != nil && *a.N == *b.N
+	case a.B != nil || b.B != nil:
+		return a.B != nil && b.B != nil && bytes.Equal(a.B, b.B)
+	case a.BOOL != nil || b.BOOL != nil:
+		return a.BOOL != nil && b.BOOL != nil && *a.BOOL == *b.BOOL
+	case a.NULL != nil || b.NULL != nil:
+		return aws.BoolValue(a.NULL) == aws.BoolValue(b.NULL)
+	case a.SS != nil || b.SS != nil:
+		return a.SS != nil && b.SS != nil && stringSetEqual(aws.StringValueSlice(a.SS), aws.StringValueSlice(b.SS))
+	case a.NS != nil || b.NS != nil:
+		return a.NS != nil && b.NS != nil && stringSetEqual(aws.StringValueSlice(a.NS), aws.StringValueSlice(b.NS))
+	case a.BS != nil || b.BS != nil:
+		if a.BS == nil || b.BS == nil {
+			return false
+		}
+		as := make([]string, len(a.BS))
+		for i, v := range a.BS {
+			as[i] = string(v)
+		}
+		bs := make([]string, len(b.BS))
+		for i, v := range b.BS {
+			bs[i] = string(v)
+		}
+		return stringSetEqual(as, bs)
+	case a.L != nil || b.L != nil:
+		if a.L == nil || b.L == nil || len(a.L) != len(b.L) {
+			return false
+		}
+		for i := range a.L {
+			if !attributeValueEqual(a.L[i], b.L[i]) {
+				return false
+			}
+		}
+		return true
+	case a.M != nil || b.M != nil:
+		if a.M == nil || b.M == nil || len(a.M) != len(b.M) {
+			return false
+		}
+		for k, v := range a.M {
+			if !attributeValueEqual(v, b.M[k]) {
+				return false
+			}
+		}
+		return true
+	}
+	return true
+}
+
+func stringSetEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// UpdateDiff update item with the difference between old and new
+// 没有变化时不会发起请求, 可以配合 UpdateCondition 使用; 配置了 ServiceBlobStore 时转存字段有变化会整体转存替换
+func (rs *Service) UpdateDiff(ctx context.Context, key PrimaryKeyType, old, new interface{}, opts ...UpdateOption) error {
+	if err := Validate(new); err != nil {
+		return err
+	}
+	return rs.updateOffloaded(ctx, key, reflect.TypeOf(new), func(hook offloadHook) (expression.UpdateBuilder, bool, error) {
+		return rs.codec.diff(old, new, key, hook)
+	}, opts...)
+}
@@ -0,0 +1,111 @@
+package rotor_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/lixw1994/rotor"
+)
+
+type diffSchema struct {
+	rotor.BaseSchema
+
+	Name    string
+	Tags    []string `dynamodbav:",stringset"`
+	Profile map[string]string
+	Note    *string `dynamodbav:",omitempty"`
+}
+
+func TestDiff(t *testing.T) {
+	codec := rotor.NewCodec()
+	old := diffSchema{
+		BaseSchema: rotor.BaseSchema{PK: "P#1", SK: "S"},
+		Name:       "n1",
+		Tags:       []string{"a", "b"},
+		Profile:    map[string]string{"city": "c1", "zip": "z1"},
+		Note:       aws.String("note"),
+	}
+	t.Run("Diff-Unchanged", func(t *testing.T) {
+		same := old
+		same.PK = "P#2"
+		same.Tags = []string{"b", "a"}
+		_, changed, err := codec.Diff(&old, &same)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changed {
+			t.Error("Diff失败: 不应该有变化")
+		}
+	})
+	t.Run("Diff-Changed", func(t *testing.T) {
+		new := old
+		new.Name = "n2"
+		new.Profile = map[string]string{"city": "c2", "zip": "z1"}
+		new.Note = nil
+		update, changed, err := codec.Diff(&old, &new)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !changed {
+			t.Fatal("Diff失败: 应该有变化")
+		}
+		expr, err := expression.NewBuilder().WithUpdate(update).Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := map[string]bool{}
+		for _, name := range expr.Names() {
+			names[aws.StringValue(name)] = true
+		}
+		for _, name := range []string{"Name", "Profile", "city", "Note"} {
+			if !names[name] {
+				t.Errorf("Diff失败: 缺少 %s, %s", name, aws.StringValue(expr.Update()))
+			}
+		}
+		for _, name := range []string{"PK", "SK", "zip", "Tags"} {
+			if names[name] {
+				t.Errorf("Diff失败: 不应该包含 %s, %s", name, aws.StringValue(expr.Update()))
+			}
+		}
+	})
+	t.Run("Diff-DottedKey", func(t *testing.T) {
+		old := old
+		old.Profile = map[string]string{"a.b": "1", "zip": "z1"}
+		new := old
+		new.Profile = map[string]string{"a.b": "2", "zip": "z1"}
+		update, changed, err := codec.Diff(&old, &new)
+		if err != nil || !changed {
+			t.Fatalf("Diff失败: 应该有变化 %v", err)
+		}
+		expr, err := expression.NewBuilder().WithUpdate(update).Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := map[string]bool{}
+		for _, name := range expr.Names() {
+			names[aws.StringValue(name)] = true
+		}
+		if !names["Profile"] || names["a"] || names["b"] {
+			t.Errorf("Diff失败: 应该整体替换 Profile, %s %v", aws.StringValue(expr.Update()), names)
+		}
+	})
+	t.Run("Diff-DottedName", func(t *testing.T) {
+		type dotted struct {
+			rotor.BaseSchema
+			Value string `dynamodbav:"a.b"`
+		}
+		if _, _, err := codec.Diff(&dotted{Value: "1"}, &dotted{Value: "2"}); !errors.Is(err, rotor.ErrInput) {
+			t.Errorf("Diff失败: 顶层属性名包含 '.' 应该返回 ErrInput %v", err)
+		}
+		if _, changed, err := codec.Diff(&dotted{Value: "1"}, &dotted{Value: "1"}); err != nil || changed {
+			t.Errorf("Diff失败: 没有变化时不应该报错 %v", err)
+		}
+	})
+	t.Run("Diff-Type", func(t *testing.T) {
+		if _, _, err := codec.Diff(&old, &rotor.BaseSchema{}); err == nil {
+			t.Error("Diff失败: 类型不同应该报错")
+		}
+	})
+}
@@ -12,6 +12,7 @@ import (
 type GetOptions struct {
 	builder        *expression.Builder
 	consistentRead *bool
+	decode         decodeOptions
 }
 
 func defaultGetOptions() *GetOptions {
@@ -29,6 +30,7 @@ func GetProjection(projection expression.ProjectionBuilder) GetOption {
 			options.builder = &builder
 		}
 		*options.builder = options.builder.WithProjection(projection)
+		options.decode.partial = true
 	}
 }
 
@@ -39,6 +41,20 @@ func GetConsistent(strong bool) GetOption {
 	}
 }
 
+// GetSkipBlobs 不加载转存到 BlobStore 的属性, 需要时用 LoadBlobs 加载
+func GetSkipBlobs() GetOption {
+	return func(options *GetOptions) {
+		options.decode.skipBlobs = true
+	}
+}
+
+// GetStrict 本次读取是否使用严格模式, 覆盖 Service 和 Codec 的设置
+func GetStrict(strict bool) GetOption {
+	return func(options *GetOptions) {
+		options.decode.strict = aws.Bool(strict)
+	}
+}
+
 // Get get item
 // Item not found will return error
 func (rs *Service) Get(ctx context.Context, key PrimaryKeyType, out interface{}, opts ...GetOption) error {
@@ -68,7 +84,9 @@ func (rs *Service) Get(ctx context.Context, key PrimaryKeyType, out interface{},
 	if ret.Item == nil {
 		return ErrItemNotFound
 	}
-	return rs.codec.UnmarshalMap(ret.Item, out)
+	dopts := options.decode
+	dopts.fullItem = !dopts.partial
+	return rs.decodeItem(ctx, ret.Item, out, dopts)
 }
 
 // GetBatch get items
@@ -116,5 +134,37 @@ func (rs *Service) GetBatch(ctx context.Context, keys []PrimaryKeyType, out inte
 	if pageErr != nil {
 		return pageErr
 	}
-	return rs.codec.UnmarshalListOfMaps(allItems, out)
+	dopts := options.decode
+	dopts.fullItem = !dopts.partial
+	return rs.decodeItems(ctx, allItems, out, dopts)
+}
+
+// getRaw get the raw item
+func (rs *Service) getRaw(ctx context.Context, key PrimaryKeyType, opts ...GetOption) (map[string]*dynamodb.AttributeValue, error) {
+	options := defaultGetOptions()
+	for _, opt := range opts {
+		opt(options)
+	}
+	var expr expression.Expression
+	var err error
+	if options.builder != nil {
+		expr, err = options.builder.Build()
+		if err != nil {
+			return nil, err
+		}
+	}
+	ret, err := rs.dynamo.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:                rs.tableName,
+		Key:                      key,
+		ConsistentRead:           options.consistentRead,
+		ProjectionExpression:     expr.Projection(),
+		ExpressionAttributeNames: expr.Names(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if ret.Item == nil {
+		return nil, ErrItemNotFound
+	}
+	return ret.Item, nil
 }
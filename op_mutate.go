@@ -0,0 +1,207 @@
+package rotor
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	mrand "math/rand"
+	"reflect"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+const (
+	versionAttr = "Version"
+
+	// 完整状态条件的长度上限, DynamoDB 的表达式不能超过 4KB, 留出调用方条件的空间
+	maxStateCondition = 3 * 1024
+
+	defaultMutateRetries = 5
+	defaultMutateBackoff = 20 * time.Millisecond
+	maxMutateBackoff     = time.Second
+)
+
+// MutateOptions MutateOptions
+type MutateOptions struct {
+	maxRetries int
+	backoff    time.Duration
+	create     bool
+	fullState  bool
+}
+
+func defaultMutateOptions() *MutateOptions {
+	return &MutateOptions{
+		maxRetries: defaultMutateRetries,
+		backoff:    defaultMutateBackoff,
+	}
+}
+
+// MutateOption MutateOption
+type MutateOption func(options *MutateOptions)
+
+// MutateMaxRetries 冲突后最多重试 n 次, 默认 5 次
+func MutateMaxRetries(n int) MutateOption {
+	return func(options *MutateOptions) {
+		options.maxRetries = n
+	}
+}
+
+// MutateBackoff 第一次重试前等待的基准时间, 之后指数增长并加随机抖动, 默认 20ms
+func MutateBackoff(base time.Duration) MutateOption {
+	return func(options *MutateOptions) {
+		options.backoff = base
+	}
+}
+
+// MutateAllowCreate item 不存在时以零值调用 fn 并创建 item, 默认返回 ErrItemNotFound
+func MutateAllowCreate() MutateOption {
+	return func(options *MutateOptions) {
+		options.create = true
+	}
+}
+
+// MutateFullState 以读到的完整 item 作为写入条件, 默认有 Version 属性时只比较 Version
+// item 太大时仍然只比较 Version, 没有 Version 属性时返回 ErrInput
+func MutateFullState() MutateOption {
+	return func(options *MutateOptions) {
+		options.fullState = true
+	}
+}
+
+// Mutate 读取 item 到 out, 调用 fn 修改 out, 再以读到的状态为条件写回
+// 有 Version 属性时以 Version 为条件并写入新的 Version, 否则以完整的 item 为条件;
+// 条件检查失败说明期间有其他写入, 会重新读取并再次调用 fn, 因此 fn 需要可以重复执行.
+// fn 不能修改主键 (item 不存在时需要设置为 key), 否则返回 ErrInput;
+// fn 返回错误时放弃写入并原样返回, 即使是 ErrConditionalCheck 也不重试; 重试耗尽时返回 ErrConditionalCheck
+func (rs *Service) Mutate(ctx context.Context, key PrimaryKeyType, out interface{}, fn func() error, opts ...MutateOption) error {
+	options := defaultMutateOptions()
+	for _, opt := range opts {
+		opt(options)
+	}
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || options.maxRetries < 0 {
+		return ErrInput
+	}
+	for attempt := 0; ; attempt++ {
+		conflict, err := rs.mutateOnce(ctx, key, rv, fn, options)
+		if !conflict {
+			return err
+		}
+		if attempt >= options.maxRetries {
+			return err
+		}
+		if err := sleepBackoff(ctx, options.backoff, attempt); err != nil {
+			return err
+		}
+	}
+}
+
+func (rs *Service) mutateOnce(ctx context.Context, key PrimaryKeyType, rv reflect.Value, fn func() error, options *MutateOptions) (conflict bool, err error) {
+	// 重试时清掉上一次的修改
+	rv.Elem().Set(reflect.Zero(rv.Elem().Type()))
+	var cond expression.ConditionBuilder
+	raw, err := rs.getRaw(ctx, key, GetConsistent(true))
+	switch {
+	case errors.Is(err, ErrItemNotFound) && options.create:
+		cond = ConditionItemNotExist()
+	case err != nil:
+		return false, err
+	default:
+		if err := rs.decodeItem(ctx, raw, rv.Interface(), decodeOptions{}); err != nil {
+			return false, err
+		}
+		if cond, err = stateCondition(raw, options.fullState); err != nil {
+			return false, err
+		}
+	}
+	if err := fn(); err != nil {
+		return false, err
+	}
+	// 主键变化时条件写入会创建另一个 item
+	item, err := rs.codec.MarshalMap(rv.Interface())
+	if err != nil {
+		return false, err
+	}
+	for name, av := range key {
+		if !attributeValueEqual(item[name], av) {
+			return false, ErrInput
+		}
+	}
+	setVersion(rv, newVersion())
+	// 只有写入的条件检查失败说明期间有其他写入
+	err = rs.Put(ctx, rv.Interface(), PutCondition(cond))
+	return errors.Is(err, ErrConditionalCheck), err
+}
+
+// stateCondition item 没有被修改的条件
+// 完整 item 的条件每个属性一个比较, 超过 maxStateCondition 时改为比较 Version, 没有 Version 属性时返回 ErrInput
+func stateCondition(raw map[string]*dynamodb.AttributeValue, fullState bool) (expression.ConditionBuilder, error) {
+	v, hasVersion := raw[versionAttr]
+	hasVersion = hasVersion && v.S != nil
+	versionCond := expression.Equal(expression.Name(versionAttr), expression.Value(v))
+	if hasVersion && !fullState {
+		return versionCond, nil
+	}
+	cond := ConditionItemExist()
+	for _, name := range attributeNames(raw) {
+		cond = cond.And(expression.Equal(expression.Name(name), expression.Value(raw[name])))
+	}
+	expr, err := expression.NewBuilder().WithCondition(cond).Build()
+	if err != nil {
+		return cond, err
+	}
+	if len(aws.StringValue(expr.Condition())) <= maxStateCondition {
+		return cond, nil
+	}
+	if hasVersion {
+		return versionCond, nil
+	}
+	return cond, fmt.Errorf("%w: item is too large for a full-state condition, add a %s attribute", ErrInput, versionAttr)
+}
+
+// setVersion 如果 out 有字符串类型的 Version 字段则写入 version
+func setVersion(rv reflect.Value, version string) {
+	v := rv.Elem()
+	if v.Kind() != reflect.Struct {
+		return
+	}
+	for _, f := range structFields(v.Type()) {
+		if f.Name != versionAttr || f.Type.Kind() != reflect.String {
+			continue
+		}
+		if fv, ok := fieldByIndex(v, f.Index); ok && fv.CanSet() {
+			fv.SetString(version)
+		}
+		return
+	}
+}
+
+func newVersion() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		mrand.Read(b)
+	}
+	return hex.EncodeToString(b)
+}
+
+// sleepBackoff 指数退避加随机抖动
+func sleepBackoff(ctx context.Context, base time.Duration, attempt int) error {
+	d := base << uint(attempt)
+	if d <= 0 || d > maxMutateBackoff {
+		d = maxMutateBackoff
+	}
+	d = d/2 + time.Duration(mrand.Int63n(int64(d/2)+1))
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,96 @@
+package rotor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+type mutateTestItem struct {
+	BaseSchema
+
+	Count int
+}
+
+func TestMutateKey(t *testing.T) {
+	rs, fake := newFakeService(func(op string, input interface{}) (interface{}, error) {
+		switch op {
+		case "GetItem":
+			return &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{
+				"PK": {S: aws.String("Item#1")}, "SK": {S: aws.String("Item")}, "Count": {N: aws.String("1")},
+			}}, nil
+		case "PutItem":
+			return &dynamodb.PutItemOutput{}, nil
+		}
+		return nil, errors.New(op)
+	})
+	ctx := context.TODO()
+	key := PrimaryKey("Item#1", "Item")
+
+	var item mutateTestItem
+	err := rs.Mutate(ctx, key, &item, func() error {
+		item.PK = "Item#2"
+		return nil
+	})
+	if !errors.Is(err, ErrInput) {
+		t.Errorf("Mutate失败: 修改主键应该返回 ErrInput %v", err)
+	}
+	if err := rs.Mutate(ctx, key, &item, func() error {
+		item.Count++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// fn 返回的 ErrConditionalCheck 不是写入冲突, 不应该重试
+	if err := rs.Mutate(ctx, key, &item, func() error {
+		return ErrConditionalCheck
+	}); !errors.Is(err, ErrConditionalCheck) {
+		t.Errorf("Mutate失败: 应该返回 fn 的错误 %v", err)
+	}
+	if ops := fake.ops(); !reflect.DeepEqual(ops, []string{"GetItem", "GetItem", "PutItem", "GetItem"}) {
+		t.Errorf("Mutate失败: 不是预期的请求 %v", ops)
+	}
+	put := fake.calls[2].input.(*dynamodb.PutItemInput)
+	if aws.StringValue(put.Item["PK"].S) != "Item#1" || aws.StringValue(put.Item["Count"].N) != "2" {
+		t.Errorf("Mutate失败: 不是预期的 item %v", put.Item)
+	}
+}
+
+func TestStateCondition(t *testing.T) {
+	raw := map[string]*dynamodb.AttributeValue{"PK": {S: aws.String("Item#1")}, "Count": {N: aws.String("1")}}
+	cond, err := stateCondition(raw, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expr, err := expression.NewBuilder().WithCondition(cond).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resolveExpression(expr.Condition(), expr.Names()); !strings.Contains(got, "Count = :") {
+		t.Errorf("stateCondition失败: 应该比较完整的 item %s", got)
+	}
+
+	for i := 0; i < 300; i++ {
+		raw[fmt.Sprintf("Attr%d", i)] = &dynamodb.AttributeValue{N: aws.String("1")}
+	}
+	if _, err := stateCondition(raw, true); !errors.Is(err, ErrInput) {
+		t.Errorf("stateCondition失败: 太大且没有 Version 时应该返回 ErrInput %v", err)
+	}
+	raw[versionAttr] = &dynamodb.AttributeValue{S: aws.String("v1")}
+	if cond, err = stateCondition(raw, true); err != nil {
+		t.Fatal(err)
+	}
+	if expr, err = expression.NewBuilder().WithCondition(cond).Build(); err != nil {
+		t.Fatal(err)
+	}
+	if got := resolveExpression(expr.Condition(), expr.Names()); got != "Version = :0" {
+		t.Errorf("stateCondition失败: 太大时应该只比较 Version %s", got)
+	}
+}
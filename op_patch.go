@@ -0,0 +1,106 @@
+package rotor
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// PatchNull 删除标记
+// Patch 中值为 PatchNull 的字段会被 REMOVE, 字段需要声明为 interface{}
+type PatchNull struct{}
+
+var patchNullType = reflect.TypeOf(PatchNull{})
+
+// Patch 将 patch 中设置了的字段转换为 update expression
+// nil 指针/interface/map/slice 和零值字段会被忽略, 指向零值的指针会写入零值, 值为 PatchNull 的字段会被删除
+// patch 设置主键 PK/SK 会返回 ErrInput, 设置了加密字段时需要使用 Service.Patch 绑定主键
+func (codec Codec) Patch(patch interface{}) (update expression.UpdateBuilder, changed bool, err error) {
+	return codec.patch(patch, nil, nil)
+}
+
+// patch offload 不为 nil 时在生成 update 之前转存 SET 的字段
+func (codec Codec) patch(patch interface{}, key PrimaryKeyType, offload offloadHook) (update expression.UpdateBuilder, changed bool, err error) {
+	rv := reflect.ValueOf(patch)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return update, false, ErrInput
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return update, false, ErrInput
+	}
+	if key != nil {
+		codec = codec.withItemKey(key)
+	} else if codec.config != nil && hasEncrypted(rv.Type(), map[reflect.Type]bool{}) {
+		return update, false, fmt.Errorf("%w: patch with encrypted fields needs the primary key", ErrEncryption)
+	}
+	item, err := codec.MarshalMap(patch)
+	if err != nil {
+		return update, false, err
+	}
+	set := map[string]*dynamodb.AttributeValue{}
+	var names, remove []string
+	for _, f := range structFields(rv.Type()) {
+		fv, ok := fieldByIndex(rv, f.Index)
+		if !ok || fv.IsZero() {
+			continue
+		}
+		if isPatchNull(fv) {
+			if f.Name == tablePK || f.Name == tableSK {
+				return update, false, ErrInput
+			}
+			remove = append(remove, f.Name)
+			continue
+		}
+		av, ok := item[f.Name]
+		if !ok {
+			continue
+		}
+		if f.Name == tablePK || f.Name == tableSK {
+			return update, false, ErrInput
+		}
+		set[f.Name] = av
+		names = append(names, f.Name)
+	}
+	if offload != nil {
+		if err := offload(rv.Type(), set, remove); err != nil {
+			return update, false, err
+		}
+	}
+	for _, name := range names {
+		update = update.Set(expression.Name(name), expression.Value(set[name]))
+		changed = true
+	}
+	for _, name := range remove {
+		update = update.Remove(expression.Name(name))
+		changed = true
+	}
+	return update, changed, nil
+}
+
+func isPatchNull(v reflect.Value) bool {
+	for v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return false
+		}
+		v = v.Elem()
+	}
+	return v.Type() == patchNullType
+}
+
+// Patch update item with the fields set in patch
+// 没有需要更新的字段时不会发起请求, 可以配合 UpdateCondition 使用; 配置了 ServiceBlobStore 时设置的转存字段会被转存
+func (rs *Service) Patch(ctx context.Context, key PrimaryKeyType, patch interface{}, opts ...UpdateOption) error {
+	// patch 只校验设置了的字段
+	if err := validateItem(patch, true); err != nil {
+		return err
+	}
+	return rs.updateOffloaded(ctx, key, reflect.TypeOf(patch), func(hook offloadHook) (expression.UpdateBuilder, bool, error) {
+		return rs.codec.patch(patch, key, hook)
+	}, opts...)
+}
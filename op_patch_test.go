@@ -0,0 +1,63 @@
+package rotor_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/lixw1994/rotor"
+)
+
+type userPatch struct {
+	Name  *string
+	Age   *int64
+	Email interface{}
+	Note  string
+}
+
+func TestPatch(t *testing.T) {
+	codec := rotor.NewCodec()
+	t.Run("Patch-OK", func(t *testing.T) {
+		update, changed, err := codec.Patch(&userPatch{
+			Age:   aws.Int64(0),
+			Email: rotor.PatchNull{},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !changed {
+			t.Fatal("Patch失败: 应该有变化")
+		}
+		expr, err := expression.NewBuilder().WithUpdate(update).Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := map[string]bool{}
+		for _, name := range expr.Names() {
+			names[aws.StringValue(name)] = true
+		}
+		if !names["Age"] || !names["Email"] || names["Name"] || names["Note"] {
+			t.Errorf("Patch失败: 不是预期的字段 %v", names)
+		}
+		t.Logf("Patch: %s", aws.StringValue(expr.Update()))
+	})
+	t.Run("Patch-Empty", func(t *testing.T) {
+		_, changed, err := codec.Patch(&userPatch{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changed {
+			t.Error("Patch失败: 不应该有变化")
+		}
+	})
+	t.Run("Patch-Key", func(t *testing.T) {
+		_, _, err := codec.Patch(&struct {
+			rotor.BaseSchema
+			Name *string
+		}{BaseSchema: rotor.BaseSchema{PK: "P#1"}})
+		if !errors.Is(err, rotor.ErrInput) {
+			t.Errorf("Patch失败: 修改主键应该报错 %v", err)
+		}
+	})
+}
@@ -2,6 +2,7 @@ package rotor
 
 import (
 	"context"
+	"reflect"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -11,7 +12,8 @@ import (
 
 // PutOptions PutOptions
 type PutOptions struct {
-	builder *expression.Builder
+	builder   *expression.Builder
+	returnOld bool
 }
 
 func defaultPutOptions() *PutOptions {
@@ -32,12 +34,23 @@ func PutCondition(condition expression.ConditionBuilder) PutOption {
 	}
 }
 
+// PutConditionReturnOld 条件检查失败时返回带当前 item 的 ConditionalCheckError
+// 请求会以单条事务发出, 消耗双倍的写容量, 不能用于 PutOut
+func PutConditionReturnOld() PutOption {
+	return func(options *PutOptions) {
+		options.returnOld = true
+	}
+}
+
 // PutOut put item
 func (rs *Service) PutOut(ctx context.Context, in interface{}, out interface{}, opts ...PutOption) error {
 	options := defaultPutOptions()
 	for _, opt := range opts {
 		opt(options)
 	}
+	if options.returnOld {
+		return ErrReturnValue
+	}
 	var expr expression.Expression
 	var err error
 	if options.builder != nil {
@@ -46,7 +59,10 @@ func (rs *Service) PutOut(ctx context.Context, in interface{}, out interface{},
 			return err
 		}
 	}
-	item, err := rs.codec.MarshalMap(in)
+	if in, err = rs.beforePut(ctx, in); err != nil {
+		return err
+	}
+	item, err := rs.marshalItem(ctx, in)
 	if err != nil {
 		return err
 	}
@@ -60,6 +76,7 @@ func (rs *Service) PutOut(ctx context.Context, in interface{}, out interface{},
 	}
 	ret, err := rs.dynamo.PutItemWithContext(ctx, input)
 	if err != nil {
+		rs.discardBlobs(ctx, item)
 		if aerr, ok := err.(awserr.Error); ok {
 			switch aerr.Code() {
 			case dynamodb.ErrCodeConditionalCheckFailedException:
@@ -70,7 +87,11 @@ func (rs *Service) PutOut(ctx context.Context, in interface{}, out interface{},
 		}
 		return err
 	}
-	return rs.codec.UnmarshalMap(ret.Attributes, out)
+	if err := rs.decodeItem(ctx, ret.Attributes, out, decodeOptions{}); err != nil {
+		return err
+	}
+	rs.cleanupBlobs(ctx, ret.Attributes, item)
+	return rs.afterWrite(ctx, in)
 }
 
 // Put put item
@@ -87,20 +108,60 @@ func (rs *Service) Put(ctx context.Context, in interface{}, opts ...PutOption) e
 			return err
 		}
 	}
-	item, err := rs.codec.MarshalMap(in)
+	if in, err = rs.beforePut(ctx, in); err != nil {
+		return err
+	}
+	item, err := rs.marshalItem(ctx, in)
 	if err != nil {
 		return err
 	}
+	if options.returnOld {
+		// 事务拿不到旧 item, 覆盖前读取用来清理不再引用的对象
+		var old []map[string]*dynamodb.AttributeValue
+		if rs.blobStore != nil && len(offloadFields(reflect.TypeOf(in))) > 0 {
+			key, err := itemKey(item, []string{tablePK, tableSK})
+			if err == nil {
+				old, err = rs.currentItems(ctx, key)
+			}
+			if err != nil {
+				rs.discardBlobs(ctx, item)
+				return err
+			}
+		}
+		err := rs.transactOne(ctx, &dynamodb.TransactWriteItem{
+			Put: &dynamodb.Put{
+				TableName:                 rs.tableName,
+				Item:                      item,
+				ConditionExpression:       expr.Condition(),
+				ExpressionAttributeNames:  expr.Names(),
+				ExpressionAttributeValues: expr.Values(),
+			},
+		})
+		if err != nil {
+			rs.discardBlobs(ctx, item)
+			return err
+		}
+		for _, o := range old {
+			rs.cleanupBlobs(ctx, o, item)
+		}
+		return rs.afterWrite(ctx, in)
+	}
+	// 覆盖时需要旧 item 来清理不再引用的对象
+	returnValues := dynamodb.ReturnValueNone
+	if rs.blobStore != nil && len(offloadFields(reflect.TypeOf(in))) > 0 {
+		returnValues = dynamodb.ReturnValueAllOld
+	}
 	input := &dynamodb.PutItemInput{
 		TableName:                 rs.tableName,
 		Item:                      item,
 		ConditionExpression:       expr.Condition(),
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
-		ReturnValues:              aws.String(dynamodb.ReturnValueNone),
+		ReturnValues:              aws.String(returnValues),
 	}
-	_, err = rs.dynamo.PutItemWithContext(ctx, input)
+	ret, err := rs.dynamo.PutItemWithContext(ctx, input)
 	if err != nil {
+		rs.discardBlobs(ctx, item)
 		if aerr, ok := err.(awserr.Error); ok {
 			switch aerr.Code() {
 			case dynamodb.ErrCodeConditionalCheckFailedException:
@@ -111,7 +172,8 @@ func (rs *Service) Put(ctx context.Context, in interface{}, opts ...PutOption) e
 		}
 		return err
 	}
-	return nil
+	rs.cleanupBlobs(ctx, ret.Attributes, item)
+	return rs.afterWrite(ctx, in)
 }
 
 // PutIfNotExist put item if not exist
@@ -139,12 +201,34 @@ func (rs *Service) PutBatch(ctx context.Context, ins []interface{}, opts ...PutO
 		}
 	}
 
+	// BeforePut 可能返回副本, 不修改调用方的 slice
+	ins = append([]interface{}{}, ins...)
 	inItems := make([]*dynamodb.TransactWriteItem, len(ins))
+	// 写入没有成功时删除已经转存的对象
+	var offloaded []map[string]*dynamodb.AttributeValue
+	defer func() {
+		rs.discardBlobs(ctx, offloaded...)
+	}()
+	// 事务拿不到旧 item, 写入前读取被覆盖的 item, 成功后清理不再引用的对象
+	var ownerKeys []PrimaryKeyType
+	var ownerItems []map[string]*dynamodb.AttributeValue
 	for i, in := range ins {
-		item, err := rs.codec.MarshalMap(in)
+		if ins[i], err = rs.beforePut(ctx, in); err != nil {
+			return err
+		}
+		item, err := rs.marshalItem(ctx, ins[i])
 		if err != nil {
 			return err
 		}
+		offloaded = append(offloaded, item)
+		if rs.blobStore != nil && len(offloadFields(reflect.TypeOf(ins[i]))) > 0 {
+			key, err := itemKey(item, []string{tablePK, tableSK})
+			if err != nil {
+				return err
+			}
+			ownerKeys = append(ownerKeys, key)
+			ownerItems = append(ownerItems, item)
+		}
 		inItems[i] = &dynamodb.TransactWriteItem{
 			Put: &dynamodb.Put{
 				TableName:                 rs.tableName,
@@ -155,6 +239,10 @@ func (rs *Service) PutBatch(ctx context.Context, ins []interface{}, opts ...PutO
 			},
 		}
 	}
+	old, err := rs.currentItems(ctx, ownerKeys...)
+	if err != nil {
+		return err
+	}
 	_, err = rs.dynamo.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
 		TransactItems: inItems,
 	})
@@ -169,5 +257,9 @@ func (rs *Service) PutBatch(ctx context.Context, ins []interface{}, opts ...PutO
 		}
 		return err
 	}
-	return nil
+	offloaded = nil
+	for i, item := range old {
+		rs.cleanupBlobs(ctx, item, ownerItems[i])
+	}
+	return rs.afterWriteAll(ctx, ins)
 }
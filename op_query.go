@@ -2,6 +2,7 @@ package rotor
 
 import (
 	"context"
+	"reflect"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
@@ -22,6 +23,10 @@ type QueryOptions struct {
 	consistentRead *bool
 	selectType     *string
 	indexName      *string
+	maxItems       int
+	startKey       PrimaryKeyType
+	cursor         string
+	decode         decodeOptions
 }
 
 func defaultQueryOptions(keyCond expression.KeyConditionBuilder) *QueryOptions {
@@ -42,6 +47,7 @@ func QueryProjection(projection expression.ProjectionBuilder) QueryOption {
 			options.builder = &builder
 		}
 		*options.builder = options.builder.WithProjection(projection)
+		options.decode.partial = true
 	}
 }
 
@@ -64,6 +70,7 @@ func QueryConsistent(strong bool) QueryOption {
 }
 
 // QuerySelectType QuerySelectType
+// QuerySelectCount 时 out 为 *int64 或 *int, 保存满足条件的 item 数量
 func QuerySelectType(v string) QueryOption {
 	return func(options *QueryOptions) {
 		options.selectType = aws.String(v)
@@ -77,13 +84,75 @@ func QueryIndex(indexName string) QueryOption {
 	}
 }
 
+// QueryMaxItems 过滤后最多返回 n 条
+// DynamoDB 的 Limit 作用于 FilterExpression 之前, 设置后会持续翻页直到 n 条通过过滤或分区结束
+func QueryMaxItems(n int) QueryOption {
+	return func(options *QueryOptions) {
+		options.maxItems = n
+	}
+}
+
+// QueryStartKey 从 key 之后开始查询, key 一般为 QueryPage 返回的游标
+func QueryStartKey(key PrimaryKeyType) QueryOption {
+	return func(options *QueryOptions) {
+		options.startKey = key
+	}
+}
+
+// QueryCursor 从 QueryPageCursor 返回的游标之后开始查询
+// 游标与生成它的 key condition 绑定, 用在其他查询上会返回 ErrCursor
+func QueryCursor(cursor string) QueryOption {
+	return func(options *QueryOptions) {
+		options.cursor = cursor
+	}
+}
+
+// QuerySkipBlobs 不加载转存到 BlobStore 的属性, 需要时用 LoadBlobs 加载
+func QuerySkipBlobs() QueryOption {
+	return func(options *QueryOptions) {
+		options.decode.skipBlobs = true
+	}
+}
+
+// QueryStrict 本次查询是否使用严格模式, 覆盖 Service 和 Codec 的设置
+func QueryStrict(strict bool) QueryOption {
+	return func(options *QueryOptions) {
+		options.decode.strict = aws.Bool(strict)
+	}
+}
+
 // Query query items
 // Item not found will return error
 func (rs *Service) Query(ctx context.Context, keyCond expression.KeyConditionBuilder, out interface{}, opts ...QueryOption) error {
+	_, _, err := rs.query(ctx, keyCond, out, opts...)
+	return err
+}
+
+// QueryPage query items and return the cursor for next page
+// 配合 QueryMaxItems 使用时游标指向最后一条返回的数据, 分区结束时游标为 nil
+func (rs *Service) QueryPage(ctx context.Context, keyCond expression.KeyConditionBuilder, out interface{}, opts ...QueryOption) (PrimaryKeyType, error) {
+	_, lastKey, err := rs.query(ctx, keyCond, out, opts...)
+	return lastKey, err
+}
+
+// QueryPageCursor query items and return the encoded cursor for next page
+// 游标可以直接交给客户端, 配置了 ServiceCursorKeyRing 时会签名或加密, 分区结束时游标为空字符串
+func (rs *Service) QueryPageCursor(ctx context.Context, keyCond expression.KeyConditionBuilder, out interface{}, opts ...QueryOption) (string, error) {
+	input, lastKey, err := rs.query(ctx, keyCond, out, opts...)
+	if err != nil || len(lastKey) == 0 {
+		return "", err
+	}
+	return rs.encodeCursor(input, lastKey)
+}
+
+func (rs *Service) query(ctx context.Context, keyCond expression.KeyConditionBuilder, out interface{}, opts ...QueryOption) (*dynamodb.QueryInput, PrimaryKeyType, error) {
 	options := defaultQueryOptions(keyCond)
 	for _, opt := range opts {
 		opt(options)
 	}
+	if options.maxItems < 0 || options.maxItems > maxReadNum {
+		return nil, nil, ErrInput
+	}
 	var expr expression.Expression
 	var err error
 	if options.builder == nil {
@@ -92,7 +161,7 @@ func (rs *Service) Query(ctx context.Context, keyCond expression.KeyConditionBui
 	}
 	expr, err = options.builder.WithKeyCondition(keyCond).Build()
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	input := &dynamodb.QueryInput{
 		TableName:                 rs.tableName,
@@ -101,36 +170,64 @@ func (rs *Service) Query(ctx context.Context, keyCond expression.KeyConditionBui
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
 		FilterExpression:          expr.Filter(),
-		ProjectionExpression:      expr.Condition(),
+		ProjectionExpression:      expr.Projection(),
 		ConsistentRead:            options.consistentRead,
+		ExclusiveStartKey:         options.startKey,
 	}
 	if options.selectType != nil {
 		switch *options.selectType {
 		case QuerySelectASC:
 			input.ScanIndexForward = aws.Bool(true)
-			return rs.queryData(ctx, input, out)
 		case QuerySelectDESC:
 			input.ScanIndexForward = aws.Bool(false)
-			return rs.queryData(ctx, input, out)
 		case QuerySelectCount:
+			// COUNT 不能和 projection 同时使用
+			if input.ProjectionExpression != nil {
+				return nil, nil, ErrInput
+			}
 			input.Select = aws.String(dynamodb.SelectCount)
+			rv := reflect.ValueOf(out)
+			if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Int64 && rv.Elem().Kind() != reflect.Int {
+				return nil, nil, ErrInput
+			}
 			c, err := rs.queryCount(ctx, input)
 			if err != nil {
-				return err
+				return nil, nil, err
 			}
-			out = c
-			return nil
+			rv.Elem().SetInt(c)
+			return input, nil, nil
 		default:
-			return ErrInput
+			return nil, nil, ErrInput
 		}
 	}
-	return rs.queryData(ctx, input, out)
+	// 游标绑定了查询方向, 需要在 ScanIndexForward 确定之后解码
+	if options.cursor != "" {
+		input.ExclusiveStartKey, err = rs.decodeCursor(input, options.cursor)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	// 索引可能只投影了部分属性
+	dopts := options.decode
+	if options.indexName != nil {
+		dopts.partial = true
+	}
+	dopts.fullItem = !dopts.partial
+	var lastKey PrimaryKeyType
+	if options.maxItems > 0 {
+		lastKey, err = rs.queryMaxItems(ctx, input, options.maxItems, out, dopts)
+	} else {
+		lastKey, err = rs.queryData(ctx, input, out, dopts)
+	}
+	return input, lastKey, err
 }
 
-func (rs *Service) queryData(ctx context.Context, input *dynamodb.QueryInput, out interface{}) error {
+func (rs *Service) queryData(ctx context.Context, input *dynamodb.QueryInput, out interface{}, dopts decodeOptions) (PrimaryKeyType, error) {
 	allItems := []map[string]*dynamodb.AttributeValue{}
+	var lastKey PrimaryKeyType
 	err := rs.dynamo.QueryPagesWithContext(ctx, input, func(qo *dynamodb.QueryOutput, b bool) bool {
 		allItems = append(allItems, qo.Items...)
+		lastKey = qo.LastEvaluatedKey
 		// TODO: 是否需要更好的处理
 		if len(allItems) > maxReadNum {
 			return false
@@ -138,15 +235,57 @@ func (rs *Service) queryData(ctx context.Context, input *dynamodb.QueryInput, ou
 		return true
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return rs.codec.UnmarshalListOfMaps(allItems, out)
+	return lastKey, rs.decodeItems(ctx, allItems, out, dopts)
 }
 
-func (rs *Service) queryCount(ctx context.Context, input *dynamodb.QueryInput) (*int64, error) {
-	ret, err := rs.dynamo.QueryWithContext(ctx, input)
+// queryMaxItems 翻页直到过滤后凑够 maxItems 条
+// 最后一页只用了一部分时, 游标由最后一条数据的主键构造, 而不是 LastEvaluatedKey
+func (rs *Service) queryMaxItems(ctx context.Context, input *dynamodb.QueryInput, maxItems int, out interface{}, dopts decodeOptions) (PrimaryKeyType, error) {
+	allItems := make([]map[string]*dynamodb.AttributeValue, 0, maxItems)
+	var lastKey PrimaryKeyType
+	var keyNames []string
+	truncated := false
+	err := rs.dynamo.QueryPagesWithContext(ctx, input, func(qo *dynamodb.QueryOutput, b bool) bool {
+		if len(qo.LastEvaluatedKey) > 0 {
+			keyNames = attributeNames(qo.LastEvaluatedKey)
+		}
+		need := maxItems - len(allItems)
+		if len(qo.Items) < need {
+			allItems = append(allItems, qo.Items...)
+			lastKey = qo.LastEvaluatedKey
+			return true
+		}
+		allItems = append(allItems, qo.Items[:need]...)
+		lastKey = qo.LastEvaluatedKey
+		truncated = len(qo.Items) > need
+		return false
+	})
 	if err != nil {
 		return nil, err
 	}
-	return ret.Count, nil
+	if truncated {
+		if keyNames == nil {
+			keyNames, err = rs.keyNames(ctx, aws.StringValue(input.IndexName))
+			if err != nil {
+				return nil, err
+			}
+		}
+		lastKey, err = itemKey(allItems[len(allItems)-1], keyNames)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return lastKey, rs.decodeItems(ctx, allItems, out, dopts)
+}
+
+// queryCount 每页最多统计 1MB 的数据, 需要累加所有页
+func (rs *Service) queryCount(ctx context.Context, input *dynamodb.QueryInput) (int64, error) {
+	var count int64
+	err := rs.dynamo.QueryPagesWithContext(ctx, input, func(qo *dynamodb.QueryOutput, last bool) bool {
+		count += aws.Int64Value(qo.Count)
+		return true
+	})
+	return count, err
 }
@@ -0,0 +1,48 @@
+package rotor
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+func TestQueryProjectionAndCount(t *testing.T) {
+	rs, fake := newFakeService(func(op string, input interface{}) (interface{}, error) {
+		in := input.(*dynamodb.QueryInput)
+		if aws.StringValue(in.Select) != dynamodb.SelectCount {
+			return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{{"Name": {S: aws.String("n")}}}}, nil
+		}
+		// 第一页带 LastEvaluatedKey, 第二页结束
+		if in.ExclusiveStartKey == nil {
+			return &dynamodb.QueryOutput{Count: aws.Int64(3), LastEvaluatedKey: PrimaryKey("User#1", "User#3")}, nil
+		}
+		return &dynamodb.QueryOutput{Count: aws.Int64(2)}, nil
+	})
+	ctx := context.TODO()
+	keyCond := expression.Key(tablePK).Equal(expression.Value("User#1"))
+
+	var names []struct{ Name string }
+	if err := rs.Query(ctx, keyCond, &names, QueryProjection(expression.NamesList(expression.Name("Name")))); err != nil {
+		t.Fatal(err)
+	}
+	input := fake.calls[0].input.(*dynamodb.QueryInput)
+	if got := resolveExpression(input.ProjectionExpression, input.ExpressionAttributeNames); got != "Name" {
+		t.Errorf("QueryProjection失败: 不是预期的 projection %s", got)
+	}
+
+	var count int64
+	if err := rs.Query(ctx, keyCond, &count, QuerySelectType(QuerySelectCount)); err != nil {
+		t.Fatal(err)
+	}
+	if count != 5 {
+		t.Errorf("QuerySelectCount失败: 应该累加所有页 %d", count)
+	}
+	var wrong string
+	if err := rs.Query(ctx, keyCond, &wrong, QuerySelectType(QuerySelectCount)); !errors.Is(err, ErrInput) {
+		t.Errorf("QuerySelectCount失败: out 不是整数时应该返回 ErrInput %v", err)
+	}
+}
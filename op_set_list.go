@@ -0,0 +1,149 @@
+package rotor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+const maxListTrimRetry = 3
+
+// setValue 把 slice 编码为 set, 元素全部为字符串/数字/[]byte 时分别对应 SS/NS/BS
+// DynamoDB 不允许空 set, values 为空时返回 ErrInput
+func (codec Codec) setValue(values interface{}) (*dynamodb.AttributeValue, error) {
+	list, err := codec.MarshalList(values)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, ErrInput
+	}
+	set := &dynamodb.AttributeValue{}
+	for _, av := range list {
+		switch {
+		case av.S != nil && set.NS == nil && set.BS == nil:
+			set.SS = append(set.SS, av.S)
+		case av.N != nil && set.SS == nil && set.BS == nil:
+			set.NS = append(set.NS, av.N)
+		case av.B != nil && set.SS == nil && set.NS == nil:
+			set.BS = append(set.BS, av.B)
+		default:
+			return nil, ErrInput
+		}
+	}
+	return set, nil
+}
+
+// updateAttr out 不为 nil 时把更新后的 attr 解码到 out
+func (rs *Service) updateAttr(ctx context.Context, key PrimaryKeyType, attr string, update expression.UpdateBuilder, out interface{}, opts ...UpdateOption) error {
+	if out == nil {
+		_, err := rs.updateRaw(ctx, key, update, UpdateReturnValueNone, true, opts...)
+		return err
+	}
+	attrs, err := rs.updateRaw(ctx, key, update, UpdateReturnValueUpdatedNew, true, opts...)
+	if err != nil {
+		return err
+	}
+	return rs.unmarshalAttr(attrs, attr, out)
+}
+
+// unmarshalAttr 属性不存在时 out 保持不变, 例如 set 的元素被全部删除
+func (rs *Service) unmarshalAttr(attrs map[string]*dynamodb.AttributeValue, attr string, out interface{}) error {
+	av, ok := attrs[attr]
+	if !ok {
+		return nil
+	}
+	return rs.codec.Unmarshal(av, out)
+}
+
+// AddToSet 向 set 属性 attr 添加 values, 属性不存在时会创建
+// values 为 []string, 数字 slice 或 [][]byte, out 不为 nil 时返回更新后的 set
+func (rs *Service) AddToSet(ctx context.Context, key PrimaryKeyType, attr string, values interface{}, out interface{}, opts ...UpdateOption) error {
+	set, err := rs.codec.setValue(values)
+	if err != nil {
+		return err
+	}
+	update := expression.Add(expression.Name(attr), expression.Value(set))
+	return rs.updateAttr(ctx, key, attr, update, out, opts...)
+}
+
+// RemoveFromSet 从 set 属性 attr 删除 values, 删空后属性会被删除
+func (rs *Service) RemoveFromSet(ctx context.Context, key PrimaryKeyType, attr string, values interface{}, out interface{}, opts ...UpdateOption) error {
+	set, err := rs.codec.setValue(values)
+	if err != nil {
+		return err
+	}
+	update := expression.Delete(expression.Name(attr), expression.Value(set))
+	return rs.updateAttr(ctx, key, attr, update, out, opts...)
+}
+
+// AppendToList 向 list 属性 attr 末尾追加 values, 属性不存在时会创建
+// maxLen 大于 0 时超出的部分从头部删除, 删除以 list 长度为条件, 并发追加时会重试
+func (rs *Service) AppendToList(ctx context.Context, key PrimaryKeyType, attr string, values interface{}, maxLen int, out interface{}, opts ...UpdateOption) error {
+	list, err := rs.codec.MarshalList(values)
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		return ErrInput
+	}
+	name := expression.Name(attr)
+	update := expression.Set(name, expression.ListAppend(
+		expression.IfNotExists(name, expression.Value(&dynamodb.AttributeValue{L: []*dynamodb.AttributeValue{}})),
+		expression.Value(&dynamodb.AttributeValue{L: list}),
+	))
+	if maxLen <= 0 {
+		return rs.updateAttr(ctx, key, attr, update, out, opts...)
+	}
+	attrs, err := rs.updateRaw(ctx, key, update, UpdateReturnValueUpdatedNew, true, opts...)
+	if err != nil {
+		return err
+	}
+	for retry := 0; ; retry++ {
+		current := attrs[attr]
+		if current == nil || len(current.L) <= maxLen {
+			break
+		}
+		if retry >= maxListTrimRetry {
+			return ErrConditionalCheck
+		}
+		var trim expression.UpdateBuilder
+		for i := 0; i < len(current.L)-maxLen; i++ {
+			trim = trim.Remove(expression.Name(fmt.Sprintf("%s[%d]", attr, i)))
+		}
+		attrs, err = rs.updateRaw(ctx, key, trim, UpdateReturnValueAllNew, true,
+			UpdateCondition(name.Size().Equal(expression.Value(len(current.L)))))
+		if errors.Is(err, ErrConditionalCheck) {
+			attrs, err = rs.getRaw(ctx, key, GetConsistent(true), GetProjection(expression.NamesList(name)))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if out == nil {
+		return nil
+	}
+	return rs.unmarshalAttr(attrs, attr, out)
+}
+
+// RemoveListIndex 删除 list 属性 attr 中下标为 index 的元素
+// 下标越界时返回 ErrConditionalCheck
+func (rs *Service) RemoveListIndex(ctx context.Context, key PrimaryKeyType, attr string, index int, out interface{}, opts ...UpdateOption) error {
+	if index < 0 {
+		return ErrInput
+	}
+	update := expression.Remove(expression.Name(fmt.Sprintf("%s[%d]", attr, index)))
+	opts = append(append([]UpdateOption{}, opts...), UpdateConditionAnd(expression.Name(attr).Size().GreaterThan(expression.Value(index))))
+	if out == nil {
+		_, err := rs.updateRaw(ctx, key, update, UpdateReturnValueNone, true, opts...)
+		return err
+	}
+	attrs, err := rs.updateRaw(ctx, key, update, UpdateReturnValueAllNew, true, opts...)
+	if err != nil {
+		return err
+	}
+	return rs.unmarshalAttr(attrs, attr, out)
+}
@@ -0,0 +1,181 @@
+package rotor
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// scriptedDynamo 按顺序返回 responses, 用完后返回错误
+func scriptedDynamo(responses ...func(input interface{}) (interface{}, error)) func(op string, input interface{}) (interface{}, error) {
+	return func(op string, input interface{}) (interface{}, error) {
+		if len(responses) == 0 {
+			return nil, errors.New("unexpected " + op)
+		}
+		next := responses[0]
+		responses = responses[1:]
+		return next(input)
+	}
+}
+
+func listAttr(attr string, n int) map[string]*dynamodb.AttributeValue {
+	list := make([]*dynamodb.AttributeValue, n)
+	for i := range list {
+		list[i] = &dynamodb.AttributeValue{S: aws.String(string(rune('a' + i)))}
+	}
+	return map[string]*dynamodb.AttributeValue{attr: {L: list}}
+}
+
+func updated(attrs map[string]*dynamodb.AttributeValue) func(interface{}) (interface{}, error) {
+	return func(interface{}) (interface{}, error) {
+		return &dynamodb.UpdateItemOutput{Attributes: attrs}, nil
+	}
+}
+
+func conditionFailed(interface{}) (interface{}, error) {
+	return nil, fakeError(dynamodb.ErrCodeConditionalCheckFailedException)
+}
+
+// resolveExpression 把表达式中的 #n 替换为属性名
+func resolveExpression(expr *string, names map[string]*string) string {
+	s := strings.TrimSpace(aws.StringValue(expr))
+	for k, v := range names {
+		s = strings.ReplaceAll(s, k, aws.StringValue(v))
+	}
+	return s
+}
+
+func TestSetHelpers(t *testing.T) {
+	ctx := context.TODO()
+	key := PrimaryKey("Post#1", "Post")
+	rs, fake := newFakeService(scriptedDynamo(
+		updated(map[string]*dynamodb.AttributeValue{"Tags": {SS: aws.StringSlice([]string{"a", "b", "c"})}}),
+		updated(nil),
+	))
+
+	var tags []string
+	if err := rs.AddToSet(ctx, key, "Tags", []string{"a", "b"}, &tags); err != nil {
+		t.Fatal(err)
+	}
+	input := fake.calls[0].input.(*dynamodb.UpdateItemInput)
+	if got := resolveExpression(input.UpdateExpression, input.ExpressionAttributeNames); got != "ADD Tags :0" {
+		t.Errorf("AddToSet失败: 不是预期的表达式 %s", got)
+	}
+	if len(input.ExpressionAttributeValues[":0"].SS) != 2 || aws.StringValue(input.ReturnValues) != UpdateReturnValueUpdatedNew {
+		t.Errorf("AddToSet失败: 不是预期的请求 %v", input)
+	}
+	if !reflect.DeepEqual(tags, []string{"a", "b", "c"}) {
+		t.Errorf("AddToSet失败: 不是预期的 set %v", tags)
+	}
+
+	if err := rs.RemoveFromSet(ctx, key, "Scores", []int{1, 2}, nil); err != nil {
+		t.Fatal(err)
+	}
+	input = fake.calls[1].input.(*dynamodb.UpdateItemInput)
+	if got := resolveExpression(input.UpdateExpression, input.ExpressionAttributeNames); got != "DELETE Scores :0" {
+		t.Errorf("RemoveFromSet失败: 不是预期的表达式 %s", got)
+	}
+	if len(input.ExpressionAttributeValues[":0"].NS) != 2 || aws.StringValue(input.ReturnValues) != UpdateReturnValueNone {
+		t.Errorf("RemoveFromSet失败: 不是预期的请求 %v", input)
+	}
+
+	if err := rs.AddToSet(ctx, key, "Tags", []string{}, nil); !errors.Is(err, ErrInput) {
+		t.Errorf("AddToSet失败: 空 set 应该返回 ErrInput %v", err)
+	}
+	if err := rs.AddToSet(ctx, key, "Tags", []interface{}{"a", 1}, nil); !errors.Is(err, ErrInput) {
+		t.Errorf("AddToSet失败: 混合类型应该返回 ErrInput %v", err)
+	}
+}
+
+func TestRemoveListIndex(t *testing.T) {
+	rs, fake := newFakeService(scriptedDynamo(updated(listAttr("Items", 1))))
+	opts := make([]UpdateOption, 1, 2)
+	opts[0] = UpdateCondition(expression.Name("Status").Equal(expression.Value("open")))
+	var items []string
+	if err := rs.RemoveListIndex(context.TODO(), PrimaryKey("Cart#1", "Cart"), "Items", 1, &items, opts...); err != nil {
+		t.Fatal(err)
+	}
+	input := fake.calls[0].input.(*dynamodb.UpdateItemInput)
+	if got := resolveExpression(input.UpdateExpression, input.ExpressionAttributeNames); got != "REMOVE Items[1]" {
+		t.Errorf("RemoveListIndex失败: 不是预期的表达式 %s", got)
+	}
+	cond := resolveExpression(input.ConditionExpression, input.ExpressionAttributeNames)
+	if !strings.Contains(cond, "Status = :") || !strings.Contains(cond, "size (Items) > :") {
+		t.Errorf("RemoveListIndex失败: 应该同时保留调用方的条件 %s", cond)
+	}
+	if opts[:cap(opts)][1] != nil {
+		t.Error("RemoveListIndex失败: 不应该修改调用方的 opts")
+	}
+	if !reflect.DeepEqual(items, []string{"a"}) {
+		t.Errorf("RemoveListIndex失败: 不是预期的 list %v", items)
+	}
+	if err := rs.RemoveListIndex(context.TODO(), PrimaryKey("Cart#1", "Cart"), "Items", -1, nil); !errors.Is(err, ErrInput) {
+		t.Errorf("RemoveListIndex失败: 负数下标应该返回 ErrInput %v", err)
+	}
+}
+
+func TestAppendToList(t *testing.T) {
+	ctx := context.TODO()
+	key := PrimaryKey("Feed#1", "Feed")
+
+	t.Run("Append", func(t *testing.T) {
+		rs, fake := newFakeService(scriptedDynamo(updated(nil)))
+		if err := rs.AppendToList(ctx, key, "Events", []string{"x"}, 0, nil); err != nil {
+			t.Fatal(err)
+		}
+		input := fake.calls[0].input.(*dynamodb.UpdateItemInput)
+		if got := resolveExpression(input.UpdateExpression, input.ExpressionAttributeNames); got != "SET Events = list_append(if_not_exists(Events, :0), :1)" {
+			t.Errorf("AppendToList失败: 不是预期的表达式 %s", got)
+		}
+	})
+
+	t.Run("Trim-Retry", func(t *testing.T) {
+		rs, fake := newFakeService(scriptedDynamo(
+			updated(listAttr("Events", 4)),
+			conditionFailed,
+			func(interface{}) (interface{}, error) {
+				return &dynamodb.GetItemOutput{Item: listAttr("Events", 5)}, nil
+			},
+			updated(listAttr("Events", 2)),
+		))
+		var events []string
+		if err := rs.AppendToList(ctx, key, "Events", []string{"x"}, 2, &events); err != nil {
+			t.Fatal(err)
+		}
+		expect := []string{"UpdateItem", "UpdateItem", "GetItem", "UpdateItem"}
+		if ops := fake.ops(); !reflect.DeepEqual(ops, expect) {
+			t.Fatalf("AppendToList失败: 不是预期的请求 %v", ops)
+		}
+		for i, want := range map[int]string{1: "REMOVE Events[0], Events[1]", 3: "REMOVE Events[0], Events[1], Events[2]"} {
+			input := fake.calls[i].input.(*dynamodb.UpdateItemInput)
+			if got := resolveExpression(input.UpdateExpression, input.ExpressionAttributeNames); got != want {
+				t.Errorf("AppendToList失败: 不是预期的裁剪表达式 %s", got)
+			}
+			if cond := resolveExpression(input.ConditionExpression, input.ExpressionAttributeNames); !strings.HasPrefix(cond, "size (Events) = :") {
+				t.Errorf("AppendToList失败: 裁剪应该以长度为条件 %s", cond)
+			}
+		}
+		if !reflect.DeepEqual(events, []string{"a", "b"}) {
+			t.Errorf("AppendToList失败: 不是预期的 list %v", events)
+		}
+	})
+
+	t.Run("Trim-Exhausted", func(t *testing.T) {
+		get := func(interface{}) (interface{}, error) {
+			return &dynamodb.GetItemOutput{Item: listAttr("Events", 4)}, nil
+		}
+		rs, _ := newFakeService(scriptedDynamo(
+			updated(listAttr("Events", 4)),
+			conditionFailed, get, conditionFailed, get, conditionFailed, get,
+		))
+		if err := rs.AppendToList(ctx, key, "Events", []string{"x"}, 2, nil); !errors.Is(err, ErrConditionalCheck) {
+			t.Errorf("AppendToList失败: 重试耗尽应该返回 ErrConditionalCheck %v", err)
+		}
+	})
+}
@@ -89,6 +89,25 @@ func TestOp(t *testing.T) {
 			t.Error("PutIfNotExist不应该成功")
 			return
 		})
+		t.Run("Put-ConditionReturnOld", func(t *testing.T) {
+			err := rs.Put(context.TODO(), newTestSchema("id1", "v2"),
+				rotor.PutCondition(rotor.ConditionItemNotExist()),
+				rotor.PutConditionReturnOld())
+			var condErr *rotor.ConditionalCheckError
+			if !errors.As(err, &condErr) || !errors.Is(err, rotor.ErrConditionalCheck) {
+				t.Errorf("PutConditionReturnOld失败: %v", err)
+				return
+			}
+			var out TestSchema
+			if err := condErr.Decode(&out); err != nil {
+				t.Errorf("ConditionalCheckError Decode失败: %v", err)
+				return
+			}
+			if out.TestV != "v1" {
+				t.Error("PutConditionReturnOld失败: 不是预期的值")
+				return
+			}
+		})
 		t.Run("Put-Condition", func(t *testing.T) {
 			// 只有存在才会写入
 			err := rs.Put(context.TODO(), newTestSchema("id1", "v1"),
@@ -173,6 +192,62 @@ func TestOp(t *testing.T) {
 			}
 			t.Logf("UpdateBatch One: %v", outs[0])
 		})
+		t.Run("Update-Both", func(t *testing.T) {
+			var oldOut, newOut TestSchema
+			update := expression.Set(expression.Name("TestV"), expression.Value("v4"))
+			err := rs.UpdateBoth(context.TODO(), rotor.PrimaryKey(pKPrefix+"id1", sk), update, &oldOut, &newOut)
+			if err != nil {
+				t.Errorf("UpdateBoth失败: %v", err)
+				return
+			}
+			if oldOut.TestV != "v3" || newOut.TestV != "v4" {
+				t.Error("UpdateBoth失败: 不是预期的值")
+				return
+			}
+		})
+		t.Run("Update-Mutate", func(t *testing.T) {
+			var out TestSchema
+			err := rs.Mutate(context.TODO(), rotor.PrimaryKey(pKPrefix+"id1", sk), &out, func() error {
+				out.TestV = "v3"
+				return nil
+			})
+			if err != nil {
+				t.Errorf("Mutate失败: %v", err)
+				return
+			}
+			t.Logf("Mutate: %v", out)
+		})
+		t.Run("Update-Increment", func(t *testing.T) {
+			key := rotor.PrimaryKey(pKPrefix+"id1", sk)
+			n, err := rs.Increment(context.TODO(), key, "Stock", 1, rotor.IncrementFloor(0))
+			if err != nil {
+				t.Errorf("Increment失败: %v", err)
+				return
+			}
+			_, err = rs.Increment(context.TODO(), key, "Stock", -(n + 1), rotor.IncrementFloor(0))
+			if !errors.Is(err, rotor.ErrCounterBound) {
+				t.Errorf("Increment应该超出下限: %v", err)
+				return
+			}
+		})
+	})
+	t.Run("Query", func(t *testing.T) {
+		t.Run("Query-MaxItems", func(t *testing.T) {
+			var outs []TestSchema
+			keyCond := expression.Key("PK").Equal(expression.Value(pKPrefix + "id1"))
+			cursor, err := rs.QueryPage(context.TODO(), keyCond, &outs,
+				rotor.QueryFilter(expression.Equal(expression.Name("TestV"), expression.Value("v3"))),
+				rotor.QueryMaxItems(1))
+			if err != nil {
+				t.Errorf("QueryPage失败: %v", err)
+				return
+			}
+			if len(outs) != 1 {
+				t.Error("QueryPage失败: 不是预期的长度")
+				return
+			}
+			t.Logf("QueryPage: %v, cursor: %v", outs[0], cursor)
+		})
 	})
 	t.Run("Delete", func(t *testing.T) {
 		t.Run("Delete-OK", func(t *testing.T) {
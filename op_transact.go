@@ -2,7 +2,9 @@ package rotor
 
 import (
 	"context"
+	"reflect"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
@@ -17,8 +19,10 @@ type TransactUpdateItem struct {
 }
 
 // TransactDeleteItem TransactDeleteItem
+// Key 为空时使用 Item 的主键, 并调用 Item 的 BeforeDelete/AfterWrite
 type TransactDeleteItem struct {
 	Key     PrimaryKeyType
+	Item    interface{}
 	Builder *expression.Builder
 }
 
@@ -81,6 +85,15 @@ func (rs *Service) Transact(ctx context.Context, opts ...TransactOption) error {
 			},
 		}
 	}
+	written := make([]interface{}, 0, len(options.PutItems)+len(options.DeleteItems))
+	// 写入没有成功时删除已经转存的对象
+	var offloaded []map[string]*dynamodb.AttributeValue
+	defer func() {
+		rs.discardBlobs(ctx, offloaded...)
+	}()
+	// 事务拿不到旧 item, 写入前读取被覆盖和删除的 item, 成功后清理不再引用的对象
+	var ownerKeys []PrimaryKeyType
+	var ownerItems []map[string]*dynamodb.AttributeValue
 	for i, put := range options.PutItems {
 		var expr expression.Expression
 		var err error
@@ -90,10 +103,24 @@ func (rs *Service) Transact(ctx context.Context, opts ...TransactOption) error {
 				return err
 			}
 		}
-		item, err := rs.codec.MarshalMap(put.Item)
+		in, err := rs.beforePut(ctx, put.Item)
+		if err != nil {
+			return err
+		}
+		written = append(written, in)
+		item, err := rs.marshalItem(ctx, in)
 		if err != nil {
 			return err
 		}
+		offloaded = append(offloaded, item)
+		if rs.blobStore != nil && len(offloadFields(reflect.TypeOf(in))) > 0 {
+			key, err := itemKey(item, []string{tablePK, tableSK})
+			if err != nil {
+				return err
+			}
+			ownerKeys = append(ownerKeys, key)
+			ownerItems = append(ownerItems, item)
+		}
 		inItems[i] = &dynamodb.TransactWriteItem{
 			Put: &dynamodb.Put{
 				TableName:                 rs.tableName,
@@ -113,18 +140,34 @@ func (rs *Service) Transact(ctx context.Context, opts ...TransactOption) error {
 				return err
 			}
 		}
+		key := delete.Key
+		if key == nil && delete.Item != nil {
+			var in interface{}
+			if in, key, err = rs.beforeDelete(ctx, delete.Item); err != nil {
+				return err
+			}
+			written = append(written, in)
+		}
+		if rs.blobStore != nil {
+			ownerKeys = append(ownerKeys, key)
+			ownerItems = append(ownerItems, nil)
+		}
 		inItems[i] = &dynamodb.TransactWriteItem{
 			Delete: &dynamodb.Delete{
 				TableName:                 rs.tableName,
-				Key:                       delete.Key,
+				Key:                       key,
 				ConditionExpression:       expr.Condition(),
 				ExpressionAttributeNames:  expr.Names(),
 				ExpressionAttributeValues: expr.Values(),
 			},
 		}
 	}
+	old, err := rs.currentItems(ctx, ownerKeys...)
+	if err != nil {
+		return err
+	}
 
-	_, err := rs.dynamo.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+	_, err = rs.dynamo.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
 		TransactItems: inItems,
 	})
 	if err != nil {
@@ -138,5 +181,33 @@ func (rs *Service) Transact(ctx context.Context, opts ...TransactOption) error {
 		}
 		return err
 	}
+	offloaded = nil
+	for i, item := range old {
+		rs.cleanupBlobs(ctx, item, ownerItems[i])
+	}
+	return rs.afterWriteAll(ctx, written)
+}
+
+// transactOne 以单个请求的事务写入, 条件检查失败时返回带当前 item 的 ConditionalCheckError
+// 单条写入的 API 不支持 ReturnValuesOnConditionCheckFailure, 代价是消耗事务写的容量
+func (rs *Service) transactOne(ctx context.Context, item *dynamodb.TransactWriteItem) error {
+	returnOld := aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld)
+	switch {
+	case item.Put != nil:
+		item.Put.ReturnValuesOnConditionCheckFailure = returnOld
+	case item.Update != nil:
+		item.Update.ReturnValuesOnConditionCheckFailure = returnOld
+	case item.Delete != nil:
+		item.Delete.ReturnValuesOnConditionCheckFailure = returnOld
+	}
+	_, err := rs.dynamo.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{item},
+	})
+	if err != nil {
+		if reason, ok := conditionalCheckFailed(err, 0); ok {
+			return &ConditionalCheckError{Item: reason.Item, rs: rs}
+		}
+		return err
+	}
 	return nil
 }
@@ -2,6 +2,7 @@ package rotor
 
 import (
 	"context"
+	"errors"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -20,9 +21,11 @@ const (
 
 // UpdateOptions UpdateOptions
 type UpdateOptions struct {
-	builder *expression.Builder
+	builder   *expression.Builder
+	condition *expression.ConditionBuilder
 
 	returnValue *string
+	returnOld   bool
 }
 
 func defaultUpdateOptions() *UpdateOptions {
@@ -33,14 +36,30 @@ func defaultUpdateOptions() *UpdateOptions {
 type UpdateOption func(options *UpdateOptions)
 
 // UpdateCondition UpdateCondition
+// 多次使用时后面的条件覆盖前面的, 需要同时满足时使用 UpdateConditionAnd
 func UpdateCondition(condition expression.ConditionBuilder) UpdateOption {
 	return func(options *UpdateOptions) {
-		if options.builder == nil {
-			builder := expression.NewBuilder()
-			options.builder = &builder
+		options.setCondition(condition)
+	}
+}
+
+// UpdateConditionAnd 与已经设置的条件为 AND 关系, 没有设置条件时与 UpdateCondition 相同
+func UpdateConditionAnd(condition expression.ConditionBuilder) UpdateOption {
+	return func(options *UpdateOptions) {
+		if options.condition != nil {
+			condition = options.condition.And(condition)
 		}
-		*options.builder = options.builder.WithCondition(condition)
+		options.setCondition(condition)
+	}
+}
+
+func (options *UpdateOptions) setCondition(condition expression.ConditionBuilder) {
+	if options.builder == nil {
+		builder := expression.NewBuilder()
+		options.builder = &builder
 	}
+	options.condition = &condition
+	*options.builder = options.builder.WithCondition(condition)
 }
 
 // UpdateReturnValue UpdateReturnValue
@@ -50,6 +69,14 @@ func UpdateReturnValue(v string) UpdateOption {
 	}
 }
 
+// UpdateConditionReturnOld 条件检查失败时返回带当前 item 的 ConditionalCheckError
+// 请求会以单条事务发出, 消耗双倍的写容量, 不能用于 UpdateOut
+func UpdateConditionReturnOld() UpdateOption {
+	return func(options *UpdateOptions) {
+		options.returnOld = true
+	}
+}
+
 // UpdateOut update item
 func (rs *Service) UpdateOut(ctx context.Context, key PrimaryKeyType, update expression.UpdateBuilder, out interface{}, opts ...UpdateOption) error {
 	options := defaultUpdateOptions()
@@ -57,7 +84,7 @@ func (rs *Service) UpdateOut(ctx context.Context, key PrimaryKeyType, update exp
 	for _, opt := range opts {
 		opt(options)
 	}
-	if aws.StringValue(options.returnValue) == UpdateReturnValueNone {
+	if aws.StringValue(options.returnValue) == UpdateReturnValueNone || options.returnOld {
 		return ErrReturnValue
 	}
 	var expr expression.Expression
@@ -90,19 +117,24 @@ func (rs *Service) UpdateOut(ctx context.Context, key PrimaryKeyType, update exp
 		}
 		return err
 	}
-	return rs.codec.UnmarshalMap(ret.Attributes, out)
+	return rs.decodeItem(ctx, ret.Attributes, out, decodeOptions{})
 }
 
 // Update update item
 func (rs *Service) Update(ctx context.Context, key PrimaryKeyType, update expression.UpdateBuilder, opts ...UpdateOption) error {
+	_, err := rs.updateRaw(ctx, key, update, UpdateReturnValueNone, false, opts...)
+	return err
+}
+
+// UpdateBatch update items
+func (rs *Service) UpdateBatch(ctx context.Context, keys []PrimaryKeyType, update expression.UpdateBuilder, opts ...UpdateOption) error {
+	if len(keys) == 0 || len(keys) > maxWriteNum {
+		return ErrInput
+	}
 	options := defaultUpdateOptions()
-	options.returnValue = aws.String(UpdateReturnValueNone)
 	for _, opt := range opts {
 		opt(options)
 	}
-	if aws.StringValue(options.returnValue) != UpdateReturnValueNone {
-		return ErrReturnValue
-	}
 	var expr expression.Expression
 	var err error
 	if options.builder == nil {
@@ -113,14 +145,21 @@ func (rs *Service) Update(ctx context.Context, key PrimaryKeyType, update expres
 	if err != nil {
 		return err
 	}
-	_, err = rs.dynamo.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
-		TableName:                 rs.tableName,
-		Key:                       key,
-		UpdateExpression:          expr.Update(),
-		ConditionExpression:       expr.Condition(),
-		ExpressionAttributeNames:  expr.Names(),
-		ExpressionAttributeValues: expr.Values(),
-		ReturnValues:              options.returnValue,
+	inItems := make([]*dynamodb.TransactWriteItem, len(keys))
+	for i, key := range keys {
+		inItems[i] = &dynamodb.TransactWriteItem{
+			Update: &dynamodb.Update{
+				TableName:                 rs.tableName,
+				Key:                       key,
+				UpdateExpression:          expr.Update(),
+				ConditionExpression:       expr.Condition(),
+				ExpressionAttributeNames:  expr.Names(),
+				ExpressionAttributeValues: expr.Values(),
+			},
+		}
+	}
+	_, err = rs.dynamo.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: inItems,
 	})
 	if err != nil {
 		if aerr, ok := err.(awserr.Error); ok {
@@ -136,28 +175,37 @@ func (rs *Service) Update(ctx context.Context, key PrimaryKeyType, update expres
 	return nil
 }
 
-// UpdateBatch update items
-func (rs *Service) UpdateBatch(ctx context.Context, keys []PrimaryKeyType, update expression.UpdateBuilder, opts ...UpdateOption) error {
-	if len(keys) == 0 || len(keys) > maxWriteNum {
-		return ErrInput
-	}
+// updateRaw 以 returnValue 执行 Update 并返回 item 的属性, 调用方不能设置 UpdateReturnValue
+// UpdateConditionReturnOld 时以单条事务写入, 拿不到属性: required 为 true 时返回 ErrReturnValue,
+// 否则 returnValue 不是 None 时返回写入前一致性读取的整个 item, 调用方需要自己挑出被更新的属性
+func (rs *Service) updateRaw(ctx context.Context, key PrimaryKeyType, update expression.UpdateBuilder, returnValue string, required bool, opts ...UpdateOption) (map[string]*dynamodb.AttributeValue, error) {
 	options := defaultUpdateOptions()
+	options.returnValue = aws.String(UpdateReturnValueNone)
 	for _, opt := range opts {
 		opt(options)
 	}
-	var expr expression.Expression
-	var err error
+	if aws.StringValue(options.returnValue) != UpdateReturnValueNone {
+		return nil, ErrReturnValue
+	}
 	if options.builder == nil {
 		builder := expression.NewBuilder()
 		options.builder = &builder
 	}
-	expr, err = options.builder.WithUpdate(update).Build()
+	expr, err := options.builder.WithUpdate(update).Build()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	inItems := make([]*dynamodb.TransactWriteItem, len(keys))
-	for i, key := range keys {
-		inItems[i] = &dynamodb.TransactWriteItem{
+	if options.returnOld {
+		if required && returnValue != UpdateReturnValueNone {
+			return nil, ErrReturnValue
+		}
+		var old map[string]*dynamodb.AttributeValue
+		if returnValue != UpdateReturnValueNone {
+			if old, err = rs.getRaw(ctx, key, GetConsistent(true)); err != nil && !errors.Is(err, ErrItemNotFound) {
+				return nil, err
+			}
+		}
+		err := rs.transactOne(ctx, &dynamodb.TransactWriteItem{
 			Update: &dynamodb.Update{
 				TableName:                 rs.tableName,
 				Key:                       key,
@@ -166,21 +214,80 @@ func (rs *Service) UpdateBatch(ctx context.Context, keys []PrimaryKeyType, updat
 				ExpressionAttributeNames:  expr.Names(),
 				ExpressionAttributeValues: expr.Values(),
 			},
+		})
+		if err != nil {
+			return nil, err
 		}
+		return old, nil
 	}
-	_, err = rs.dynamo.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
-		TransactItems: inItems,
+	ret, err := rs.dynamo.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 rs.tableName,
+		Key:                       key,
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ReturnValues:              aws.String(returnValue),
 	})
 	if err != nil {
 		if aerr, ok := err.(awserr.Error); ok {
 			switch aerr.Code() {
 			case dynamodb.ErrCodeConditionalCheckFailedException:
-				return ErrConditionalCheck
+				return nil, ErrConditionalCheck
 			default:
-				return err
+				return nil, err
 			}
 		}
+		return nil, err
+	}
+	return ret.Attributes, nil
+}
+
+// UpdateBoth update item and return both the old and new images
+// 先一致性读取当前 item, 再以当前 item 未被修改为条件执行更新并返回 ALL_NEW, 两个镜像对应同一次原子更新;
+// 期间有其他写入时会重新读取并重试, 重新读取到相同 item 说明是 UpdateCondition 不满足, 返回 ErrConditionalCheck.
+// item 不存在时 oldOut 保持不变; item 太大时以 Version 属性为条件, 没有 Version 属性时返回 ErrInput
+func (rs *Service) UpdateBoth(ctx context.Context, key PrimaryKeyType, update expression.UpdateBuilder, oldOut, newOut interface{}, opts ...UpdateOption) error {
+	old, err := rs.getRaw(ctx, key, GetConsistent(true))
+	if err != nil && !errors.Is(err, ErrItemNotFound) {
 		return err
 	}
-	return nil
+	for attempt := 0; ; attempt++ {
+		guard := ConditionItemNotExist()
+		if old != nil {
+			if guard, err = stateCondition(old, true); err != nil {
+				return err
+			}
+		}
+		attrs, err := rs.updateRaw(ctx, key, update, UpdateReturnValueAllNew, true, append(append([]UpdateOption{}, opts...), UpdateConditionAnd(guard))...)
+		if err == nil {
+			if old != nil {
+				if err := rs.decodeItem(ctx, old, oldOut, decodeOptions{}); err != nil {
+					return err
+				}
+			}
+			return rs.decodeItem(ctx, attrs, newOut, decodeOptions{})
+		}
+		if !errors.Is(err, ErrConditionalCheck) {
+			return err
+		}
+		current, err := rs.getRaw(ctx, key, GetConsistent(true))
+		if err != nil && !errors.Is(err, ErrItemNotFound) {
+			return err
+		}
+		if itemEqual(old, current) || attempt >= defaultMutateRetries {
+			return ErrConditionalCheck
+		}
+		old = current
+		if err := sleepBackoff(ctx, defaultMutateBackoff, attempt); err != nil {
+			return err
+		}
+	}
+}
+
+func itemEqual(a, b map[string]*dynamodb.AttributeValue) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return attributeValueEqual(&dynamodb.AttributeValue{M: a}, &dynamodb.AttributeValue{M: b})
 }
@@ -0,0 +1,105 @@
+package rotor
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+func TestUpdateCondition(t *testing.T) {
+	build := func(opts ...UpdateOption) string {
+		options := defaultUpdateOptions()
+		for _, opt := range opts {
+			opt(options)
+		}
+		expr, err := options.builder.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resolveExpression(expr.Condition(), expr.Names())
+	}
+	a := expression.Name("A").Equal(expression.Value(1))
+	b := expression.Name("B").Equal(expression.Value(2))
+	if cond := build(UpdateCondition(a), UpdateCondition(b)); strings.Contains(cond, "A") || !strings.Contains(cond, "B") {
+		t.Errorf("UpdateCondition失败: 后面的条件应该覆盖前面的 %s", cond)
+	}
+	if cond := build(UpdateCondition(a), UpdateConditionAnd(b)); !strings.Contains(cond, "A") || !strings.Contains(cond, "AND") {
+		t.Errorf("UpdateConditionAnd失败: 条件之间应该是 AND 关系 %s", cond)
+	}
+}
+
+func TestUpdateRawReturnValue(t *testing.T) {
+	current := map[string]*dynamodb.AttributeValue{"A": {N: aws.String("0")}}
+	rs, fake := newFakeService(func(op string, input interface{}) (interface{}, error) {
+		if op == "GetItem" {
+			return &dynamodb.GetItemOutput{Item: current}, nil
+		}
+		return &dynamodb.TransactWriteItemsOutput{}, nil
+	})
+	ctx := context.TODO()
+	key := PrimaryKey("Item#1", "Item")
+	update := expression.Set(expression.Name("A"), expression.Value(1))
+
+	if err := rs.Update(ctx, key, update, UpdateReturnValue(UpdateReturnValueAllNew)); !errors.Is(err, ErrReturnValue) {
+		t.Errorf("Update失败: 不能设置返回值 %v", err)
+	}
+	if _, err := rs.updateRaw(ctx, key, update, UpdateReturnValueAllNew, true, UpdateConditionReturnOld()); !errors.Is(err, ErrReturnValue) {
+		t.Errorf("updateRaw失败: 需要返回值时不能使用事务 %v", err)
+	}
+	attrs, err := rs.updateRaw(ctx, key, update, UpdateReturnValueNone, false, UpdateConditionReturnOld())
+	if err != nil || attrs != nil {
+		t.Errorf("updateRaw失败: 不需要返回值时应该以事务写入 %v %v", attrs, err)
+	}
+	// 不是必需的返回值在写入前一致性读取
+	attrs, err = rs.updateRaw(ctx, key, update, UpdateReturnValueUpdatedOld, false, UpdateConditionReturnOld())
+	if err != nil || !itemEqual(attrs, current) {
+		t.Errorf("updateRaw失败: 应该返回写入前的 item %v %v", attrs, err)
+	}
+	if ops := fake.ops(); !reflect.DeepEqual(ops, []string{"TransactWriteItems", "GetItem", "TransactWriteItems"}) {
+		t.Errorf("updateRaw失败: 不是预期的请求 %v", ops)
+	}
+}
+
+func TestUpdateEncryptedKey(t *testing.T) {
+	type secret struct {
+		BaseSchema
+		Phone string `rotor:"encrypt"`
+	}
+	provider, err := NewStaticKeyProvider(StaticKey{ID: "k1", Secret: []byte("0123456789abcdef")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec := NewCodec(CodecKeyProvider(provider))
+	rs, fake := newFakeService(func(op string, input interface{}) (interface{}, error) {
+		return &dynamodb.UpdateItemOutput{}, nil
+	}, ServiceCodec(codec))
+	ctx := context.TODO()
+	key := PrimaryKey("Test#id1", "Test")
+	// 写入的密文绑定 key, 移动到其他 item 时不能解密
+	check := func(name string) {
+		input := fake.calls[len(fake.calls)-1].input.(*dynamodb.UpdateItemInput)
+		item := map[string]*dynamodb.AttributeValue{tablePK: key[tablePK], tableSK: key[tableSK], "Phone": input.ExpressionAttributeValues[":0"]}
+		var out secret
+		if err := codec.UnmarshalMap(item, &out); err != nil || out.Phone != "2" {
+			t.Errorf("%s失败: 密文应该绑定主键 %v %v", name, out.Phone, err)
+		}
+		item[tablePK] = &dynamodb.AttributeValue{S: aws.String("Test#id2")}
+		if err := codec.UnmarshalMap(item, &out); !errors.Is(err, ErrEncryption) {
+			t.Errorf("%s失败: 其他主键不能解密 %v", name, err)
+		}
+	}
+	if err := rs.Patch(ctx, key, &secret{Phone: "2"}); err != nil {
+		t.Fatal(err)
+	}
+	check("Patch")
+	if err := rs.UpdateDiff(ctx, key, &secret{Phone: "1"}, &secret{Phone: "2"}); err != nil {
+		t.Fatal(err)
+	}
+	check("UpdateDiff")
+}
@@ -0,0 +1,107 @@
+package rotor
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// Upsert 将 item 转换为 update expression
+// 与 Put 不同, item 中没有的属性不会被删除; 带 `rotor:"insertonly"` 的字段只在属性不存在时写入
+// 除主键外没有其他属性时 changed 为 false
+func (codec Codec) Upsert(in interface{}) (key PrimaryKeyType, update expression.UpdateBuilder, changed bool, err error) {
+	t := indirectType(reflect.TypeOf(in))
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, update, false, ErrInput
+	}
+	item, err := codec.MarshalMap(in)
+	if err != nil {
+		return nil, update, false, err
+	}
+	return upsertItem(t, item, "")
+}
+
+// upsertItem 将类型 t 编码后的 item 转换为 update expression, 属性 ignore 不计入 changed
+func upsertItem(t reflect.Type, item map[string]*dynamodb.AttributeValue, ignore string) (key PrimaryKeyType, update expression.UpdateBuilder, changed bool, err error) {
+	key, err = itemKey(item, []string{tablePK, tableSK})
+	if err != nil {
+		return nil, update, false, err
+	}
+	insertOnly := map[string]bool{}
+	for _, f := range structFields(t) {
+		if f.Rotor.Has("insertonly") {
+			insertOnly[f.Name] = true
+		}
+	}
+	for _, name := range attributeNames(item) {
+		if name == tablePK || name == tableSK {
+			continue
+		}
+		operand := expression.Name(name)
+		value := expression.Value(item[name])
+		if insertOnly[name] {
+			update = update.Set(operand, expression.IfNotExists(operand, value))
+		} else {
+			update = update.Set(operand, value)
+		}
+		if name != ignore {
+			changed = true
+		}
+	}
+	return key, update, changed, nil
+}
+
+// Upsert 创建或更新 item
+// 只覆盖 item 中编码出来的属性, 其他服务写入的属性会被保留, 可以配合 UpdateCondition 使用
+// 与 Put 一样转存大对象, 记录 schema 版本并检查大小, 转存的字段不能同时是 insertonly
+// UpdateItem 不能只写主键, item 只有主键时返回 ErrInput
+func (rs *Service) Upsert(ctx context.Context, in interface{}, opts ...UpdateOption) error {
+	in, err := rs.beforePut(ctx, in)
+	if err != nil {
+		return err
+	}
+	t := indirectType(reflect.TypeOf(in))
+	if t == nil || t.Kind() != reflect.Struct {
+		return ErrInput
+	}
+	offload := rs.blobStore != nil && len(offloadFields(t)) > 0
+	if offload {
+		// insertonly 的字段可能没有写入, 新转存的对象和旧对象都无法判断是否还被引用
+		for _, f := range offloadFields(t) {
+			if f.Rotor.Has("insertonly") {
+				return ErrInput
+			}
+		}
+	}
+	item, err := rs.marshalItem(ctx, in)
+	if err != nil {
+		return err
+	}
+	// schema 版本不算作 item 的属性
+	var schemaAttr string
+	if rs.schemas != nil {
+		schemaAttr = rs.schemas.attr
+	}
+	key, update, changed, err := upsertItem(t, item, schemaAttr)
+	if err != nil || !changed {
+		rs.discardBlobs(ctx, item)
+		if err != nil {
+			return err
+		}
+		return ErrInput
+	}
+	// 覆盖时需要被更新属性的旧值来清理不再引用的对象
+	returnValue := UpdateReturnValueNone
+	if offload {
+		returnValue = UpdateReturnValueUpdatedOld
+	}
+	old, err := rs.updateRaw(ctx, key, update, returnValue, false, opts...)
+	if err != nil {
+		rs.discardBlobs(ctx, item)
+		return err
+	}
+	rs.cleanupBlobs(ctx, pickAttributes(old, attributeNames(item)), item)
+	return rs.afterWrite(ctx, in)
+}
@@ -0,0 +1,37 @@
+package rotor_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/lixw1994/rotor"
+)
+
+func TestUpsert(t *testing.T) {
+	codec := rotor.NewCodec()
+	key, update, changed, err := codec.Upsert(newTestSchema("id1", "v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed || aws.StringValue(key["PK"].S) != pKPrefix+"id1" {
+		t.Fatalf("Upsert失败: 不是预期的主键 %v", key)
+	}
+	expr, err := expression.NewBuilder().WithUpdate(update).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var createTime string
+	for alias, name := range expr.Names() {
+		switch aws.StringValue(name) {
+		case "PK", "SK":
+			t.Errorf("Upsert失败: 不应该更新主键")
+		case "CreateTime":
+			createTime = alias
+		}
+	}
+	if createTime == "" || !strings.Contains(aws.StringValue(expr.Update()), "if_not_exists("+createTime) {
+		t.Errorf("Upsert失败: CreateTime 应该只在创建时写入, %s", aws.StringValue(expr.Update()))
+	}
+}
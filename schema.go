@@ -0,0 +1,210 @@
+package rotor
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+const (
+	defaultSchemaVersionAttr = "SchemaVersion"
+
+	writeBackQueueSize = 1024
+	writeBackTimeout   = 10 * time.Second
+)
+
+// Migration 把上一个版本的 item 升级到下一个版本
+// item 是存储形式, 加密和转存的属性保持原样, 可以直接修改并返回 item
+type Migration func(item map[string]*dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, error)
+
+// SchemaRegistry 类型的 schema 版本和升级函数
+// 写入时 Service 在 item 中记录当前版本, 读取时先把旧版本的 item 逐级升级再解码
+type SchemaRegistry struct {
+	attr  string
+	mu    sync.RWMutex
+	types map[reflect.Type][]Migration
+}
+
+// NewSchemaRegistry attr 为保存版本号的属性名, 为空时使用 SchemaVersion
+func NewSchemaRegistry(attr string) *SchemaRegistry {
+	if attr == "" {
+		attr = defaultSchemaVersionAttr
+	}
+	return &SchemaRegistry{attr: attr, types: map[reflect.Type][]Migration{}}
+}
+
+// Register 注册 sample 的类型, 第 i 个 Migration 把版本 i 升级到 i+1, 当前版本为 len(migrations)
+// 没有版本属性的 item 视为版本 0
+func (r *SchemaRegistry) Register(sample interface{}, migrations ...Migration) error {
+	t := indirectType(reflect.TypeOf(sample))
+	if t == nil || t.Kind() != reflect.Struct {
+		return ErrInput
+	}
+	for _, m := range migrations {
+		if m == nil {
+			return ErrInput
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.types[t] = append([]Migration{}, migrations...)
+	return nil
+}
+
+func (r *SchemaRegistry) lookup(t reflect.Type) ([]Migration, bool) {
+	t = indirectType(t)
+	if r == nil || t == nil {
+		return nil, false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	migrations, ok := r.types[t]
+	return migrations, ok
+}
+
+// stamp 写入时记录当前版本
+func (r *SchemaRegistry) stamp(t reflect.Type, item map[string]*dynamodb.AttributeValue) {
+	migrations, ok := r.lookup(t)
+	if !ok {
+		return
+	}
+	item[r.attr] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(len(migrations)))}
+}
+
+// itemVersion item 的版本, 没有版本属性时为 0
+func (r *SchemaRegistry) itemVersion(item map[string]*dynamodb.AttributeValue) (int, error) {
+	av, ok := item[r.attr]
+	if !ok || av.NULL != nil {
+		return 0, nil
+	}
+	if av.N == nil {
+		return 0, fmt.Errorf("rotor: schema version attribute %s is not a number", r.attr)
+	}
+	return strconv.Atoi(*av.N)
+}
+
+// upgrade 把 item 升级到类型 t 的当前版本, 返回升级前的版本和是否升级
+// 比当前版本新的 item 原样返回, 滚动发布期间旧代码仍然可以读取
+func (r *SchemaRegistry) upgrade(t reflect.Type, item map[string]*dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, int, bool, error) {
+	migrations, ok := r.lookup(t)
+	if !ok {
+		return item, 0, false, nil
+	}
+	version, err := r.itemVersion(item)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if version >= len(migrations) {
+		return item, version, false, nil
+	}
+	upgraded := make(map[string]*dynamodb.AttributeValue, len(item))
+	for k, v := range item {
+		upgraded[k] = v
+	}
+	for v := version; v < len(migrations); v++ {
+		if upgraded, err = migrations[v](upgraded); err != nil {
+			return nil, 0, false, fmt.Errorf("rotor: migrate %s from version %d: %w", t, v, err)
+		}
+	}
+	r.stamp(t, upgraded)
+	return upgraded, version, true, nil
+}
+
+// ServiceSchemaRegistry 写入时记录 schema 版本, 读取时升级旧版本的 item
+func ServiceSchemaRegistry(registry *SchemaRegistry) ServiceOption {
+	return func(rs *Service) {
+		rs.schemas = registry
+	}
+}
+
+// ServiceSchemaWriteBack Get/GetBatch/Query 读到旧版本的完整 item 时把升级后的 item 写回
+// 写回在后台 goroutine 中依次进行, 不增加读取的延迟; 以读到的 item 没有变化为条件,
+// 队列满或者写回失败时放弃, item 下次读取时会再次升级
+func ServiceSchemaWriteBack() ServiceOption {
+	return func(rs *Service) {
+		rs.schemaWriteBack = &writeBackQueue{items: make(chan writeBackItem, writeBackQueueSize)}
+	}
+}
+
+// writeBackQueue 等待写回的 item, 第一次使用时启动后台 goroutine
+type writeBackQueue struct {
+	once  sync.Once
+	items chan writeBackItem
+}
+
+type writeBackItem struct {
+	raw, upgraded map[string]*dynamodb.AttributeValue
+}
+
+// enqueueWriteBack 不阻塞, 队列满时丢弃
+func (rs *Service) enqueueWriteBack(raw, upgraded map[string]*dynamodb.AttributeValue) {
+	q := rs.schemaWriteBack
+	q.once.Do(func() {
+		go func() {
+			for it := range q.items {
+				ctx, cancel := context.WithTimeout(context.Background(), writeBackTimeout)
+				rs.writeBack(ctx, it.raw, it.upgraded)
+				cancel()
+			}
+		}()
+	})
+	select {
+	case q.items <- writeBackItem{raw: raw, upgraded: upgraded}:
+	default:
+	}
+}
+
+// upgradeItem 升级读取到的 item, 需要时写回
+func (rs *Service) upgradeItem(ctx context.Context, t reflect.Type, item map[string]*dynamodb.AttributeValue, dopts decodeOptions) (map[string]*dynamodb.AttributeValue, error) {
+	if rs.schemas == nil {
+		return item, nil
+	}
+	upgraded, _, ok, err := rs.schemas.upgrade(t, item)
+	if err != nil || !ok {
+		return upgraded, err
+	}
+	if rs.schemaWriteBack != nil && dopts.fullItem {
+		rs.enqueueWriteBack(item, upgraded)
+	}
+	return upgraded, nil
+}
+
+// writeBack 以读到的 raw 没有变化为条件写回升级后的 item
+// 只比较版本号会覆盖期间其他不修改版本号的写入, 例如 Update
+func (rs *Service) writeBack(ctx context.Context, raw, item map[string]*dynamodb.AttributeValue) {
+	if checkItemSize(item) != nil {
+		return
+	}
+	// 太大且没有 Version 属性的 item 不写回, 只比较 schema 版本会覆盖其他写入
+	cond, err := stateCondition(raw, true)
+	if err != nil {
+		return
+	}
+	expr, err := expression.NewBuilder().WithCondition(cond).Build()
+	if err != nil {
+		return
+	}
+	_, _ = rs.dynamo.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:                 rs.tableName,
+		Item:                      item,
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+}
+
+// elemType out 指向的 slice 的元素类型
+func elemType(out interface{}) reflect.Type {
+	t := indirectType(reflect.TypeOf(out))
+	if t == nil || (t.Kind() != reflect.Slice && t.Kind() != reflect.Array) {
+		return nil
+	}
+	return t.Elem()
+}
@@ -0,0 +1,122 @@
+package rotor
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+type schemaTestUser struct {
+	BaseSchema
+
+	FullName string
+	Email    string
+}
+
+func TestSchemaUpgrade(t *testing.T) {
+	registry := NewSchemaRegistry("")
+	err := registry.Register(schemaTestUser{},
+		// v0 -> v1: Name 改名为 FullName
+		func(item map[string]*dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, error) {
+			item["FullName"] = item["Name"]
+			delete(item, "Name")
+			return item, nil
+		},
+		// v1 -> v2: 新增 Email
+		func(item map[string]*dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, error) {
+			item["Email"] = &dynamodb.AttributeValue{S: aws.String("unknown")}
+			return item, nil
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := &Service{codec: NewCodec(), tableName: aws.String("test"), schemas: registry}
+	ctx := context.TODO()
+
+	old := map[string]*dynamodb.AttributeValue{
+		"PK":   {S: aws.String("User#1")},
+		"SK":   {S: aws.String("User")},
+		"Name": {S: aws.String("n")},
+	}
+	var users []schemaTestUser
+	if err := rs.decodeItems(ctx, []map[string]*dynamodb.AttributeValue{old}, &users, decodeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].FullName != "n" || users[0].Email != "unknown" {
+		t.Errorf("decodeItems失败: 没有升级 %+v", users)
+	}
+	if _, ok := old["FullName"]; ok {
+		t.Error("decodeItems失败: 不应该修改读取到的 item")
+	}
+
+	item, err := rs.marshalItem(ctx, &users[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(item[defaultSchemaVersionAttr].N) != "2" {
+		t.Errorf("marshalItem失败: 没有记录版本 %v", item[defaultSchemaVersionAttr])
+	}
+	item["Email"] = &dynamodb.AttributeValue{S: aws.String("e")}
+	var user schemaTestUser
+	if err := rs.decodeItem(ctx, item, &user, decodeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "e" {
+		t.Errorf("decodeItem失败: 当前版本不应该升级 %+v", user)
+	}
+}
+
+func TestSchemaWriteBack(t *testing.T) {
+	registry := NewSchemaRegistry("")
+	err := registry.Register(schemaTestUser{}, func(item map[string]*dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, error) {
+		item["Email"] = &dynamodb.AttributeValue{S: aws.String("unknown")}
+		return item, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, fake := newFakeService(func(op string, input interface{}) (interface{}, error) {
+		return &dynamodb.PutItemOutput{}, nil
+	}, ServiceSchemaRegistry(registry), ServiceSchemaWriteBack())
+	ctx := context.TODO()
+	old := map[string]*dynamodb.AttributeValue{
+		"PK":       {S: aws.String("User#1")},
+		"SK":       {S: aws.String("User")},
+		"FullName": {S: aws.String("n")},
+	}
+
+	var user schemaTestUser
+	if err := rs.decodeItem(ctx, old, &user, decodeOptions{fullItem: true}); err != nil {
+		t.Fatal(err)
+	}
+	calls := fake.waitCalls(1)
+	if len(calls) != 1 {
+		t.Fatalf("writeBack失败: 没有在后台写回 %v", fake.ops())
+	}
+	put := calls[0].input.(*dynamodb.PutItemInput)
+	cond := resolveExpression(put.ConditionExpression, put.ExpressionAttributeNames)
+	for _, name := range []string{"PK", "SK", "FullName"} {
+		if !strings.Contains(cond, name+" = :") {
+			t.Errorf("writeBack失败: 应该以完整的 item 为条件 %s", cond)
+		}
+	}
+	if aws.StringValue(put.Item["Email"].S) != "unknown" {
+		t.Errorf("writeBack失败: 不是预期的 item %v", put.Item)
+	}
+
+	collection := NewCollectionRegistry("").RegisterPrefix("User", schemaTestUser{})
+	var out struct{ Users []schemaTestUser }
+	if err := rs.decodeItems(ctx, []map[string]*dynamodb.AttributeValue{old}, &collectionOut{registry: collection, out: &out}, decodeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Users) != 1 || out.Users[0].Email != "unknown" {
+		t.Errorf("decodeItems失败: QueryCollection 没有升级 %+v", out.Users)
+	}
+	if calls := fake.waitCalls(2); len(calls) != 1 {
+		t.Errorf("decodeItems失败: 不是完整的 item 时不应该写回 %v", fake.ops())
+	}
+}
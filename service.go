@@ -1,6 +1,10 @@
 package rotor
 
 import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -34,6 +38,28 @@ func PrimaryKey(pk, sk string) PrimaryKeyType {
 	}
 }
 
+// itemKey 从 item 中取出 keyNames 对应的主键
+func itemKey(item map[string]*dynamodb.AttributeValue, keyNames []string) (PrimaryKeyType, error) {
+	key := make(PrimaryKeyType, len(keyNames))
+	for _, name := range keyNames {
+		av, ok := item[name]
+		if !ok {
+			return nil, ErrInput
+		}
+		key[name] = av
+	}
+	return key, nil
+}
+
+func attributeNames(item map[string]*dynamodb.AttributeValue) []string {
+	names := make([]string, 0, len(item))
+	for name := range item {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // ConditionItemNotExist ConditionItemNotExist
 func ConditionItemNotExist() expression.ConditionBuilder {
 	return expression.AttributeNotExists(expression.Name(tablePK))
@@ -49,7 +75,7 @@ type BaseSchema struct {
 	PK         string     `dynamodbav:"PK"`
 	SK         string     `dynamodbav:"SK"`
 	Version    string     `dynamodbav:",omitempty"`
-	CreateTime int64      `dynamodbav:",omitempty"`
+	CreateTime int64      `dynamodbav:",omitempty" rotor:"insertonly"`
 	UpdateTime int64      `dynamodbav:",omitempty"`
 	ExpireTime *time.Time `dynamodbav:",unixtime,omitempty"`
 }
@@ -81,6 +107,33 @@ type Service struct {
 	dynamo    *dynamodb.DynamoDB
 	codec     Codec
 	tableName *string
+
+	// index name => key attribute names, 表的主键对应空字符串
+	keySchema  sync.Map
+	cursorRing *CursorKeyRing
+	blobStore  BlobStore
+
+	schemas         *SchemaRegistry
+	schemaWriteBack *writeBackQueue
+
+	strict bool
+}
+
+// ServiceOption ServiceOption
+type ServiceOption func(rs *Service)
+
+// ServiceCursorKeyRing 使用 ring 签名/加密 QueryPageCursor 返回的游标
+func ServiceCursorKeyRing(ring *CursorKeyRing) ServiceOption {
+	return func(rs *Service) {
+		rs.cursorRing = ring
+	}
+}
+
+// ServiceCodec 使用自定义的 Codec, 例如注册了 Converter 的 Codec
+func ServiceCodec(codec Codec) ServiceOption {
+	return func(rs *Service) {
+		rs.codec = codec
+	}
 }
 
 // TableName TableName
@@ -89,10 +142,138 @@ func (rs *Service) TableName() string {
 }
 
 // New New service
-func New(sess *session.Session, tableName string) *Service {
-	return &Service{
+func New(sess *session.Session, tableName string, opts ...ServiceOption) *Service {
+	rs := &Service{
 		dynamo:    dynamodb.New(sess),
 		codec:     NewCodec(),
 		tableName: aws.String(tableName),
 	}
+	for _, opt := range opts {
+		opt(rs)
+	}
+	return rs
+}
+
+// keyNames 返回表或索引的主键属性名, 索引的主键包含表的主键
+func (rs *Service) keyNames(ctx context.Context, indexName string) ([]string, error) {
+	if names, ok := rs.keySchema.Load(indexName); ok {
+		return names.([]string), nil
+	}
+	ret, err := rs.dynamo.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: rs.tableName,
+	})
+	if err != nil {
+		return nil, err
+	}
+	tableKeys := keySchemaNames(ret.Table.KeySchema)
+	rs.keySchema.Store("", tableKeys)
+	for _, index := range ret.Table.LocalSecondaryIndexes {
+		rs.keySchema.Store(aws.StringValue(index.IndexName), mergeNames(tableKeys, keySchemaNames(index.KeySchema)))
+	}
+	for _, index := range ret.Table.GlobalSecondaryIndexes {
+		rs.keySchema.Store(aws.StringValue(index.IndexName), mergeNames(tableKeys, keySchemaNames(index.KeySchema)))
+	}
+	names, ok := rs.keySchema.Load(indexName)
+	if !ok {
+		return nil, ErrInput
+	}
+	return names.([]string), nil
+}
+
+func keySchemaNames(schema []*dynamodb.KeySchemaElement) []string {
+	names := make([]string, 0, len(schema))
+	for _, elem := range schema {
+		names = append(names, aws.StringValue(elem.AttributeName))
+	}
+	return names
+}
+
+func mergeNames(a, b []string) []string {
+	seen := make(map[string]struct{}, len(a)+len(b))
+	names := make([]string, 0, len(a)+len(b))
+	for _, name := range append(append([]string{}, a...), b...) {
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// decodeOptions 读取 item 时的选项
+type decodeOptions struct {
+	skipBlobs bool
+	// partial 使用了 projection, fullItem 读到的是完整的 item, 只有完整的 item 可以写回
+	partial  bool
+	fullItem bool
+	// strict 单次调用覆盖 Service 的严格模式
+	strict *bool
+}
+
+// decodeItem 解码读取到的 item, 所有读取路径都经过这里
+func (rs *Service) decodeItem(ctx context.Context, item map[string]*dynamodb.AttributeValue, out interface{}, dopts decodeOptions) error {
+	item, err := rs.upgradeItem(ctx, reflect.TypeOf(out), item, dopts)
+	if err != nil {
+		return err
+	}
+	if item, err = rs.hydrateBlobs(ctx, item, dopts.skipBlobs); err != nil {
+		return err
+	}
+	av := &dynamodb.AttributeValue{M: item}
+	if err := rs.checkStrict(av, out, dopts); err != nil {
+		return err
+	}
+	if err := rs.codec.decode(av, out); err != nil {
+		return err
+	}
+	// PutOut 等没有旧 item 时不算读取到了 item
+	if len(item) == 0 {
+		return nil
+	}
+	return afterLoad(ctx, reflect.ValueOf(out))
+}
+
+// decodeItems 解码读取到的多个 item, out 可以是 QueryCollection 的 collectionOut
+func (rs *Service) decodeItems(ctx context.Context, items []map[string]*dynamodb.AttributeValue, out interface{}, dopts decodeOptions) error {
+	c, isCollection := out.(*collectionOut)
+	decoded := make([]map[string]*dynamodb.AttributeValue, len(items))
+	for i, item := range items {
+		t := elemType(out)
+		if isCollection {
+			// 按注册的类型升级, 没有注册的 item 原样保留
+			t, _ = c.registry.resolve(item)
+		}
+		var err error
+		if item, err = rs.upgradeItem(ctx, t, item, dopts); err != nil {
+			return err
+		}
+		if decoded[i], err = rs.hydrateBlobs(ctx, item, dopts.skipBlobs); err != nil {
+			return err
+		}
+		if isCollection && t != nil {
+			if err := rs.checkStrictType(&dynamodb.AttributeValue{M: decoded[i]}, indirectType(t), dopts); err != nil {
+				return err
+			}
+		}
+	}
+	if isCollection {
+		if err := c.registry.Decode(rs.codec, decoded, c.out); err != nil {
+			return err
+		}
+		return afterLoadAll(ctx, c.out)
+	}
+	list := make([]*dynamodb.AttributeValue, len(decoded))
+	for i, item := range decoded {
+		list[i] = &dynamodb.AttributeValue{M: item}
+	}
+	av := &dynamodb.AttributeValue{L: list}
+	if err := rs.checkStrict(av, out, dopts); err != nil {
+		return err
+	}
+	if err := rs.codec.decode(av, out); err != nil {
+		return err
+	}
+	return afterLoadAll(ctx, out)
 }
@@ -0,0 +1,346 @@
+package rotor
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Validator 写入前由 Put/PutBatch/Transact/Upsert/Patch/UpdateDiff 调用
+// 在 `rotor` tag 的规则都通过之后才会调用; 嵌套的结构体, slice 和 map 的元素实现了 Validator 时,
+// 在它自己的规则通过之后调用, 错误记录为这个路径上 validate 规则的 ValidationFieldError
+type Validator interface {
+	Validate() error
+}
+
+var validatorType = reflect.TypeOf((*Validator)(nil)).Elem()
+
+// ValidationFieldError 一个字段的校验问题
+type ValidationFieldError struct {
+	// Path 属性路径, 例如 Profile.Tags[2]
+	Path string
+	// Rule 没有通过的规则: required, min, max, regex, enum, 嵌套值的 Validate() 为 validate
+	Rule   string
+	Reason string
+}
+
+// ValidationError 写入前校验失败, errors.Is(err, ErrValidation) 成立
+type ValidationError struct {
+	Errors []ValidationFieldError
+	// Err Validate() 返回的错误
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors)+1)
+	for _, fe := range e.Errors {
+		msgs = append(msgs, fe.Path+": "+fe.Reason)
+	}
+	if e.Err != nil {
+		msgs = append(msgs, e.Err.Error())
+	}
+	return ErrValidation.Error() + ": " + strings.Join(msgs, "; ")
+}
+
+// Is Is
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// Unwrap 返回 Validate() 的错误
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// validateRules 一个字段的规则, 来自 `rotor:"required,min=1,max=32,enum=a|b,regex=^[a-z]+$"`
+// min/max 对字符串按字符数, 对 slice/map 按长度, 对数字按值比较; regex 只能是最后一个选项
+type validateRules struct {
+	required bool
+	min, max *float64
+	regex    *regexp.Regexp
+	enum     []string
+}
+
+type validateField struct {
+	field
+	rules *validateRules
+}
+
+var validateCache sync.Map // reflect.Type => []validateField
+
+// validateFields 结构体的字段和它们的规则, tag 写错时返回 ErrInput
+func validateFields(t reflect.Type) ([]validateField, error) {
+	if cached, ok := validateCache.Load(t); ok {
+		return cached.([]validateField), nil
+	}
+	fields := structFields(t)
+	vfs := make([]validateField, len(fields))
+	for i, f := range fields {
+		rules, err := parseValidateRules(f)
+		if err != nil {
+			return nil, err
+		}
+		vfs[i] = validateField{field: f, rules: rules}
+	}
+	cached, _ := validateCache.LoadOrStore(t, vfs)
+	return cached.([]validateField), nil
+}
+
+func parseValidateRules(f field) (*validateRules, error) {
+	var rules validateRules
+	var set bool
+	kind := indirectType(f.Type).Kind()
+	invalid := func(rule string) error {
+		return fmt.Errorf("%w: rule %s of field %s is invalid for %s", ErrInput, rule, f.Name, f.Type)
+	}
+	if f.Rotor.Has("required") {
+		rules.required, set = true, true
+	}
+	for _, rule := range []string{"min", "max"} {
+		s, ok := f.Rotor[rule]
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil || !(kind == reflect.String || hasLength(kind) || isNumberKind(kind)) {
+			return nil, invalid(rule)
+		}
+		if rule == "min" {
+			rules.min = &n
+		} else {
+			rules.max = &n
+		}
+		set = true
+	}
+	if s, ok := f.Rotor["regex"]; ok {
+		re, err := regexp.Compile(s)
+		if err != nil || kind != reflect.String {
+			return nil, invalid("regex")
+		}
+		rules.regex, set = re, true
+	}
+	if s, ok := f.Rotor["enum"]; ok {
+		if kind != reflect.String && !isNumberKind(kind) {
+			return nil, invalid("enum")
+		}
+		rules.enum, set = strings.Split(s, "|"), true
+	}
+	if !set {
+		return nil, nil
+	}
+	return &rules, nil
+}
+
+func hasLength(kind reflect.Kind) bool {
+	return kind == reflect.Slice || kind == reflect.Array || kind == reflect.Map
+}
+
+func isNumberKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// Validate 按 `rotor` tag 的规则校验 in, 都通过后调用 in 的 Validate() 方法
+// 有问题时返回 *ValidationError, Service 的写入路径在编码前会自动调用
+func Validate(in interface{}) error {
+	return validateItem(in, false)
+}
+
+// validateItem partial 为 true 时 in 是只包含部分字段的 patch, 忽略顶层的零值字段
+func validateItem(in interface{}, partial bool) error {
+	v := reflect.ValueOf(in)
+	var errs []ValidationFieldError
+	if err := validateValue(v, "", partial, &errs); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	hv, ok := hookValue(v, validatorType)
+	if !ok {
+		return nil
+	}
+	err := hv.Interface().(Validator).Validate()
+	if err == nil {
+		return nil
+	}
+	if verr, ok := err.(*ValidationError); ok {
+		return verr
+	}
+	return &ValidationError{Err: err}
+}
+
+func validateValue(v reflect.Value, path string, partial bool, errs *[]ValidationFieldError) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	t := v.Type()
+	switch v.Kind() {
+	case reflect.Struct:
+		if isTimeType(t) || isProtoType(t) {
+			return nil
+		}
+		n := len(*errs)
+		if err := validateStruct(v, path, partial, errs); err != nil {
+			return err
+		}
+		// 顶层的 Validate() 由 validateItem 调用
+		if path != "" && len(*errs) == n {
+			validateNested(v, path, errs)
+		}
+	case reflect.Slice, reflect.Array:
+		if indirectType(t.Elem()).Kind() != reflect.Struct && t.Elem().Kind() != reflect.Interface {
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := validateValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), false, errs); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String || (indirectType(t.Elem()).Kind() != reflect.Struct && t.Elem().Kind() != reflect.Interface) {
+			return nil
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := validateValue(iter.Value(), keyPath(path, iter.Key().String()), false, errs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateNested 调用嵌套值的 Validate(), *ValidationError 的路径加上 path 前缀
+func validateNested(v reflect.Value, path string, errs *[]ValidationFieldError) {
+	hv, ok := hookValue(v, validatorType)
+	if !ok {
+		return
+	}
+	err := hv.Interface().(Validator).Validate()
+	if err == nil {
+		return
+	}
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		*errs = append(*errs, ValidationFieldError{Path: path, Rule: "validate", Reason: err.Error()})
+		return
+	}
+	for _, fe := range verr.Errors {
+		if fe.Path == "" || strings.HasPrefix(fe.Path, "[") {
+			fe.Path = path + fe.Path
+		} else {
+			fe.Path = joinPath(path, fe.Path)
+		}
+		*errs = append(*errs, fe)
+	}
+	if verr.Err != nil {
+		*errs = append(*errs, ValidationFieldError{Path: path, Rule: "validate", Reason: verr.Err.Error()})
+	}
+}
+
+func validateStruct(v reflect.Value, path string, partial bool, errs *[]ValidationFieldError) error {
+	fields, err := validateFields(v.Type())
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		fv, ok := fieldByIndex(v, f.Index)
+		if partial && (!ok || fv.IsZero()) {
+			continue
+		}
+		fpath := joinPath(path, f.Name)
+		if f.rules != nil {
+			f.rules.check(fv, ok, fpath, errs)
+		}
+		if ok {
+			if err := validateValue(fv, fpath, false, errs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// check ok 为 false 时字段所在的匿名结构体指针为 nil
+// Patch 中的 PatchNull 会删除属性, 与空值相同
+func (r *validateRules) check(v reflect.Value, ok bool, path string, errs *[]ValidationFieldError) {
+	fail := func(rule, reason string) {
+		*errs = append(*errs, ValidationFieldError{Path: path, Rule: rule, Reason: reason})
+	}
+	removed := ok && isPatchNull(v)
+	if !ok || removed || v.IsZero() {
+		if r.required {
+			fail("required", "required field is empty")
+		}
+		if !ok || removed {
+			return
+		}
+	}
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	if r.min != nil || r.max != nil {
+		n, what := validateSize(v)
+		if r.min != nil && n < *r.min {
+			fail("min", fmt.Sprintf("%s %v is less than min %v", what, n, *r.min))
+		}
+		if r.max != nil && n > *r.max {
+			fail("max", fmt.Sprintf("%s %v is greater than max %v", what, n, *r.max))
+		}
+	}
+	if r.regex != nil && !r.regex.MatchString(v.String()) {
+		fail("regex", fmt.Sprintf("%q does not match %s", v.String(), r.regex))
+	}
+	if r.enum != nil {
+		s := formatEnum(v)
+		for _, e := range r.enum {
+			if s == e {
+				return
+			}
+		}
+		fail("enum", fmt.Sprintf("%q is not one of %s", s, strings.Join(r.enum, "|")))
+	}
+}
+
+// validateSize 字符串的字符数, slice/map 的长度或者数字的值
+func validateSize(v reflect.Value) (float64, string) {
+	switch v.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), "length"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), "length"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), "value"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), "value"
+	}
+	return v.Float(), "value"
+}
+
+func formatEnum(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10)
+	}
+	return strconv.FormatFloat(v.Float(), 'g', -1, 64)
+}
@@ -0,0 +1,191 @@
+package rotor
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+var errValidateAge = errors.New("age must be set for admins")
+
+type validateTestAddress struct {
+	City string `rotor:"required,max=8"`
+}
+
+type validateTestUser struct {
+	BaseSchema
+
+	Name      string   `rotor:"required,min=2,max=4"`
+	Role      string   `rotor:"enum=admin|user"`
+	Age       *int     `rotor:"min=0,max=150"`
+	Level     int      `rotor:"enum=1|2|3"`
+	Tags      []string `rotor:"max=2"`
+	Email     string   `rotor:"regex=^[a-z]{1,8}@[a-z]+\\.com$"`
+	Addresses []validateTestAddress
+	Contacts  map[string]validateTestAddress
+}
+
+func (u *validateTestUser) Validate() error {
+	if u.Role == "admin" && u.Age == nil {
+		return errValidateAge
+	}
+	return nil
+}
+
+func newValidateTestUser() validateTestUser {
+	u := validateTestUser{
+		Name:      "名字",
+		Role:      "user",
+		Level:     1,
+		Email:     "a@b.com",
+		Addresses: []validateTestAddress{{City: "c"}},
+	}
+	u.PK, u.SK = "User#1", "User"
+	return u
+}
+
+func TestValidate(t *testing.T) {
+	if err := Validate(newValidateTestUser()); err != nil {
+		t.Fatal(err)
+	}
+
+	u := newValidateTestUser()
+	u.Name = "n"
+	u.Role = "guest"
+	u.Age = aws.Int(-1)
+	u.Level = 4
+	u.Tags = []string{"a", "b", "c"}
+	u.Email = "a,b@b.com"
+	u.Addresses = append(u.Addresses, validateTestAddress{}, validateTestAddress{City: "123456789"})
+	u.Contacts = map[string]validateTestAddress{"a.b": {}}
+	err := Validate(&u)
+	var verr *ValidationError
+	if !errors.As(err, &verr) || !errors.Is(err, ErrValidation) {
+		t.Fatalf("Validate失败: 不是 ValidationError %v", err)
+	}
+	var got []string
+	for _, fe := range verr.Errors {
+		got = append(got, fe.Path+" "+fe.Rule)
+	}
+	expect := []string{"Name min", "Role enum", "Age min", "Level enum", "Tags max", "Email regex", "Addresses[1].City required", "Addresses[2].City max", `Contacts["a.b"].City required`}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Validate失败: 不是预期的错误 %v", got)
+	}
+
+	u = newValidateTestUser()
+	u.Role = "admin"
+	if err := Validate(u); !errors.Is(err, errValidateAge) || !errors.Is(err, ErrValidation) {
+		t.Errorf("Validate失败: 应该返回 Validate() 的错误 %v", err)
+	}
+
+	type badRegex struct {
+		Name string `rotor:"regex=("`
+	}
+	if err := Validate(badRegex{}); !errors.Is(err, ErrInput) {
+		t.Errorf("Validate失败: 错误的 tag 应该返回 ErrInput %v", err)
+	}
+}
+
+func TestServiceValidate(t *testing.T) {
+	rs := &Service{codec: NewCodec(), tableName: aws.String("test")}
+	ctx := context.TODO()
+
+	u := newValidateTestUser()
+	u.Name = ""
+	if _, err := rs.marshalItem(ctx, &u); !errors.Is(err, ErrValidation) {
+		t.Errorf("marshalItem失败: 应该在编码前校验 %v", err)
+	}
+	if err := rs.Upsert(ctx, &u); !errors.Is(err, ErrValidation) {
+		t.Errorf("Upsert失败: 应该在编码前校验 %v", err)
+	}
+
+	// patch 只校验设置了的字段
+	if err := validateItem(&validateTestUser{Role: "user"}, true); err != nil {
+		t.Errorf("validateItem失败: patch 不应该检查没有设置的字段 %v", err)
+	}
+	if err := rs.Patch(ctx, PrimaryKey("User#1", "User"), &validateTestUser{Name: "n"}); !errors.Is(err, ErrValidation) {
+		t.Errorf("Patch失败: 应该校验设置了的字段 %v", err)
+	}
+}
+
+func TestValidatePatchNull(t *testing.T) {
+	type patch struct {
+		Name  interface{} `rotor:"required"`
+		Email interface{}
+	}
+	err := validateItem(&patch{Name: PatchNull{}}, true)
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "Name" || verr.Errors[0].Rule != "required" {
+		t.Errorf("validateItem失败: 删除必填字段应该返回 required 错误 %v", err)
+	}
+	if err := validateItem(&patch{Name: "n", Email: PatchNull{}}, true); err != nil {
+		t.Errorf("validateItem失败: 可以删除非必填字段 %v", err)
+	}
+}
+
+type validateTestRange struct {
+	From int `rotor:"min=0"`
+	To   int
+}
+
+func (r validateTestRange) Validate() error {
+	if r.From > r.To {
+		return errors.New("from is after to")
+	}
+	return nil
+}
+
+type validateTestLine struct {
+	Sku string
+}
+
+func (l *validateTestLine) Validate() error {
+	if l.Sku == "" {
+		return &ValidationError{Errors: []ValidationFieldError{{Path: "Sku", Rule: "required", Reason: "sku is empty"}}}
+	}
+	return nil
+}
+
+func TestValidateNested(t *testing.T) {
+	type order struct {
+		Period *validateTestRange
+		Lines  []validateTestLine
+		ByName map[string]*validateTestLine
+	}
+	ok := order{
+		Period: &validateTestRange{From: 1, To: 2},
+		Lines:  []validateTestLine{{Sku: "a"}},
+		ByName: map[string]*validateTestLine{"a": {Sku: "a"}},
+	}
+	if err := Validate(ok); err != nil {
+		t.Fatal(err)
+	}
+
+	bad := order{
+		Period: &validateTestRange{From: 3, To: 2},
+		Lines:  []validateTestLine{{Sku: "a"}, {}},
+		ByName: map[string]*validateTestLine{"a": {}},
+	}
+	err := Validate(bad)
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Validate失败: 不是 ValidationError %v", err)
+	}
+	var got []string
+	for _, fe := range verr.Errors {
+		got = append(got, fe.Path+" "+fe.Rule)
+	}
+	expect := []string{"Period validate", "Lines[1].Sku required", "ByName.a.Sku required"}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Validate失败: 不是预期的错误 %v", got)
+	}
+
+	// tag 规则没有通过时不调用 Validate()
+	bad = order{Period: &validateTestRange{From: -1, To: -2}}
+	if err := Validate(bad); !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Rule != "min" {
+		t.Errorf("Validate失败: 不是预期的错误 %v", err)
+	}
+}